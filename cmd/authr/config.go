@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+)
+
+// validateConfig implements `authr config validate --config=<file>` for CI,
+// it prints all problems of the config and returns exit code
+func validateConfig(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	configFile := flags.String("config", "./config.yml", "config file path")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	_, err := authrlib.LoadConfig(*configFile)
+	if err == nil {
+		fmt.Fprintf(out, "%s is valid\n", *configFile)
+		return 0
+	}
+	fmt.Fprintf(out, "%s is invalid:\n", *configFile)
+	if validationErr, ok := err.(*authrlib.ValidationError); ok {
+		for _, problem := range validationErr.Problems {
+			fmt.Fprintf(out, "  %s\n", problem)
+		}
+	} else {
+		fmt.Fprintf(out, "  %v\n", err)
+	}
+	return 1
+}
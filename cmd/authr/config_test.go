@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConfig(t *testing.T) {
+	file, err := ioutil.TempFile(os.TempDir(), "cfg")
+	if err != nil {
+		t.Fatal("unable to create tmp file for test", err)
+	}
+	defer os.Remove(file.Name())
+	if err = ioutil.WriteFile(file.Name(), []byte("app:\n  name: \"authr\"\n  env: \"prod\"\n"), 0644); err != nil {
+		t.Fatal("unable to write test data into file", err)
+	}
+
+	testCases := []struct {
+		name string
+		args []string
+		code int
+		out  []string
+	}{
+		{name: "valid", args: []string{"--config=../../configs/authr-config.yml"}, code: 0,
+			out: []string{"../../configs/authr-config.yml is valid\n"}},
+		{name: "invalid", args: []string{"--config=" + file.Name()}, code: 1,
+			out: []string{file.Name() + " is invalid:\n", `  app.env: unsupported value "prod"`, "  app.port: must be between 1 and 65535, got 0\n"}},
+		{name: "not found", args: []string{"--config=/tmp/fake/config.yml"}, code: 1,
+			out: []string{"  unable to find application configuration file: /tmp/fake/config.yml\n"}},
+		{name: "unknown flag", args: []string{"--db=fixture"}, code: 2, out: []string{"flag provided but not defined: -db"}},
+	}
+	for _, testCase := range testCases {
+		var out bytes.Buffer
+		assert.Equal(t, testCase.code, validateConfig(testCase.args, &out), testCase.name)
+		for _, line := range testCase.out {
+			assert.Contains(t, out.String(), line, testCase.name)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
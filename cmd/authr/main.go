@@ -1,25 +1,73 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"net/http"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"bitbucket.org/teachingstrategies/authorization-service/internal/app/authr"
 	"bitbucket.org/teachingstrategies/authorization-service/internal/app/authr/access"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/app/authr/debug"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/app/authr/keys"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/app/authr/probes"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/app/authr/webhook"
 	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
 	"bitbucket.org/teachingstrategies/go-svc-bootstrap/health"
 
 	_ "github.com/denisenkom/go-mssqldb"
+	_ "github.com/lib/pq"
 )
 
+// max time to send collected data to new relic on shutdown
+const newRelicFlushTimeout = 10 * time.Second
+
 func main() {
+	// `authr config validate --config=<file>` checks config and exits
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "validate" {
+		os.Exit(validateConfig(os.Args[3:], os.Stdout))
+	}
+
 	configFile := flag.String("config", "./config.yml", "config file path")
+	dbBackend := flag.String("db", "", "overrides db backend of config file, `fixture` serves access from fixtures file without db")
+	fixturesFile := flag.String("fixtures", "", "overrides fixtures file of fixture db backend")
+	printConfig := flag.Bool("print-config", false, "prints effective config with secrets masked and exits")
 	flag.Parse()
 
 	ctx := new(authrlib.AppContext)
 
-	ctx.ConfigService = authrlib.NewApplicationConfigService(configFile)
+	// flags override config file, so they are validated with it
+	ctx.ConfigService = authrlib.NewApplicationConfigService(configFile, func(config *authrlib.Config) {
+		if *dbBackend != "" {
+			config.MsSQL.Backend = *dbBackend
+		}
+		if *fixturesFile != "" {
+			config.MsSQL.FixturesFile = *fixturesFile
+		}
+	})
+	if *printConfig {
+		masked, err := ctx.ConfigService.Config().MaskedYAML()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "unable to print config:", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(masked)
+		return
+	}
 	ctx.Logger = authrlib.NewLogger(ctx.ConfigService.IsProduction())
+	if err := authrlib.SetLogLevel(ctx.ConfigService.Config().App.LogLevel); err != nil {
+		ctx.Logger.Fatal().Err(err).Msg("unable to set log level")
+	}
+	ctx.ConfigService.Subscribe(func(old, new *authrlib.Config) {
+		if new.App.LogLevel != old.App.LogLevel {
+			authrlib.SetLogLevel(new.App.LogLevel)
+			ctx.Logger.Info().Str("level", new.App.LogLevel).Msg("log level reloaded")
+		}
+	})
 
 	ctx.Logger.Info().Msg("initializing authorization service")
 
@@ -32,25 +80,103 @@ func main() {
 
 	// Init healthchecks
 	ctx.Healthchecks = health.NewHealthCheckCollection()
+	ctx.Probes = authrlib.NewProbes(ctx)
+
+	// initialize db, startup fails when db is not reachable after retries
+	// fixture db backend keeps data in memory and needs no db
+	if ctx.ConfigService.Config().MsSQL.DriverName() != authrlib.BackendFixture {
+		ctx.DbManager, err = authrlib.NewDbManager(ctx)
+		if err != nil {
+			ctx.Logger.Fatal().Err(err).Msg("unable to connect db")
+		}
+	} else {
+		ctx.Logger.Warn().Str("fixtures", ctx.ConfigService.Config().MsSQL.FixturesFile).Msg("access is served from fixtures")
+	}
+
+	// initialize key ring signing issued tokens, keys are reloaded with config
+	keyRing, err := authrlib.NewKeyRing(ctx)
+	if err != nil {
+		ctx.Logger.Fatal().Err(err).Msg("unable to load token signing keys")
+	}
+	if keyRing != nil {
+		ctx.TokenSigner = keyRing
+	}
 
-	// initialize db
-	ctx.DbManager, err = authrlib.NewDbManager(ctx)
+	// initialize audit trail of access lookups, it's written apart from application log
+	ctx.AuditSink, err = authrlib.NewAuditSink(ctx)
 	if err != nil {
-		ctx.Logger.Fatal().Err(err).Msg("unable to connect mssql")
+		ctx.Logger.Fatal().Err(err).Msg("unable to initialize audit")
 	}
-	defer ctx.DbManager.Release()
+
+	// initialize local copy of keys server keys verifying caller tokens
+	ctx.KeySet = authrlib.NewKeySet(ctx)
 
 	// handler for /access router ( should be moved to router ?)
-	ctx.AccessHandler = access.NewAccessHandler(ctx)
+	history := access.NewHistoryStore(ctx)
+	// notifications of changed access, published in background
+	webhooks, err := webhook.NewPublisher(ctx)
+	if err != nil {
+		ctx.Logger.Fatal().Err(err).Msg("unable to initialize webhooks")
+	}
+	accessService := access.NewAccessService(ctx, history, webhooks)
+	ctx.AccessHandler = access.NewAccessHandler(ctx, accessService)
 	ctx.AccessValidationMiddlewares = access.NewAccessValidationMiddlewares(ctx)
+	ctx.DelegatedAccessValidationMiddlewares = access.NewDelegatedAccessValidationMiddlewares(ctx, accessService)
+	ctx.AccessCheckHandler = access.NewAccessCheckHandler(ctx, accessService)
+	ctx.BatchAccessHandler = access.NewBatchAccessHandler(ctx, accessService)
+	ctx.BatchAccessValidationMiddlewares = access.NewBatchAccessValidationMiddlewares(ctx)
+	ctx.CacheInvalidationHandler = access.NewCacheInvalidationHandler(ctx, accessService)
+	ctx.CacheInvalidationValidationMiddlewares = access.NewCacheInvalidationValidationMiddlewares(ctx)
+	ctx.TokenHandler = access.NewTokenHandler(ctx, accessService)
+	ctx.AccessHistoryHandler = access.NewHistoryHandler(ctx, history)
+	ctx.JWKSHandler = keys.NewJWKSHandler(ctx)
+	ctx.DBStatsHandler = debug.NewDBStatsHandler(ctx)
+	ctx.DebugValidationMiddlewares = access.NewDebugValidationMiddlewares(ctx)
+	ctx.LivenessHandler = probes.NewLivenessHandler(ctx)
+	ctx.ReadinessHandler = probes.NewReadinessHandler(ctx)
+
+	// config is reloaded on SIGHUP and when config file changes, components are notified by their subscriptions
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go authrlib.WatchConfig(ctx, *configFile, reload, ctx.ConfigService.Config().App.ConfigWatchInterval)
 
 	// Initialize router
 	router := authr.CreateRouter(ctx)
 
-	port := ctx.ConfigService.Config().App.PortToStr()
-	ctx.Logger.Info().Str("port", port).Msg("starting server")
+	// resources are released in order once server is shut down, until shutdown deadline
+	server := authrlib.NewServer(ctx, router)
+	if ctx.DbManager != nil {
+		server.OnShutdown("db", func(context.Context) error {
+			ctx.DbManager.Release()
+			return nil
+		})
+	}
+	if cache, ok := accessService.(io.Closer); ok {
+		server.OnShutdown("cache", func(context.Context) error { return cache.Close() })
+	}
+	if history != nil {
+		server.OnShutdown("history", func(context.Context) error { return history.Close() })
+	}
+	server.OnShutdown("newrelic", func(shutdown context.Context) error {
+		timeout := newRelicFlushTimeout
+		if deadline, ok := shutdown.Deadline(); ok && time.Until(deadline) < timeout {
+			timeout = time.Until(deadline)
+		}
+		ctx.NewRelicService.Application().Shutdown(timeout)
+		return nil
+	})
+	if ctx.AuditSink != nil {
+		server.OnShutdown("audit", func(context.Context) error { return ctx.AuditSink.Close() })
+	}
+	if webhooks != nil {
+		server.OnShutdown("webhooks", webhooks.Close)
+	}
 
-	if err = http.ListenAndServe(":"+port, router); err != nil {
-		ctx.Logger.Fatal().Err(err).Msg("unable to start a server")
+	ctx.Logger.Info().Str("port", ctx.ConfigService.Config().App.PortToStr()).Msg("starting server")
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	if err = server.Run(stop); err != nil {
+		ctx.Logger.Fatal().Err(err).Msg("server failed")
 	}
+	ctx.Logger.Info().Msg("authorization service stopped")
 }
@@ -0,0 +1,41 @@
+package access
+
+import (
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+)
+
+// Cache keeps converted access documents by user ID
+type Cache interface {
+	// returns cached access and true if entry exists and is not expired
+	Get(int) (*authorization.Access, bool)
+	// stores access of the user
+	Set(int, *authorization.Access)
+	// removes access of the user
+	Delete(int)
+}
+
+// in-process Cache with TTL and LRU eviction
+type lruCache struct {
+	*lru
+}
+
+func newLRUCache(ttl time.Duration, maxSize int) *lruCache {
+	return &lruCache{newLRU(ttl, maxSize)}
+}
+
+func (c *lruCache) Get(userID int) (*authorization.Access, bool) {
+	if access, ok := c.get(userID); ok {
+		return access.(*authorization.Access), true
+	}
+	return nil, false
+}
+
+func (c *lruCache) Set(userID int, access *authorization.Access) {
+	c.set(userID, access)
+}
+
+func (c *lruCache) Delete(userID int) {
+	c.delete(userID)
+}
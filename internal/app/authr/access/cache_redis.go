@@ -0,0 +1,211 @@
+package access
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/go-redis/redis"
+)
+
+// cacheSchemaVersion has to be increased whenever the way cached documents are encoded changes
+const cacheSchemaVersion = 1
+
+// cacheSchema is a part of every redis key, it includes hash of authorization.Access fields and tags,
+// so replicas built with different versions of bootstrap module never read each other's documents
+var cacheSchema = fmt.Sprintf("v%d-%s", cacheSchemaVersion, typeHash(reflect.TypeOf(authorization.Access{})))
+
+// defaults of shared cache
+const (
+	defaultRedisKeyPrefix = "authr:access"
+	defaultRedisTTL       = 15 * time.Minute
+	defaultRedisTimeout   = 100 * time.Millisecond
+)
+
+// shared Cache stored in redis; any redis failure is logged and reported as a miss,
+// so lookups fall back to the database
+type redisCache struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	ttl    time.Duration
+	prefix string
+	logger *authrlib.AppLogger
+}
+
+func newRedisCache(config authrlib.RedisConfig, logger *authrlib.AppLogger) *redisCache {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+	cache := &redisCache{
+		client: redis.NewClient(&redis.Options{
+			Addr:         config.Address,
+			Password:     config.Password,
+			DB:           config.DB,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+		}),
+		ttl:    config.TTL,
+		prefix: config.KeyPrefix,
+		logger: logger,
+	}
+	if cache.ttl <= 0 {
+		cache.ttl = defaultRedisTTL
+	}
+	if cache.prefix == "" {
+		cache.prefix = defaultRedisKeyPrefix
+	}
+	return cache
+}
+
+func (c *redisCache) Get(userID int) (*authorization.Access, bool) {
+	bytes, err := c.client.Get(c.key(userID)).Bytes()
+	if err == redis.Nil {
+		return nil, false
+	}
+	if err != nil {
+		c.logger.Warn().Err(err).Int("user_id", userID).Msg("unable to read access from redis")
+		return nil, false
+	}
+	access := new(authorization.Access)
+	if err = json.Unmarshal(bytes, access); err != nil {
+		c.logger.Warn().Err(err).Int("user_id", userID).Msg("unable to decode access from redis")
+		return nil, false
+	}
+	return access, true
+}
+
+func (c *redisCache) Set(userID int, access *authorization.Access) {
+	bytes, err := json.Marshal(access)
+	if err != nil {
+		c.logger.Warn().Err(err).Int("user_id", userID).Msg("unable to encode access for redis")
+		return
+	}
+	if err = c.client.Set(c.key(userID), bytes, c.ttl).Err(); err != nil {
+		c.logger.Warn().Err(err).Int("user_id", userID).Msg("unable to write access to redis")
+	}
+}
+
+// Delete removes access from redis and notifies other replicas to drop their local copies
+func (c *redisCache) Delete(userID int) {
+	if err := c.client.Del(c.key(userID)).Err(); err != nil {
+		c.logger.Warn().Err(err).Int("user_id", userID).Msg("unable to delete access from redis")
+	}
+	if err := c.client.Publish(c.channel(), strconv.Itoa(userID)).Err(); err != nil {
+		c.logger.Warn().Err(err).Int("user_id", userID).Msg("unable to publish access invalidation")
+	}
+}
+
+// subscribe calls onInvalidate for every user ID published by Delete of any replica
+func (c *redisCache) subscribe(onInvalidate func(int)) {
+	c.pubsub = c.client.Subscribe(c.channel())
+	go func(messages <-chan *redis.Message) {
+		for msg := range messages {
+			userID, err := strconv.Atoi(msg.Payload)
+			if err != nil {
+				c.logger.Warn().Err(err).Str("payload", msg.Payload).Msg("invalid access invalidation message")
+				continue
+			}
+			onInvalidate(userID)
+		}
+	}(c.pubsub.Channel())
+}
+
+// ping checks that redis is reachable
+func (c *redisCache) ping() error {
+	return c.client.Ping().Err()
+}
+
+// Close stops subscription and releases redis connections
+func (c *redisCache) Close() error {
+	if c.pubsub != nil {
+		if err := c.pubsub.Close(); err != nil {
+			return err
+		}
+	}
+	return c.client.Close()
+}
+
+func (c *redisCache) key(userID int) string {
+	return fmt.Sprintf("%s:%s:%d", c.prefix, cacheSchema, userID)
+}
+
+func (c *redisCache) channel() string {
+	return fmt.Sprintf("%s:%s:invalidate", c.prefix, cacheSchema)
+}
+
+// short hash of names, types and tags of fields of t and nested types
+func typeHash(t reflect.Type) string {
+	var shape strings.Builder
+	describeType(&shape, t)
+	sum := sha256.Sum256([]byte(shape.String()))
+	return hex.EncodeToString(sum[:4])
+}
+
+func describeType(shape *strings.Builder, t reflect.Type) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		shape.WriteString(t.Kind().String() + "(")
+		if t.Kind() == reflect.Map {
+			describeType(shape, t.Key())
+			shape.WriteString(",")
+		}
+		describeType(shape, t.Elem())
+		shape.WriteString(")")
+	case reflect.Struct:
+		shape.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			shape.WriteString(fmt.Sprintf("%s %q ", field.Name, field.Tag))
+			describeType(shape, field.Type)
+			shape.WriteString(";")
+		}
+		shape.WriteString("}")
+	default:
+		shape.WriteString(t.Kind().String())
+	}
+}
+
+// two level Cache, local tier is checked first and filled from the shared one
+type tieredCache struct {
+	local  Cache
+	shared Cache
+}
+
+func (c *tieredCache) Get(userID int) (*authorization.Access, bool) {
+	if access, ok := c.local.Get(userID); ok {
+		return access, true
+	}
+	access, ok := c.shared.Get(userID)
+	if ok {
+		c.local.Set(userID, access)
+	}
+	return access, ok
+}
+
+func (c *tieredCache) Set(userID int, access *authorization.Access) {
+	c.local.Set(userID, access)
+	c.shared.Set(userID, access)
+}
+
+func (c *tieredCache) Delete(userID int) {
+	c.local.Delete(userID)
+	c.shared.Delete(userID)
+}
+
+// Close releases connections of shared cache
+func (c *tieredCache) Close() error {
+	if closer, ok := c.shared.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
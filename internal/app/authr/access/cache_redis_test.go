@@ -0,0 +1,121 @@
+package access
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/alicebob/miniredis"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisCache(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal("unable to start miniredis", err)
+	}
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := &authrlib.AppLogger{Logger: zerolog.New(&buf)}
+	cache := newRedisCache(authrlib.RedisConfig{Address: server.Addr(), TTL: time.Minute}, logger)
+	defer cache.Close()
+
+	// miss
+	_, ok := cache.Get(42)
+	assert.False(t, ok)
+
+	// hit, key is versioned
+	access := &authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{1, 2}}}
+	cache.Set(42, access)
+	assert.True(t, server.Exists("authr:access:"+cacheSchema+":42"))
+	cached, ok := cache.Get(42)
+	assert.True(t, ok)
+	assert.Equal(t, access, cached)
+
+	// ttl
+	server.FastForward(time.Minute + time.Second)
+	_, ok = cache.Get(42)
+	assert.False(t, ok)
+
+	// delete, publishing is not supported by miniredis and only logged
+	cache.Set(42, access)
+	cache.Delete(42)
+	assert.False(t, server.Exists("authr:access:"+cacheSchema+":42"))
+	assert.Contains(t, buf.String(), "unable to publish access invalidation")
+
+	// corrupted document
+	buf.Reset()
+	assert.NoError(t, server.Set("authr:access:"+cacheSchema+":43", "{"))
+	_, ok = cache.Get(43)
+	assert.False(t, ok)
+	assert.Contains(t, buf.String(), "unable to decode access from redis")
+
+	// redis is down
+	server.Close()
+	buf.Reset()
+	cache.Set(44, access)
+	_, ok = cache.Get(44)
+	assert.False(t, ok)
+	logs := buf.String()
+	assert.Contains(t, logs, "unable to write access to redis")
+	assert.Contains(t, logs, "unable to read access from redis")
+}
+
+func TestNewRedisCacheDefaults(t *testing.T) {
+	cache := newRedisCache(authrlib.RedisConfig{}, nil)
+	defer cache.Close()
+	assert.Equal(t, defaultRedisTTL, cache.ttl)
+	assert.Equal(t, "authr:access:"+cacheSchema+":7", cache.key(7))
+	assert.True(t, strings.HasSuffix(cache.channel(), ":"+cacheSchema+":invalidate"))
+}
+
+func TestTypeHash(t *testing.T) {
+	type access struct {
+		Teacher *authorization.TeacherType `json:",omitempty"`
+	}
+	type renamed struct {
+		Teacher *authorization.TeacherType `json:"teacher,omitempty"`
+	}
+	type nested struct {
+		Teacher *authorization.TeamMemberType `json:",omitempty"`
+	}
+	assert.Regexp(t, "^v1-[0-9a-f]{8}$", cacheSchema)
+	assert.Equal(t, typeHash(reflect.TypeOf(access{})), typeHash(reflect.TypeOf(access{})))
+	// tags and nested types are a part of the schema
+	assert.NotEqual(t, typeHash(reflect.TypeOf(access{})), typeHash(reflect.TypeOf(renamed{})))
+	assert.NotEqual(t, typeHash(reflect.TypeOf(access{})), typeHash(reflect.TypeOf(nested{})))
+}
+
+func TestTieredCache(t *testing.T) {
+	local, shared := newLRUCache(time.Minute, 10), newLRUCache(time.Minute, 10)
+	cache := &tieredCache{local: local, shared: shared}
+	access := &authorization.Access{}
+
+	// miss
+	_, ok := cache.Get(1)
+	assert.False(t, ok)
+
+	// shared hit fills local tier
+	shared.Set(1, access)
+	cached, ok := cache.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, access, cached)
+	_, ok = local.Get(1)
+	assert.True(t, ok)
+
+	// set and delete reach both tiers
+	cache.Set(2, access)
+	_, inLocal := local.Get(2)
+	_, inShared := shared.Get(2)
+	assert.True(t, inLocal && inShared)
+	cache.Delete(2)
+	_, inLocal = local.Get(2)
+	_, inShared = shared.Get(2)
+	assert.False(t, inLocal || inShared)
+}
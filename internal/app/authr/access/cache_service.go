@@ -0,0 +1,117 @@
+package access
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"golang.org/x/sync/singleflight"
+)
+
+// names of New Relic custom metrics
+const (
+	cacheHitMetric  = "Custom/AccessCache/Hit"
+	cacheMissMetric = "Custom/AccessCache/Miss"
+)
+
+// Invalidator drops cached access of a user
+type Invalidator interface {
+	Invalidate(int)
+}
+
+// Service decorator which serves access from Cache, concurrent lookups
+// of the same user are collapsed into a single db query
+type cachingService struct {
+	Service
+	cache    Cache
+	group    singleflight.Group
+	timeout  time.Duration // limit of a shared lookup
+	newRelic authrlib.NewRelicService
+}
+
+func newCachingService(service Service, cache Cache, timeout time.Duration, newRelic authrlib.NewRelicService) *cachingService {
+	return &cachingService{Service: service, cache: cache, timeout: timeout, newRelic: newRelic}
+}
+
+// Access returns cached access or fetches it from the decorated service,
+// concurrent lookups share a query which isn't bound to any of the callers, so one
+// which gives up doesn't fail the rest
+func (s *cachingService) Access(ctx context.Context, userID int) (*authorization.Access, error) {
+	if access, ok := s.cache.Get(userID); ok {
+		s.record(cacheHitMetric, 1)
+		return access, nil
+	}
+	s.record(cacheMissMetric, 1)
+	shared := s.group.DoChan(strconv.Itoa(userID), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		access, err := s.Service.Access(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		s.cache.Set(userID, access)
+		return access, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, queryError(ctx, ctx.Err())
+	case result := <-shared:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*authorization.Access), nil
+	}
+}
+
+// BatchAccess serves cached users and fetches the rest with a single call of the decorated service
+func (s *cachingService) BatchAccess(ctx context.Context, userIDs []int) (map[int]*batchAccessItem, error) {
+	result := make(map[int]*batchAccessItem, len(userIDs))
+	missed := make([]int, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if access, ok := s.cache.Get(userID); ok {
+			result[userID] = &batchAccessItem{Status: http.StatusOK, Access: access}
+		} else {
+			missed = append(missed, userID)
+		}
+	}
+	s.record(cacheHitMetric, float64(len(result)))
+	s.record(cacheMissMetric, float64(len(missed)))
+	if len(missed) == 0 {
+		return result, nil
+	}
+	fetched, err := s.Service.BatchAccess(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	for userID, item := range fetched {
+		if item.Status == http.StatusOK {
+			s.cache.Set(userID, item.Access)
+		}
+		result[userID] = item
+	}
+	return result, nil
+}
+
+// Invalidate removes user from the cache
+func (s *cachingService) Invalidate(userID int) {
+	s.cache.Delete(userID)
+}
+
+// Close releases connections of cache, e.g. shared one
+func (s *cachingService) Close() error {
+	if closer, ok := s.cache.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (s *cachingService) record(metric string, value float64) {
+	if value == 0 || s.newRelic == nil || s.newRelic.Application() == nil {
+		return
+	}
+	_ = s.newRelic.Application().RecordCustomMetric(metric, value)
+}
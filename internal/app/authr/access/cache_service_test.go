@@ -0,0 +1,152 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachingServiceAccess(t *testing.T) {
+	service := &serviceMock{}
+	cachingService := newCachingService(service, newLRUCache(time.Minute, 10), time.Minute, nil)
+	access := &authorization.Access{SuperUser: true}
+
+	// miss, then hit
+	service.On("Access", 42).Return(access, nil).Once()
+	for i := 0; i < 2; i++ {
+		reply, err := cachingService.Access(context.Background(), 42)
+		assert.NoError(t, err)
+		assert.Equal(t, access, reply)
+	}
+
+	// errors are not cached
+	service.On("Access", 43).Return(nil, errNotFound).Twice()
+	for i := 0; i < 2; i++ {
+		_, err := cachingService.Access(context.Background(), 43)
+		assert.Equal(t, errNotFound, err)
+	}
+
+	// invalidation
+	cachingService.Invalidate(42)
+	service.On("Access", 42).Return(access, nil).Once()
+	_, err := cachingService.Access(context.Background(), 42)
+	assert.NoError(t, err)
+
+	service.AssertExpectations(t)
+}
+
+// Service which blocks lookup until released, used to verify singleflight
+type blockingService struct {
+	serviceMock
+	release chan struct{}
+	calls   int
+	mu      sync.Mutex
+}
+
+func (s *blockingService) Access(ctx context.Context, userID int) (*authorization.Access, error) {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+	<-s.release
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &authorization.Access{}, nil
+}
+
+func TestCachingServiceCollapsesLookups(t *testing.T) {
+	service := &blockingService{release: make(chan struct{})}
+	cachingService := newCachingService(service, newLRUCache(time.Minute, 10), time.Minute, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cachingService.Access(context.Background(), 42)
+			assert.NoError(t, err)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(service.release)
+	wg.Wait()
+	assert.Equal(t, 1, service.calls)
+}
+
+func TestCachingServiceCallerGivesUp(t *testing.T) {
+	service := &blockingService{release: make(chan struct{})}
+	cachingService := newCachingService(service, newLRUCache(time.Minute, 10), time.Minute, nil)
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr, secondErr := make(chan error, 1), make(chan error, 1)
+	go func() {
+		_, err := cachingService.Access(first, 42)
+		firstErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		_, err := cachingService.Access(context.Background(), 42)
+		secondErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the first caller disconnects, the shared lookup goes on for the second one
+	cancel()
+	assert.True(t, errors.Is(<-firstErr, authrerr.New(authrerr.RequestCancelled, "")))
+	close(service.release)
+	assert.NoError(t, <-secondErr)
+	assert.Equal(t, 1, service.calls)
+}
+
+func TestCachingServiceBatchAccess(t *testing.T) {
+	service := &serviceMock{}
+	nr, _ := authrlib.CreateNewRelicService(&authrlib.AppContext{ConfigService: configWithCache(authrlib.CacheConfig{})})
+	cachingService := newCachingService(service, newLRUCache(time.Minute, 10), time.Minute, nr)
+	cached := &authorization.Access{SuperUser: true}
+	cachingService.cache.Set(1, cached)
+
+	// db error
+	service.On("BatchAccess", []int{2, 3}).Return(nil, errors.New("db error")).Once()
+	_, err := cachingService.BatchAccess(context.Background(), []int{1, 2, 3})
+	assert.EqualError(t, err, "db error")
+
+	// only missed users are fetched, successful results are cached
+	fetched := &authorization.Access{}
+	service.On("BatchAccess", []int{2, 3}).Return(map[int]*batchAccessItem{
+		2: {Status: http.StatusOK, Access: fetched},
+		3: {Status: http.StatusNotFound, Message: errNotFound.Error()},
+	}, nil).Once()
+	reply, err := cachingService.BatchAccess(context.Background(), []int{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, cached, reply[1].Access)
+	assert.Equal(t, fetched, reply[2].Access)
+	assert.Equal(t, http.StatusNotFound, reply[3].Status)
+
+	// everything cached except not found user
+	service.On("BatchAccess", []int{3}).Return(map[int]*batchAccessItem{
+		3: {Status: http.StatusNotFound, Message: errNotFound.Error()},
+	}, nil).Once()
+	_, err = cachingService.BatchAccess(context.Background(), []int{1, 2, 3})
+	assert.NoError(t, err)
+
+	// all hits
+	reply, err = cachingService.BatchAccess(context.Background(), []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(reply))
+
+	service.AssertExpectations(t)
+}
+
+func configWithCache(cacheConfig authrlib.CacheConfig) *configServiceMock {
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(&authrlib.Config{Cache: cacheConfig})
+	return mockConfigService
+}
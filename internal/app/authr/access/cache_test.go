@@ -0,0 +1,76 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	cache := newLRUCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+
+	first, second, third := &authorization.Access{}, &authorization.Access{SuperUser: true}, &authorization.Access{}
+
+	// miss
+	_, ok := cache.Get(1)
+	assert.False(t, ok)
+
+	// hit
+	cache.Set(1, first)
+	access, ok := cache.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, first, access)
+
+	// update existing entry
+	cache.Set(1, second)
+	access, _ = cache.Get(1)
+	assert.Equal(t, second, access)
+
+	// least recently used entry is evicted
+	cache.Set(2, first)
+	_, _ = cache.Get(1)
+	cache.Set(3, third)
+	_, ok = cache.Get(2)
+	assert.False(t, ok)
+	_, ok = cache.Get(1)
+	assert.True(t, ok)
+	_, ok = cache.Get(3)
+	assert.True(t, ok)
+	assert.Equal(t, 2, cache.order.Len())
+
+	// delete
+	cache.Delete(3)
+	cache.Delete(42)
+	_, ok = cache.Get(3)
+	assert.False(t, ok)
+
+	// expired entry is removed
+	now = now.Add(time.Minute + time.Second)
+	_, ok = cache.Get(1)
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.order.Len())
+	assert.Empty(t, cache.items)
+
+	// changed ttl applies to entries stored from now on
+	cache.Set(1, first)
+	cache.setTTL(time.Hour)
+	cache.Set(2, second)
+	now = now.Add(30 * time.Minute)
+	_, ok = cache.Get(1)
+	assert.False(t, ok)
+	_, ok = cache.Get(2)
+	assert.True(t, ok)
+}
+
+func BenchmarkLRUCache(b *testing.B) {
+	cache := newLRUCache(time.Minute, 1000)
+	access := &authorization.Access{}
+	for i := 0; i < b.N; i++ {
+		cache.Set(i%2000, access)
+		_, _ = cache.Get(i % 1000)
+	}
+}
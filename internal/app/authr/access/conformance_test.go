@@ -0,0 +1,144 @@
+package access
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	_ "github.com/lib/pq"
+)
+
+// max time to wait until db service of CI pipeline accepts connections
+const conformanceStartTimeout = 2 * time.Minute
+
+// connection strings of disposable dbs the conformance suite runs against, backends without one are skipped
+// locally but fail in CI, so the suite can't be skipped silently there
+var conformanceDSNs = map[string]string{
+	authrlib.BackendMSSQL:    "AUTHR_TEST_MSSQL_DSN",
+	authrlib.BackendPostgres: "AUTHR_TEST_POSTGRES_DSN",
+}
+
+func TestDaoConformance(t *testing.T) {
+	for backend, env := range conformanceDSNs {
+		dsn := os.Getenv(env)
+		if dsn == "" && os.Getenv("CI") != "" {
+			t.Fatalf("%s is not set, %s conformance must run in CI", env, backend)
+		} else if dsn == "" {
+			t.Logf("%s is not set, %s conformance is skipped", env, backend)
+			continue
+		}
+		db, err := sql.Open(backend, dsn)
+		if err != nil {
+			t.Fatalf("unable to open %s db: %v", backend, err)
+		}
+		waitConformanceDB(t, backend, db)
+		seedConformanceFixtures(t, backend, db)
+		t.Run(backend, func(t *testing.T) {
+			testDaoConformance(t, &accessRepo{db: &dbReaderMock{db}, dialect: dialects[backend], timeout: 30 * time.Second})
+		})
+		db.Close()
+	}
+}
+
+// db services are started along with the build, so they may not accept connections yet
+func waitConformanceDB(t *testing.T, backend string, db *sql.DB) {
+	deadline := time.Now().Add(conformanceStartTimeout)
+	for {
+		err := db.Ping()
+		if err == nil {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("unable to connect %s db: %v", backend, err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// runs statements of testdata/conformance/fixtures.sql one by one
+func seedConformanceFixtures(t *testing.T, backend string, db *sql.DB) {
+	script, err := ioutil.ReadFile("testdata/conformance/fixtures.sql")
+	if err != nil {
+		t.Fatalf("unable to read fixtures: %v", err)
+	}
+	statements := []string{}
+	if backend == authrlib.BackendPostgres {
+		statements = append(statements, "CREATE SCHEMA IF NOT EXISTS dbo")
+	}
+	var statement strings.Builder
+	for _, line := range strings.Split(string(script), "\n") {
+		if strings.HasPrefix(line, "--") {
+			continue
+		}
+		statement.WriteString(line + "\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(statement.String()), ";"))
+			statement.Reset()
+		}
+	}
+	for _, statement := range statements {
+		if _, err = db.Exec(statement); err != nil {
+			t.Fatalf("unable to seed %s db: %v\n%s", backend, err, statement)
+		}
+	}
+}
+
+// behavior every Dao backend must have against the seeded fixtures
+func testDaoConformance(t *testing.T, dao Dao) {
+	ctx := context.Background()
+	conv := &accessConverter{authrlib.DefaultRolesConfig()}
+	testCases := []struct {
+		name   string
+		userID int
+		access *authorization.Access
+	}{
+		{name: "teacher and co-teacher", userID: 1, access: &authorization.Access{
+			Teacher:   &authorization.TeacherType{Cls: []int64{101}},
+			CoTeacher: &authorization.TeacherType{Cls: []int64{102}}}},
+		{name: "site admin", userID: 2, access: &authorization.Access{
+			Admin: &authorization.AdminType{Ent: []int64{1011}}}},
+		{name: "team member with accepted invitation", userID: 3, access: &authorization.Access{
+			TeamMember: &authorization.TeamMemberType{Kid: []int64{501}}}},
+		{name: "fund source admin", userID: 4, access: &authorization.Access{
+			FSAdmin: &authorization.FsAdminType{AdminType: authorization.AdminType{Ent: []int64{1021}}, FundSrc: []int64{9001}}}},
+		{name: "superuser", userID: 5, access: &authorization.Access{SuperUser: true}},
+		{name: "unknown user", userID: 999, access: &authorization.Access{}},
+	}
+	for _, testCase := range testCases {
+		rows, err := dao.QueryAccessData(ctx, testCase.userID)
+		assert.NoError(t, err, testCase.name)
+		assert.Equal(t, testCase.access, conv.Convert(ctx, rows), testCase.name)
+		t.Log("test case ok:", testCase.name)
+	}
+
+	// batch groups rows per user and has no rows of unknown users
+	batch, err := dao.QueryBatchAccessData(ctx, []int{1, 2, 999})
+	assert.NoError(t, err)
+	assert.Len(t, batch, 2)
+	assert.Equal(t, testCases[0].access, conv.Convert(ctx, batch[1]))
+	assert.Equal(t, testCases[1].access, conv.Convert(ctx, batch[2]))
+
+	// organization is expanded down to classes, site 12 has no entity
+	hierarchy, err := dao.QueryEntityHierarchy(ctx, []int64{1031}, 3, 100)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{1021, 1011}, hierarchy.Ent)
+	assert.ElementsMatch(t, []int64{101, 102, 103}, hierarchy.Cls)
+	assert.False(t, hierarchy.Truncated)
+
+	// depth and result limits
+	hierarchy, err = dao.QueryEntityHierarchy(ctx, []int64{1031}, 1, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1021}, hierarchy.Ent)
+	assert.Empty(t, hierarchy.Cls)
+	hierarchy, err = dao.QueryEntityHierarchy(ctx, []int64{1021}, 3, 2)
+	assert.NoError(t, err)
+	assert.True(t, hierarchy.Truncated)
+}
@@ -1,18 +1,25 @@
 package access
 
-import "bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+import (
+	"context"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+)
 
 // Converter represent methods for converting db rows to authorization.Access
 type Converter interface {
 	// converting method
-	Convert([]*accessDataRow) *authorization.Access
+	Convert(context.Context, []*accessDataRow) *authorization.Access
 }
 
-// converts db struct into rest result struct
-type accessConverter struct{}
+// converts db struct into rest result struct, CCNET type IDs are resolved by roles mapping
+type accessConverter struct {
+	roles authrlib.RolesConfig
+}
 
 // Convert does the conversion
-func (conv *accessConverter) Convert(rows []*accessDataRow) *authorization.Access {
+func (conv *accessConverter) Convert(_ context.Context, rows []*accessDataRow) *authorization.Access {
 	res := new(authorization.Access)
 	if len(rows) > 0 && rows[0].userTypeID.Valid {
 
@@ -35,7 +42,7 @@ func (*accessConverter) convertSuperUser(rows []*accessDataRow, res *authorizati
 	res.SuperUser = rows[0].superUserTypeID.Int64 != int64(0)
 }
 
-func (*accessConverter) convertTeacher(rows []*accessDataRow, res *authorization.Access, userTypeID int64) {
+func (conv *accessConverter) convertTeacher(rows []*accessDataRow, res *authorization.Access, userTypeID int64) {
 	teachers := make(map[int64]map[int64]interface{})
 	for _, row := range rows {
 		teacherTypeID, class := row.teacherTypeID, row.classID
@@ -48,31 +55,32 @@ func (*accessConverter) convertTeacher(rows []*accessDataRow, res *authorization
 		}
 	}
 
-	if foundTeacher, ok := teachers[1]; (ok && len(foundTeacher) > 0) || userTypeID == 1 { // teacher
+	isTeacher := userTypeID == conv.roles.UserTypes[authrlib.RoleTeacher]
+	if foundTeacher, ok := teachers[conv.roles.TeacherTypes[authrlib.RoleTeacher]]; (ok && len(foundTeacher) > 0) || isTeacher { // teacher
 		res.Teacher = &authorization.TeacherType{Cls: keys(foundTeacher)}
 	}
-	if foundCoTeacher, ok := teachers[2]; ok && len(foundCoTeacher) > 0 && userTypeID == 1 { // co teacher
+	if foundCoTeacher, ok := teachers[conv.roles.TeacherTypes[authrlib.RoleCoTeacher]]; ok && len(foundCoTeacher) > 0 && isTeacher { // co teacher
 		res.CoTeacher = &authorization.TeacherType{Cls: keys(foundCoTeacher)}
 	}
-	if foundAssistant, ok := teachers[3]; ok && len(foundAssistant) > 0 && userTypeID == 1 { // assistant
+	if foundAssistant, ok := teachers[conv.roles.TeacherTypes[authrlib.RoleAssistantTeacher]]; ok && len(foundAssistant) > 0 && isTeacher { // assistant
 		res.AssistantTeacher = &authorization.TeacherType{Cls: keys(foundAssistant)}
 	}
 }
 
-func (*accessConverter) convertTeamMember(rows []*accessDataRow, res *authorization.Access, userTypeID int64) {
+func (conv *accessConverter) convertTeamMember(rows []*accessDataRow, res *authorization.Access, userTypeID int64) {
 	teamMembers := make(map[int64]interface{})
 	for _, row := range rows {
 		if teamChildID := row.teamChildID; teamChildID.Valid {
 			teamMembers[teamChildID.Int64] = struct{}{}
 		}
 	}
-	if userTypeID == 5 || len(teamMembers) > 0 {
+	if userTypeID == conv.roles.UserTypes[authrlib.RoleTeamMember] || len(teamMembers) > 0 {
 		res.TeamMember = &authorization.TeamMemberType{Kid: keys(teamMembers)}
 	}
 }
 
-func (*accessConverter) convertFSAdmin(rows []*accessDataRow, res *authorization.Access, userTypeID int64) {
-	if userTypeID != 7 {
+func (conv *accessConverter) convertFSAdmin(rows []*accessDataRow, res *authorization.Access, userTypeID int64) {
+	if userTypeID != conv.roles.UserTypes[authrlib.RoleFSAdmin] {
 		return
 	}
 	fsAdminItems := make(map[int64]interface{})
@@ -91,16 +99,16 @@ func (*accessConverter) convertFSAdmin(rows []*accessDataRow, res *authorization
 			AdminType: authorization.AdminType{Ent: keys(fsAdminItems)},
 			FundSrc:   keys(fsItems)}
 		switch fundSourceAdminTypeID.Int64 {
-		case 0:
+		case conv.roles.FundSourceAdminTypes[authrlib.RoleFSAdmin]:
 			res.FSAdmin = admin
-		case 1:
+		case conv.roles.FundSourceAdminTypes[authrlib.RoleFSVOAdmin]:
 			res.FSVOAdmin = admin
 		}
 	}
 }
 
-func (*accessConverter) convertAdmin(rows []*accessDataRow, res *authorization.Access, userTypeID int64) {
-	if userTypeID != 3 {
+func (conv *accessConverter) convertAdmin(rows []*accessDataRow, res *authorization.Access, userTypeID int64) {
+	if userTypeID != conv.roles.UserTypes[authrlib.RoleAdmin] {
 		return
 	}
 	entityIDs := make(map[int64]interface{})
@@ -113,11 +121,11 @@ func (*accessConverter) convertAdmin(rows []*accessDataRow, res *authorization.A
 	if len(entityIDs) > 0 && adminTypeID.Valid {
 		admin := &authorization.AdminType{Ent: keys(entityIDs)}
 		switch adminTypeID.Int64 {
-		case 0:
+		case conv.roles.AdminTypes[authrlib.RoleAdmin]:
 			res.Admin = admin
-		case 1:
+		case conv.roles.AdminTypes[authrlib.RoleVOAdmin]:
 			res.VOAdmin = admin
-		case 2:
+		case conv.roles.AdminTypes[authrlib.RoleVONoChildAdmin]:
 			res.VONoChildAdmin = admin
 		}
 	}
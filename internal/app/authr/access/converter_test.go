@@ -1,31 +1,33 @@
 package access
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"reflect"
 	"sort"
 	"testing"
 
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
 	"github.com/stretchr/testify/assert"
 )
 
 func BenchmarkConvert(b *testing.B) {
-	conv := &accessConverter{}
+	conv := &accessConverter{authrlib.DefaultRolesConfig()}
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < len(testCases); j++ {
 			p := testCaseToPayload(testCases[j].rows)
-			_ = conv.Convert(p)
+			_ = conv.Convert(context.Background(), p)
 		}
 	}
 }
 
 func TestConvert(t *testing.T) {
-	conv := &accessConverter{}
+	conv := &accessConverter{authrlib.DefaultRolesConfig()}
 	for j := 0; j < len(testCases); j++ {
 		testCase := testCases[j]
 		rows := testCaseToPayload(testCase.rows)
-		res := conv.Convert(rows)
+		res := conv.Convert(context.Background(), rows)
 
 		sortNestedInt64Slices(res)
 
@@ -39,6 +41,27 @@ func TestConvert(t *testing.T) {
 	}
 }
 
+func TestConvertCustomRoles(t *testing.T) {
+	roles := authrlib.DefaultRolesConfig()
+	roles.UserTypes[authrlib.RoleAdmin] = 8
+	roles.AdminTypes = map[string]int64{authrlib.RoleAdmin: 5, authrlib.RoleVOAdmin: 0, authrlib.RoleVONoChildAdmin: 2}
+	conv := &accessConverter{roles}
+
+	// user type 3 is not an admin anymore, admin type 0 is view only
+	rows := testCaseToPayload([]accessDataRowTest{
+		{userTypeID: 8, adminTypeID: 0, fundSourceAdminTypeID: -1, superUserTypeID: -1, fundSourceID: -1,
+			adminEntityID: 111, fsAdminEntityID: -1, classID: -1, teacherTypeID: -1, teamChildID: -1},
+	})
+	bytes, err := json.Marshal(conv.Convert(context.Background(), rows))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"SuperUser":false,"VOAdmin":{"ent":[111]}}`, string(bytes))
+
+	rows[0].userTypeID.Int64 = 3
+	bytes, err = json.Marshal(conv.Convert(context.Background(), rows))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"SuperUser":false}`, string(bytes))
+}
+
 // used to sort all nested fields which has type []int64, because `assert.JSONEq`
 // consider that serialized json `[1,2]` is not equal `[2,1]`.
 // test probes also should be defined as sorted array.
@@ -0,0 +1,168 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
+	"bitbucket.org/teachingstrategies/authorization-service/pkg/permission"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+)
+
+// reasons of rejected delegated admin lookups
+var (
+	errCallerNotAdmin      = authrerr.New(authrerr.CallerNotAdmin, "caller is not an admin of any entity")
+	errUserOutsideEntities = authrerr.New(authrerr.UserOutsideEntities, "user is outside of caller's entities")
+	errHierarchyTooLarge   = authrerr.New(authrerr.HierarchyTooLarge, "caller's entities have more descendants than hierarchy max results")
+)
+
+// authorizeDelegatedAdmin allows admin to read access of a user whose classes and entities
+// all belong to the caller's Admin/VOAdmin entities or their descendants; users with kids or
+// fund sources are denied, since those grants can't be resolved to the caller's entities
+func authorizeDelegatedAdmin(ctx context.Context, service Service, scopes *scopeCache, callerID, userID int) error {
+	caller, err := service.Access(ctx, callerID)
+	if errors.Is(err, errNotFound) || errors.Is(err, errNotAllowed) {
+		return errCallerNotAdmin
+	}
+	if err != nil {
+		return err
+	}
+	admin := &authorization.Access{Admin: caller.Admin, VOAdmin: caller.VOAdmin}
+	var entityIDs []int64
+	for _, role := range []*authorization.AdminType{admin.Admin, admin.VOAdmin} {
+		if role != nil {
+			entityIDs = append(entityIDs, role.Ent...)
+		}
+	}
+	if len(entityIDs) == 0 {
+		return errCallerNotAdmin
+	}
+
+	user, err := service.Access(ctx, userID)
+	if err != nil {
+		return err
+	}
+	userEntities, userClasses := accessScope(user)
+	if user.SuperUser || hasUnscopedGrants(user) || len(userEntities)+len(userClasses) == 0 {
+		return errUserOutsideEntities
+	}
+
+	scope, err := delegatedScope(ctx, service, scopes, callerID, admin, entityIDs)
+	if err != nil {
+		return err
+	}
+	for _, id := range userEntities {
+		if !scope.entities[id] {
+			return errUserOutsideEntities
+		}
+	}
+	for _, id := range userClasses {
+		if !scope.classes[id] {
+			return errUserOutsideEntities
+		}
+	}
+	return nil
+}
+
+// entities and classes within caller's admin entities
+type delegationScope struct {
+	key      string // admin entities the scope is expanded from
+	entities map[int64]bool
+	classes  map[int64]bool
+}
+
+// expands caller's admin entities down the hierarchy or takes them from cache unless they've changed;
+// scope is an error if the hierarchy is truncated, so users beyond the cap aren't denied by mistake
+func delegatedScope(ctx context.Context, service Service, scopes *scopeCache, callerID int, admin *authorization.Access, entityIDs []int64) (*delegationScope, error) {
+	sorted := append([]int64{}, entityIDs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	key := fmt.Sprint(sorted)
+	if scope, ok := scopes.get(callerID); ok && scope.key == key {
+		return scope, nil
+	}
+
+	hierarchy, err := service.Hierarchy(ctx, admin)
+	if err != nil {
+		return nil, err
+	}
+	scope := &delegationScope{key: key, entities: make(map[int64]bool), classes: make(map[int64]bool)}
+	for _, id := range entityIDs {
+		scope.entities[id] = true
+	}
+	for _, role := range []string{permission.RoleAdmin, permission.RoleVOAdmin} {
+		if descendants, ok := hierarchy[role]; ok {
+			if descendants.Truncated {
+				return nil, errHierarchyTooLarge
+			}
+			for _, id := range descendants.Ent {
+				scope.entities[id] = true
+			}
+			for _, id := range descendants.Cls {
+				scope.classes[id] = true
+			}
+		}
+	}
+	scopes.set(callerID, scope)
+	return scope, nil
+}
+
+// delegation scopes of callers with TTL and LRU eviction, nil cache keeps nothing
+type scopeCache struct {
+	*lru
+}
+
+func newScopeCache(ttl time.Duration, maxSize int) *scopeCache {
+	return &scopeCache{newLRU(ttl, maxSize)}
+}
+
+func (c *scopeCache) get(callerID int) (*delegationScope, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if scope, ok := c.lru.get(callerID); ok {
+		return scope.(*delegationScope), true
+	}
+	return nil, false
+}
+
+func (c *scopeCache) set(callerID int, scope *delegationScope) {
+	if c != nil {
+		c.lru.set(callerID, scope)
+	}
+}
+
+// entities and classes the user has access to through any role
+func accessScope(access *authorization.Access) (entities, classes []int64) {
+	for _, teacher := range []*authorization.TeacherType{access.Teacher, access.CoTeacher, access.AssistantTeacher} {
+		if teacher != nil {
+			classes = append(classes, teacher.Cls...)
+		}
+	}
+	for _, admin := range []*authorization.AdminType{access.Admin, access.VOAdmin, access.VONoChildAdmin} {
+		if admin != nil {
+			entities = append(entities, admin.Ent...)
+		}
+	}
+	for _, admin := range []*authorization.FsAdminType{access.FSAdmin, access.FSVOAdmin} {
+		if admin != nil {
+			entities = append(entities, admin.Ent...)
+		}
+	}
+	return entities, classes
+}
+
+// whether the user has kids or fund sources, which aren't linked with entities of the hierarchy
+func hasUnscopedGrants(access *authorization.Access) bool {
+	if access.TeamMember != nil && len(access.TeamMember.Kid) > 0 {
+		return true
+	}
+	for _, admin := range []*authorization.FsAdminType{access.FSAdmin, access.FSVOAdmin} {
+		if admin != nil && len(admin.FundSrc) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/pkg/permission"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizeDelegatedAdmin(t *testing.T) {
+	const callerID, userID = 7, 108
+	siteAdmin := &authorization.Access{
+		Admin:   &authorization.AdminType{Ent: []int64{100}},
+		VOAdmin: &authorization.AdminType{Ent: []int64{200}},
+		// roles other than Admin and VOAdmin don't delegate
+		VONoChildAdmin: &authorization.AdminType{Ent: []int64{300}},
+	}
+	adminOnly := &authorization.Access{Admin: siteAdmin.Admin, VOAdmin: siteAdmin.VOAdmin}
+	hierarchy := map[string]*entityHierarchy{
+		permission.RoleAdmin:   {Ent: []int64{101}, Cls: []int64{1001, 1002}},
+		permission.RoleVOAdmin: {Ent: []int64{}, Cls: []int64{2001}},
+	}
+
+	testCases := []struct {
+		name   string
+		caller *authorization.Access
+		user   *authorization.Access
+		err    error
+		mock   func(m *serviceMock)
+	}{
+		{name: "caller not found", err: errCallerNotAdmin,
+			mock: func(m *serviceMock) { m.On("Access", callerID).Return(nil, errNotFound).Once() }},
+		{name: "caller lookup failed", err: errors.New("db failed"),
+			mock: func(m *serviceMock) { m.On("Access", callerID).Return(nil, errors.New("db failed")).Once() }},
+		{name: "caller is not admin", err: errCallerNotAdmin,
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(&authorization.Access{VONoChildAdmin: siteAdmin.VONoChildAdmin}, nil).Once()
+			}},
+		{name: "user not found", err: errNotFound,
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(nil, errNotFound).Once()
+			}},
+		{name: "user is superuser", err: errUserOutsideEntities,
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{SuperUser: true}, nil).Once()
+			}},
+		{name: "user has no classes and entities", err: errUserOutsideEntities,
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{TeamMember: &authorization.TeamMemberType{Kid: []int64{5}}}, nil).Once()
+			}},
+		{name: "hierarchy failed", err: errors.New("db failed"),
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{1001}}}, nil).Once()
+				m.On("Hierarchy", adminOnly).Return(nil, errors.New("db failed")).Once()
+			}},
+		{name: "hierarchy truncated", err: errHierarchyTooLarge,
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{1001}}}, nil).Once()
+				m.On("Hierarchy", adminOnly).Return(map[string]*entityHierarchy{
+					permission.RoleAdmin: {Ent: []int64{101}, Cls: []int64{1001}, Truncated: true},
+				}, nil).Once()
+			}},
+		{name: "class outside of entities", err: errUserOutsideEntities,
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{1001, 3001}}}, nil).Once()
+				m.On("Hierarchy", adminOnly).Return(hierarchy, nil).Once()
+			}},
+		{name: "entity outside of entities", err: errUserOutsideEntities,
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{VONoChildAdmin: siteAdmin.VONoChildAdmin}, nil).Once()
+				m.On("Hierarchy", adminOnly).Return(hierarchy, nil).Once()
+			}},
+		{name: "class within entities and kids", err: errUserOutsideEntities,
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{
+					Teacher:    &authorization.TeacherType{Cls: []int64{1001}},
+					TeamMember: &authorization.TeamMemberType{Kid: []int64{5}},
+				}, nil).Once()
+			}},
+		{name: "entity within entities and fund sources", err: errUserOutsideEntities,
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{
+					FSVOAdmin: &authorization.FsAdminType{AdminType: authorization.AdminType{Ent: []int64{100}}, FundSrc: []int64{9001}},
+				}, nil).Once()
+			}},
+		{name: "teacher within entities",
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{
+					Teacher:   &authorization.TeacherType{Cls: []int64{1001}},
+					CoTeacher: &authorization.TeacherType{Cls: []int64{2001}},
+				}, nil).Once()
+				m.On("Hierarchy", adminOnly).Return(hierarchy, nil).Once()
+			}},
+		{name: "admin within entities",
+			mock: func(m *serviceMock) {
+				m.On("Access", callerID).Return(siteAdmin, nil).Once()
+				m.On("Access", userID).Return(&authorization.Access{
+					Admin:   &authorization.AdminType{Ent: []int64{101}},
+					FSAdmin: &authorization.FsAdminType{AdminType: authorization.AdminType{Ent: []int64{100}}},
+				}, nil).Once()
+				m.On("Hierarchy", adminOnly).Return(hierarchy, nil).Once()
+			}},
+	}
+
+	for _, testCase := range testCases {
+		service := &serviceMock{}
+		testCase.mock(service)
+		err := authorizeDelegatedAdmin(context.Background(), service, nil, callerID, userID)
+		assert.Equal(t, testCase.err, err, testCase.name)
+		service.AssertExpectations(t)
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestAuthorizeDelegatedAdminCachesScope(t *testing.T) {
+	const callerID, userID = 7, 108
+	caller := &authorization.Access{Admin: &authorization.AdminType{Ent: []int64{100}}}
+	user := &authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{1001}}}
+	hierarchy := map[string]*entityHierarchy{permission.RoleAdmin: {Ent: []int64{101}, Cls: []int64{1001}}}
+	now := time.Date(2019, 12, 1, 10, 0, 0, 0, time.UTC)
+	scopes := newScopeCache(time.Minute, 10)
+	scopes.now = func() time.Time { return now }
+
+	// the second lookup takes caller's scope from cache
+	service := &serviceMock{}
+	service.On("Access", callerID).Return(caller, nil).Twice()
+	service.On("Access", userID).Return(user, nil).Twice()
+	service.On("Hierarchy", caller).Return(hierarchy, nil).Once()
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, authorizeDelegatedAdmin(context.Background(), service, scopes, callerID, userID))
+	}
+	service.AssertExpectations(t)
+
+	// caller's entities changed, the scope is expanded again
+	moved := &authorization.Access{Admin: &authorization.AdminType{Ent: []int64{200}}}
+	service = &serviceMock{}
+	service.On("Access", callerID).Return(moved, nil).Once()
+	service.On("Access", userID).Return(user, nil).Once()
+	service.On("Hierarchy", moved).Return(map[string]*entityHierarchy{}, nil).Once()
+	assert.Equal(t, errUserOutsideEntities, authorizeDelegatedAdmin(context.Background(), service, scopes, callerID, userID))
+	service.AssertExpectations(t)
+
+	// expired scope is expanded again
+	now = now.Add(2 * time.Minute)
+	service = &serviceMock{}
+	service.On("Access", callerID).Return(moved, nil).Once()
+	service.On("Access", userID).Return(user, nil).Once()
+	service.On("Hierarchy", moved).Return(map[string]*entityHierarchy{}, nil).Once()
+	assert.Equal(t, errUserOutsideEntities, authorizeDelegatedAdmin(context.Background(), service, scopes, callerID, userID))
+	service.AssertExpectations(t)
+	assert.Equal(t, 1, scopes.order.Len())
+}
@@ -0,0 +1,82 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+)
+
+// statements of a db backend; %s in statements is replaced by placeholders
+type dialect struct {
+	query            string
+	batchQuery       string
+	entityRootsQuery string
+	// the first parameter is max number of rows
+	childrenQueries map[int]string
+	entityQueries   map[int]string
+	// returns comma separated list of n placeholders, the first one is parameter number `from`
+	placeholders func(from, n int) string
+}
+
+// dialects by backend name
+var dialects = map[string]*dialect{
+	authrlib.BackendMSSQL:    mssqlDialect,
+	authrlib.BackendPostgres: postgresDialect,
+}
+
+var mssqlDialect = &dialect{
+	query:            query,
+	batchQuery:       batchQuery,
+	entityRootsQuery: entityRootsQuery,
+	childrenQueries:  childrenQueries,
+	entityQueries:    entityQueries,
+	placeholders: func(_, n int) string {
+		return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+	},
+}
+
+// postgres has neither table hints nor TOP, parameters are numbered
+var postgresDialect = &dialect{
+	query: `
+	SELECT ` + accessColumns + withoutHints(accessTables) + `
+WHERE u.UserID = $1`,
+	batchQuery: `
+	SELECT u.UserID,
+		` + accessColumns + withoutHints(accessTables) + `
+WHERE u.UserID IN (%s)`,
+	entityRootsQuery: withoutHints(entityRootsQuery),
+	childrenQueries: map[int]string{
+		organizationLevel: `
+	SELECT p.ProgramID
+	FROM dbo.CC_Programs p
+WHERE p.OrganizationID IN (%s)
+LIMIT $1`,
+		programLevel: `
+	SELECT s.SiteID
+	FROM dbo.CC_Sites s
+WHERE s.ProgramID IN (%s)
+LIMIT $1`,
+		siteLevel: `
+	SELECT c.ClassID
+	FROM dbo.CC_Classes c
+WHERE c.SiteID IN (%s)
+LIMIT $1`,
+	},
+	entityQueries: map[int]string{
+		programLevel: withoutHints(entityQueries[programLevel]),
+		siteLevel:    withoutHints(entityQueries[siteLevel]),
+	},
+	placeholders: func(from, n int) string {
+		params := make([]string, n)
+		for i := range params {
+			params[i] = fmt.Sprintf("$%d", from+i)
+		}
+		return strings.Join(params, ", ")
+	},
+}
+
+// removes MSSQL table hints
+func withoutHints(query string) string {
+	return strings.Replace(query, " WITH (NOLOCK)", "", -1)
+}
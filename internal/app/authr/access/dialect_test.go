@@ -0,0 +1,62 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceholders(t *testing.T) {
+	assert.Equal(t, "?, ?, ?", mssqlDialect.placeholders(2, 3))
+	assert.Equal(t, "$2, $3, $4", postgresDialect.placeholders(2, 3))
+	assert.Equal(t, "$1", postgresDialect.placeholders(1, 1))
+}
+
+func TestPostgresDialect(t *testing.T) {
+	for _, statement := range []string{postgresDialect.query, postgresDialect.batchQuery, postgresDialect.entityRootsQuery,
+		postgresDialect.childrenQueries[siteLevel], postgresDialect.entityQueries[siteLevel]} {
+		assert.False(t, strings.Contains(statement, "NOLOCK"), statement)
+		assert.False(t, strings.Contains(statement, "TOP"), statement)
+		assert.False(t, strings.Contains(statement, "?"), statement)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create db mock: %v", err)
+	}
+	defer db.Close()
+	repo := &accessRepo{db: &dbReaderMock{db}, dialect: postgresDialect, timeout: time.Second}
+	columns := []string{"UserTypeID", "AdminTypeID", "FundSourceAdminTypeID",
+		"SuperUserTypeID", "FundSourceID", "AdminEntityID", "FSAdminEntityID",
+		"ClassID", "TeacherTypeID", "TeamChildID"}
+
+	// single user
+	mock.ExpectPrepare(regexp.QuoteMeta("WHERE u.UserID = $1")).ExpectQuery().WithArgs(333).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, nil, nil, 0, nil, nil, nil, 101, 1, nil))
+	data, err := repo.QueryAccessData(context.Background(), 333)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1)
+
+	// batch
+	mock.ExpectPrepare(regexp.QuoteMeta("WHERE u.UserID IN ($1, $2)")).ExpectQuery().WithArgs(333, 444).
+		WillReturnRows(sqlmock.NewRows(append([]string{"UserID"}, columns...)).AddRow(444, 1, nil, nil, 0, nil, nil, nil, 101, 1, nil))
+	batch, err := repo.QueryBatchAccessData(context.Background(), []int{333, 444})
+	assert.NoError(t, err)
+	assert.Len(t, batch[444], 1)
+
+	// limit of children is the first parameter
+	mock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf(postgresDialect.entityRootsQuery, "$1"))).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"OrganizationID", "ProgramID", "SiteID"}).AddRow(nil, nil, 30))
+	mock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf(postgresDialect.childrenQueries[siteLevel], "$2"))).WithArgs(11, 30).
+		WillReturnRows(sqlmock.NewRows([]string{"ClassID"}).AddRow(300))
+	hierarchy, err := repo.QueryEntityHierarchy(context.Background(), []int64{1}, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{300}, hierarchy.Cls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
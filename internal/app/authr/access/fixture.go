@@ -0,0 +1,230 @@
+package access
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CCNET data of fixture db mode
+type fixtures struct {
+	Users         []*fixtureUser `yaml:"users"`
+	Organizations []fixtureNode  `yaml:"organizations"`
+	Programs      []fixtureNode  `yaml:"programs"`
+	Sites         []fixtureNode  `yaml:"sites"`
+	Classes       []fixtureNode  `yaml:"classes"`
+}
+
+// organization, program, site or class
+type fixtureNode struct {
+	ID int64 `yaml:"id"`
+	// organization of program, program of site, site of class
+	Parent int64 `yaml:"parent"`
+	// G2 entity of organization, program or site, zero if there is none
+	Entity int64 `yaml:"entity"`
+}
+
+// user with the records joined by access query
+type fixtureUser struct {
+	ID                  int            `yaml:"id"`
+	UserType            int64          `yaml:"user-type"`
+	AdminType           *int64         `yaml:"admin-type"`
+	FundSourceAdminType *int64         `yaml:"fund-source-admin-type"`
+	SuperUser           bool           `yaml:"super-user"`
+	Classes             []fixtureClass `yaml:"classes"`
+	// children of accepted team invitations
+	Children           []int64 `yaml:"children"`
+	AdminEntities      []int64 `yaml:"admin-entities"`
+	FundSources        []int64 `yaml:"fund-sources"`
+	FundSourceEntities []int64 `yaml:"fund-source-entities"`
+}
+
+type fixtureClass struct {
+	ID          int64 `yaml:"id"`
+	TeacherType int64 `yaml:"teacher-type"`
+}
+
+// Dao of fixture db mode, produces the same rows as access query does, so converter is exercised
+type fixtureDao struct {
+	users map[int]*fixtureUser
+	// nodes by hierarchy level and ID
+	nodes map[int]map[int64]fixtureNode
+	// entity -> level and ID of the node it is linked with
+	entities map[int64]fixtureNode
+	levels   map[int64]int
+}
+
+// NewFixtureDao loads Dao of fixture db mode from YAML file
+func NewFixtureDao(fixturesFile string) (Dao, error) {
+	bytes, err := ioutil.ReadFile(fixturesFile)
+	if err != nil {
+		return nil, err
+	}
+	data := new(fixtures)
+	// unknown keys are rejected, so misspelled fixtures aren't ignored silently
+	if err = yaml.UnmarshalStrict(bytes, data); err != nil {
+		return nil, err
+	}
+	return newFixtureDao(data)
+}
+
+func newFixtureDao(data *fixtures) (*fixtureDao, error) {
+	dao := &fixtureDao{
+		users:    make(map[int]*fixtureUser, len(data.Users)),
+		nodes:    make(map[int]map[int64]fixtureNode),
+		entities: make(map[int64]fixtureNode),
+		levels:   make(map[int64]int),
+	}
+	for _, user := range data.Users {
+		if _, ok := dao.users[user.ID]; ok {
+			return nil, fmt.Errorf("duplicate fixture user %d", user.ID)
+		}
+		dao.users[user.ID] = user
+	}
+	for level, nodes := range [][]fixtureNode{data.Organizations, data.Programs, data.Sites, data.Classes} {
+		dao.nodes[level] = make(map[int64]fixtureNode, len(nodes))
+		for _, node := range nodes {
+			if _, ok := dao.nodes[level][node.ID]; ok {
+				return nil, fmt.Errorf("duplicate fixture node %d on level %d", node.ID, level)
+			}
+			if _, ok := dao.nodes[level-1][node.Parent]; level > organizationLevel && !ok {
+				return nil, fmt.Errorf("unknown parent %d of fixture node %d on level %d", node.Parent, node.ID, level)
+			}
+			dao.nodes[level][node.ID] = node
+			if node.Entity != 0 && level < classLevel {
+				if _, ok := dao.entities[node.Entity]; ok {
+					return nil, fmt.Errorf("duplicate fixture entity %d", node.Entity)
+				}
+				dao.entities[node.Entity], dao.levels[node.Entity] = node, level
+			}
+		}
+	}
+	return dao, nil
+}
+
+func (dao *fixtureDao) QueryAccessData(_ context.Context, userID int) ([]*accessDataRow, error) {
+	user, ok := dao.users[userID]
+	if !ok {
+		return []*accessDataRow{}, nil
+	}
+	return user.rows(), nil
+}
+
+func (dao *fixtureDao) QueryBatchAccessData(_ context.Context, userIDs []int) (map[int][]*accessDataRow, error) {
+	accessData := make(map[int][]*accessDataRow, len(userIDs))
+	for _, userID := range userIDs {
+		if user, ok := dao.users[userID]; ok {
+			accessData[userID] = user.rows()
+		}
+	}
+	return accessData, nil
+}
+
+// walks fixture nodes the same way accessRepo walks CCNET tables
+func (dao *fixtureDao) QueryEntityHierarchy(_ context.Context, entityIDs []int64, maxDepth, maxResults int) (*entityHierarchy, error) {
+	result := &entityHierarchy{Ent: []int64{}, Cls: []int64{}}
+	if len(entityIDs) == 0 || maxDepth <= 0 {
+		return result, nil
+	}
+	frontier := make(map[int]map[int64]interface{})
+	visited := map[int]map[int64]interface{}{programLevel: {}, siteLevel: {}, classLevel: {}}
+	for _, entityID := range entityIDs {
+		if node, ok := dao.entities[entityID]; ok {
+			level := dao.levels[entityID]
+			if frontier[level] == nil {
+				frontier[level] = make(map[int64]interface{})
+			}
+			frontier[level][node.ID] = struct{}{}
+			if level != organizationLevel {
+				visited[level][node.ID] = struct{}{}
+			}
+		}
+	}
+	descendants := map[int]map[int64]interface{}{programLevel: {}, siteLevel: {}, classLevel: {}}
+	found := 0
+	for depth := 0; depth < maxDepth && !result.Truncated; depth++ {
+		next := make(map[int]map[int64]interface{})
+		for level := organizationLevel; level < classLevel && !result.Truncated; level++ {
+			for _, child := range dao.nodes[level+1] {
+				if _, ok := frontier[level][child.Parent]; !ok {
+					continue
+				}
+				if _, ok := visited[level+1][child.ID]; ok {
+					continue
+				}
+				if found == maxResults {
+					result.Truncated = true
+					break
+				}
+				found++
+				visited[level+1][child.ID] = struct{}{}
+				descendants[level+1][child.ID] = struct{}{}
+				if next[level+1] == nil {
+					next[level+1] = make(map[int64]interface{})
+				}
+				next[level+1][child.ID] = struct{}{}
+			}
+		}
+		frontier = next
+	}
+	result.Cls = keys(descendants[classLevel])
+	for _, level := range []int{programLevel, siteLevel} {
+		for id := range descendants[level] {
+			if entity := dao.nodes[level][id].Entity; entity != 0 {
+				result.Ent = append(result.Ent, entity)
+			}
+		}
+	}
+	return result, nil
+}
+
+// one row per joined record, user columns are repeated in every row
+func (user *fixtureUser) rows() []*accessDataRow {
+	base := accessDataRow{
+		userTypeID:            nullInt64(&user.UserType),
+		adminTypeID:           nullInt64(user.AdminType),
+		fundSourceAdminTypeID: nullInt64(user.FundSourceAdminType),
+		superUserTypeID:       sql.NullInt64{Valid: true},
+	}
+	if user.SuperUser {
+		base.superUserTypeID.Int64 = 1
+	}
+	rows := make([]*accessDataRow, 0)
+	add := func(set func(*accessDataRow)) {
+		row := base
+		set(&row)
+		rows = append(rows, &row)
+	}
+	for _, class := range user.Classes {
+		add(func(row *accessDataRow) {
+			row.classID = sql.NullInt64{Int64: class.ID, Valid: true}
+			row.teacherTypeID = sql.NullInt64{Int64: class.TeacherType, Valid: true}
+		})
+	}
+	for _, id := range user.Children {
+		add(func(row *accessDataRow) { row.teamChildID = sql.NullInt64{Int64: id, Valid: true} })
+	}
+	for _, id := range user.AdminEntities {
+		add(func(row *accessDataRow) { row.adminEntityID = sql.NullInt64{Int64: id, Valid: true} })
+	}
+	for _, id := range user.FundSources {
+		add(func(row *accessDataRow) { row.fundSourceID = sql.NullInt64{Int64: id, Valid: true} })
+	}
+	for _, id := range user.FundSourceEntities {
+		add(func(row *accessDataRow) { row.fsAdminEntityID = sql.NullInt64{Int64: id, Valid: true} })
+	}
+	if len(rows) == 0 {
+		rows = append(rows, &base)
+	}
+	return rows
+}
+
+func nullInt64(value *int64) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *value, Valid: true}
+}
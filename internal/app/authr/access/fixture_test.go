@@ -0,0 +1,103 @@
+package access
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixtureDaoConformance(t *testing.T) {
+	dao, err := NewFixtureDao("testdata/conformance/fixtures.yml")
+	if err != nil {
+		t.Fatalf("unable to load fixtures: %v", err)
+	}
+	testDaoConformance(t, dao)
+}
+
+func TestNewFixtureDao(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		name     string
+		fixtures string
+		err      string
+	}{
+		{name: "invalid yaml", fixtures: "users: {", err: "yaml: line 1: did not find expected node content"},
+		{name: "unknown key", fixtures: "users: [{id: 1, usertype: 1}]",
+			err: "yaml: unmarshal errors:\n  line 1: field usertype not found in type access.fixtureUser"},
+		{name: "duplicate user", fixtures: "users: [{id: 1}, {id: 1}]", err: "duplicate fixture user 1"},
+		{name: "duplicate node", fixtures: "organizations: [{id: 1}, {id: 1}]", err: "duplicate fixture node 1 on level 0"},
+		{name: "unknown parent", fixtures: "organizations: [{id: 1}]\nprograms: [{id: 2, parent: 3}]",
+			err: "unknown parent 3 of fixture node 2 on level 1"},
+		{name: "duplicate entity", fixtures: "organizations: [{id: 1, entity: 5}]\nprograms: [{id: 2, parent: 1, entity: 5}]",
+			err: "duplicate fixture entity 5"},
+		{name: "valid", fixtures: "organizations: [{id: 1, entity: 5}]\nusers: [{id: 1, user-type: 1}]"},
+	}
+	for _, testCase := range testCases {
+		file := filepath.Join(dir, "fixtures.yml")
+		if err = ioutil.WriteFile(file, []byte(testCase.fixtures), 0600); err != nil {
+			t.Fatalf("unable to write fixtures: %v", err)
+		}
+		dao, err := NewFixtureDao(file)
+		if testCase.err != "" {
+			assert.EqualError(t, err, testCase.err, testCase.name)
+		} else {
+			assert.NoError(t, err, testCase.name)
+			assert.NotNil(t, dao, testCase.name)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+
+	_, err = NewFixtureDao(filepath.Join(dir, "missing.yml"))
+	assert.Error(t, err)
+
+	// fixtures shipped for local development
+	_, err = NewFixtureDao("../../../../configs/fixtures.yml")
+	assert.NoError(t, err)
+}
+
+func TestFixtureUserRows(t *testing.T) {
+	adminType := int64(1)
+	// user without records has a single row
+	user := &fixtureUser{ID: 1, UserType: 3, AdminType: &adminType}
+	assert.Equal(t, []*accessDataRow{{
+		userTypeID:      sql.NullInt64{Int64: 3, Valid: true},
+		adminTypeID:     sql.NullInt64{Int64: 1, Valid: true},
+		superUserTypeID: sql.NullInt64{Valid: true},
+	}}, user.rows())
+
+	// every record has its own row with user columns
+	user = &fixtureUser{ID: 1, UserType: 1, SuperUser: true, Classes: []fixtureClass{{ID: 10, TeacherType: 2}}, Children: []int64{20}}
+	rows := user.rows()
+	assert.Len(t, rows, 2)
+	for _, row := range rows {
+		assert.Equal(t, sql.NullInt64{Int64: 1, Valid: true}, row.userTypeID)
+		assert.Equal(t, sql.NullInt64{Int64: 1, Valid: true}, row.superUserTypeID)
+		assert.False(t, row.adminTypeID.Valid)
+	}
+	assert.Equal(t, sql.NullInt64{Int64: 10, Valid: true}, rows[0].classID)
+	assert.Equal(t, sql.NullInt64{Int64: 2, Valid: true}, rows[0].teacherTypeID)
+	assert.Equal(t, sql.NullInt64{Int64: 20, Valid: true}, rows[1].teamChildID)
+	assert.False(t, rows[1].classID.Valid)
+}
+
+func TestFixtureDaoHierarchyRoots(t *testing.T) {
+	dao, err := NewFixtureDao("testdata/conformance/fixtures.yml")
+	if err != nil {
+		t.Fatalf("unable to load fixtures: %v", err)
+	}
+	// site entity is expanded to its classes only, unknown entities are ignored
+	hierarchy, err := dao.QueryEntityHierarchy(context.Background(), []int64{1011, 9999}, 3, 100)
+	assert.NoError(t, err)
+	assert.Empty(t, hierarchy.Ent)
+	assert.ElementsMatch(t, []int64{101, 102}, hierarchy.Cls)
+}
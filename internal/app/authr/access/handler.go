@@ -1,69 +1,266 @@
 package access
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
 	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/authorization-service/pkg/permission"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
 	"github.com/gamegos/jsend"
 	"github.com/go-chi/chi"
 )
 
+// default max number of user IDs in a single batch request
+const defaultBatchLimit = 500
+
+// room of batch request body per user ID and for the rest of the document
+const (
+	batchBytesPerUserID = 32
+	batchBodyOverhead   = 1024
+)
+
+// evaluates permission request against access, replaced in tests
+var evaluate = permission.Evaluate
+
 // NewAccessHandler creates new instance of access handler
-func NewAccessHandler(ctx *authrlib.AppContext) http.HandlerFunc {
-	service := &accessService{&accessConverter{}, &accessRepo{ctx.DbManager.Db()}}
+func NewAccessHandler(ctx *authrlib.AppContext, service Service) http.HandlerFunc {
 	return (&accessHandler{ctx, service}).handlerFunc()
 }
 
+// NewBatchAccessHandler creates new instance of batch access handler
+func NewBatchAccessHandler(ctx *authrlib.AppContext, service Service) http.HandlerFunc {
+	return (&accessHandler{ctx, service}).batchHandlerFunc()
+}
+
+// NewAccessCheckHandler creates new instance of handler which evaluates a single permission of a user
+func NewAccessCheckHandler(ctx *authrlib.AppContext, service Service) http.HandlerFunc {
+	return (&accessHandler{ctx, service}).checkHandlerFunc()
+}
+
+// NewCacheInvalidationHandler creates new instance of handler which purges cached access of a user
+func NewCacheInvalidationHandler(ctx *authrlib.AppContext, service Service) http.HandlerFunc {
+	return (&accessHandler{ctx, service}).invalidationHandlerFunc()
+}
+
 // struct which produces http.HandlerFunc
 type accessHandler struct {
 	ctx     *authrlib.AppContext
 	service Service
 }
 
+// body of batch access request
+type batchAccessRequest struct {
+	UserIDs []int `json:"userIds"`
+}
+
 func (ah *accessHandler) handlerFunc() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 		logger := ah.ctx.Logger.HandlerLogger(r)
 
 		if err != nil {
-			msg := "userID has incorrect value"
-			logger.Error().Str("user_id", chi.URLParam(r, "userID")).Msg(msg)
-			if _, err = jsend.Wrap(w).Message(msg).Status(http.StatusInternalServerError).Send(); err != nil {
-				logger.Warn().Err(err).Msgf("unable to reply: %s", msg)
-			}
+			replyError(authrerr.Wrap(err, authrerr.InvalidRequest, "userID has incorrect value"), logger, w)
+			return
+		}
+		expand := r.URL.Query().Get("expand")
+		if expand != "" && expand != expandHierarchy {
+			replyError(authrerr.New(authrerr.InvalidRequest, "expand has incorrect value"), logger, w)
 			return
 		}
-		resp, err := ah.service.Access(userID)
+		resp, err := ah.service.Access(r.Context(), userID)
 		if err != nil {
 			replyAccessError(userID, err, logger, w)
 			return
 		}
+		auditChildren(r, resp)
+		var data interface{} = resp
+		if expand == expandHierarchy {
+			hierarchy, err := ah.service.Hierarchy(r.Context(), resp)
+			if err != nil {
+				replyAccessError(userID, err, logger, w)
+				return
+			}
+			data = &expandedAccess{Access: resp, Hierarchy: hierarchy}
+		}
+		if _, err = jsend.Wrap(w).Message("request completed").Data(data).Status(http.StatusOK).Send(); err != nil {
+			logger.Warn().Err(err).Msg("unable to reply success")
+		}
+	}
+}
+
+func (ah *accessHandler) batchHandlerFunc() http.HandlerFunc {
+	limit := ah.ctx.ConfigService.Config().App.BatchLimit
+	if limit <= 0 {
+		limit = defaultBatchLimit
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ah.ctx.Logger.HandlerLogger(r)
+
+		userIDs, err := decodeBatchAccessRequest(w, r, limit)
+		if err != nil {
+			replyError(err, logger, w)
+			return
+		}
+		if event := authrlib.AuditEventFrom(r.Context()); event != nil {
+			event.UserIDs = userIDs
+		}
+		resp, err := ah.service.BatchAccess(r.Context(), userIDs)
+		if err != nil {
+			logger = &authrlib.AppLogger{Logger: logger.With().Int("users", len(userIDs)).Logger()}
+			replyError(err, logger, w)
+			return
+		}
+		for _, item := range resp {
+			if item.Access != nil {
+				auditChildren(r, item.Access)
+			}
+		}
 		if _, err = jsend.Wrap(w).Message("request completed").Data(resp).Status(http.StatusOK).Send(); err != nil {
 			logger.Warn().Err(err).Msg("unable to reply success")
 		}
 	}
 }
 
-func replyAccessError(userID int, err error, logger *authrlib.AppLogger, w http.ResponseWriter) {
-	if err == errNotFound {
-		logger.Warn().Err(err).Int("user_id", userID).Msg("not found")
-		if _, err = jsend.Wrap(w).Message(err.Error()).Status(http.StatusNotFound).Send(); err != nil {
-			logger.Warn().Err(err).Msg("unable to reply: user not found")
+func (ah *accessHandler) checkHandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+		logger := ah.ctx.Logger.HandlerLogger(r)
+
+		if err != nil {
+			replyError(authrerr.Wrap(err, authrerr.InvalidRequest, "userID has incorrect value"), logger, w)
+			return
+		}
+		var req permission.Request
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil {
+			err = req.Validate()
+		}
+		if err != nil {
+			replyAccessError(userID, authrerr.New(authrerr.InvalidRequest, err.Error()), logger, w)
+			return
+		}
+		access, err := ah.service.Access(r.Context(), userID)
+		if err != nil {
+			replyAccessError(userID, err, logger, w)
+			return
+		}
+		decision, err := evaluate(access, req)
+		if err != nil {
+			replyAccessError(userID, authrerr.New(authrerr.InvalidRequest, err.Error()), logger, w)
+			return
+		}
+		auditDecision(r, req, decision)
+		logger.Info().Int("user_id", userID).
+			Str("resource_type", string(req.ResourceType)).
+			Int64("resource_id", req.ResourceID).
+			Str("action", string(req.Action)).
+			Bool("allowed", decision.Allowed).
+			Str("role", decision.Role).
+			Msg("permission checked")
+		if _, err = jsend.Wrap(w).Message("request completed").Data(decision).Status(http.StatusOK).Send(); err != nil {
+			logger.Warn().Err(err).Msg("unable to reply success")
 		}
-		return
 	}
-	if err == errNotAllowed {
-		logger.Warn().Err(err).Int("user_id", userID).Msg("forbidden")
-		if _, err = jsend.Wrap(w).Message(err.Error()).Status(http.StatusForbidden).Send(); err != nil {
-			logger.Warn().Err(err).Msg("unable to reply: forbidden")
+}
+
+func (ah *accessHandler) invalidationHandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+		logger := ah.ctx.Logger.HandlerLogger(r)
+
+		if err != nil {
+			replyError(authrerr.Wrap(err, authrerr.InvalidRequest, "userID has incorrect value"), logger, w)
+			return
 		}
+		// nothing to purge if cache is disabled
+		if invalidator, ok := ah.service.(Invalidator); ok {
+			invalidator.Invalidate(userID)
+		}
+		logger.Info().Int("user_id", userID).Msg("access cache purged")
+		if _, err = jsend.Wrap(w).Message("cache purged").Status(http.StatusOK).Send(); err != nil {
+			logger.Warn().Err(err).Msg("unable to reply success")
+		}
+	}
+}
+
+// records IDs of children the access grants in audit event of the request
+func auditChildren(r *http.Request, access *authorization.Access) {
+	if event := authrlib.AuditEventFrom(r.Context()); event != nil && access.TeamMember != nil {
+		event.ChildIDs = append(event.ChildIDs, access.TeamMember.Kid...)
+	}
+}
+
+// records checked permission in audit event of the request, ID of a child resource is redacted with the rest of child IDs
+func auditDecision(r *http.Request, req permission.Request, decision permission.Decision) {
+	event := authrlib.AuditEventFrom(r.Context())
+	if event == nil {
 		return
 	}
-	err = fmt.Errorf("unable to fetch access data for userID %d; err=%v", userID, err)
-	logger.Warn().Err(err).Int("user_id", userID).Msg("unable to fetch access data")
-	if _, replyErr := jsend.Wrap(w).Message(err.Error()).Status(http.StatusInternalServerError).Send(); replyErr != nil {
-		logger.Warn().Err(err).Msg("unable to reply: unable to fetch access data")
+	event.ResourceType, event.ResourceAction, event.Role = string(req.ResourceType), string(req.Action), decision.Role
+	if req.ResourceType == permission.Child {
+		event.ChildIDs = append(event.ChildIDs, req.ResourceID)
+	} else {
+		event.ResourceID = req.ResourceID
+	}
+	event.Decision = authrlib.AuditDeny
+	if decision.Allowed {
+		event.Decision = authrlib.AuditAllow
+	}
+}
+
+// reads batch request body of at most maxBatchBodySize and returns distinct user IDs
+func decodeBatchAccessRequest(w http.ResponseWriter, r *http.Request, limit int) ([]int, error) {
+	var req batchAccessRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodySize(limit))
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		// the only way MaxBytesReader reports exceeded size
+		if err.Error() == "http: request body too large" {
+			return nil, authrerr.Wrap(err, authrerr.RequestTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBatchBodySize(limit)))
+		}
+		return nil, authrerr.New(authrerr.InvalidRequest, fmt.Sprintf("unable to decode request body: %v", err))
+	}
+	userIDs := make([]int, 0, len(req.UserIDs))
+	seen := make(map[int]struct{}, len(req.UserIDs))
+	for _, userID := range req.UserIDs {
+		if userID <= 0 {
+			return nil, authrerr.New(authrerr.InvalidRequest, fmt.Sprintf("userID has incorrect value: %d", userID))
+		}
+		if _, ok := seen[userID]; !ok {
+			seen[userID] = struct{}{}
+			userIDs = append(userIDs, userID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil, authrerr.New(authrerr.InvalidRequest, "userIds should not be empty")
+	}
+	if len(userIDs) > limit {
+		return nil, authrerr.New(authrerr.InvalidRequest, fmt.Sprintf("too many userIds: %d, max allowed %d", len(userIDs), limit))
+	}
+	return userIDs, nil
+}
+
+// max size of batch request body with limit user IDs, formatting and duplicates are tolerated
+func maxBatchBodySize(limit int) int64 {
+	return int64(limit)*batchBytesPerUserID + batchBodyOverhead
+}
+
+// replies with error of access lookup of the user
+func replyAccessError(userID int, err error, logger *authrlib.AppLogger, w http.ResponseWriter) {
+	replyError(err, &authrlib.AppLogger{Logger: logger.With().Int("user_id", userID).Logger()}, w)
+}
+
+// logs error with internal details and replies with its code and sanitized message
+func replyError(err error, logger *authrlib.AppLogger, w http.ResponseWriter) {
+	e := authrerr.From(err)
+	event := logger.Warn()
+	if e.Code.Status() >= http.StatusInternalServerError {
+		event = logger.Error()
+	}
+	event.Err(err).Str("code", string(e.Code)).Msg(e.Message)
+	if _, replyErr := authrerr.Reply(w, e); replyErr != nil {
+		logger.Warn().Err(replyErr).Msgf("unable to reply: %s", e.Message)
 	}
 }
@@ -13,13 +13,16 @@ import (
 	"testing"
 
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/rs/zerolog"
 
 	"github.com/stretchr/testify/assert"
 
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
 	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/authorization-service/pkg/permission"
 	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
 	bootstraputils "bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
 	"github.com/stretchr/testify/mock"
@@ -28,8 +31,10 @@ import (
 // dbManagerMock dbmanager mock
 type dbManagerMock struct{ mock.Mock }
 
-func (*dbManagerMock) Db() *sql.DB { return nil }
-func (*dbManagerMock) Release()    {}
+func (*dbManagerMock) Db() *sql.DB                                     { return nil }
+func (*dbManagerMock) Read(context.Context, func(*sql.DB) error) error { return nil }
+func (*dbManagerMock) Stats() map[string]sql.DBStats                   { return nil }
+func (*dbManagerMock) Release()                                        {}
 
 // responserWriterMock
 type responserWriterMock struct{ mock.Mock }
@@ -41,9 +46,8 @@ func (*responserWriterMock) WriteHeader(statusCode int) {}
 var writeError = errors.New("write response error")
 
 func TestNewAccessHandler(t *testing.T) {
-	dbManager := &dbManagerMock{}
-	ctx := &authrlib.AppContext{DbManager: dbManager}
-	handler := NewAccessHandler(ctx)
+	ctx := &authrlib.AppContext{}
+	handler := NewAccessHandler(ctx, &serviceMock{})
 	assert.NotNil(t, handler)
 }
 
@@ -60,30 +64,35 @@ func TestReplyAccessError(t *testing.T) {
 		{
 			name: "user not found",
 			err:  errNotFound, respWriter: httptest.NewRecorder(), status: http.StatusNotFound,
-			logs: []string{`{"level":"warn","error":"user not found","user_id":42,"message":"not found"}`}},
+			logs: []string{`{"level":"warn","user_id":42,"error":"user not found","code":"USER_NOT_FOUND","message":"user not found"}`}},
 		{
 			name: "user not found (unable to reply)",
 			err:  errNotFound, respWriter: &responserWriterMock{}, status: 0,
-			logs: []string{`{"level":"warn","error":"user not found","user_id":42,"message":"not found"}`,
-				`{"level":"warn","error":"write response error","message":"unable to reply: user not found"}`}},
+			logs: []string{`{"level":"warn","user_id":42,"error":"user not found","code":"USER_NOT_FOUND","message":"user not found"}`,
+				`{"level":"warn","user_id":42,"error":"write response error","message":"unable to reply: user not found"}`}},
 		{
 			name: "user not allowed",
 			err:  errNotAllowed, respWriter: httptest.NewRecorder(), status: http.StatusForbidden,
-			logs: []string{`{"error":"user not allowed", "level":"warn", "message":"forbidden", "user_id":42}`}},
+			logs: []string{`{"level":"warn","user_id":42,"error":"user not allowed","code":"USER_TYPE_NOT_ALLOWED","message":"user not allowed"}`}},
 		{
 			name: "user not allowed (unable to reply)",
 			err:  errNotAllowed, respWriter: &responserWriterMock{}, status: 0,
-			logs: []string{`{"error":"user not allowed", "level":"warn", "message":"forbidden", "user_id":42}`,
-				`{"level":"warn","error":"write response error","message":"unable to reply: forbidden"}`}},
+			logs: []string{`{"level":"warn","user_id":42,"error":"user not allowed","code":"USER_TYPE_NOT_ALLOWED","message":"user not allowed"}`,
+				`{"level":"warn","user_id":42,"error":"write response error","message":"unable to reply: user not allowed"}`}},
 		{
-			name: "unable to fetch access data",
+			name: "db unavailable",
+			err:  authrerr.Wrap(errors.New("login failed"), authrerr.DBUnavailable, errDBUnavailableMessage), respWriter: httptest.NewRecorder(),
+			status: http.StatusServiceUnavailable,
+			logs:   []string{`{"level":"error","user_id":42,"error":"access data is temporarily unavailable: login failed","code":"DB_UNAVAILABLE","message":"access data is temporarily unavailable"}`}},
+		{
+			name: "unexpected error",
 			err:  errors.New("other error"), respWriter: httptest.NewRecorder(), status: http.StatusInternalServerError,
-			logs: []string{`{"level":"warn","error":"unable to fetch access data for userID 42; err=other error","user_id":42,"message":"unable to fetch access data"}`}},
+			logs: []string{`{"level":"error","user_id":42,"error":"other error","code":"INTERNAL","message":"internal server error"}`}},
 		{
-			name: "unable to fetch access data (unable to reply)",
+			name: "unexpected error (unable to reply)",
 			err:  errors.New("other error"), respWriter: &responserWriterMock{}, status: 0,
-			logs: []string{`{"level":"warn","error":"unable to fetch access data for userID 42; err=other error","user_id":42,"message":"unable to fetch access data"}`,
-				`{"level":"warn","error":"unable to fetch access data for userID 42; err=other error","message":"unable to reply: unable to fetch access data"}`}},
+			logs: []string{`{"level":"error","user_id":42,"error":"other error","code":"INTERNAL","message":"internal server error"}`,
+				`{"level":"warn","user_id":42,"error":"write response error","message":"unable to reply: internal server error"}`}},
 	}
 
 	for _, testCase := range testCases {
@@ -123,7 +132,7 @@ type serviceMock struct {
 	mock.Mock
 }
 
-func (m *serviceMock) Access(userID int) (*authorization.Access, error) {
+func (m *serviceMock) Access(_ context.Context, userID int) (*authorization.Access, error) {
 	args := m.Called(userID)
 	val := args.Get(0)
 	if val == nil {
@@ -132,6 +141,24 @@ func (m *serviceMock) Access(userID int) (*authorization.Access, error) {
 	return val.(*authorization.Access), args.Error(1)
 }
 
+func (m *serviceMock) BatchAccess(_ context.Context, userIDs []int) (map[int]*batchAccessItem, error) {
+	args := m.Called(userIDs)
+	val := args.Get(0)
+	if val == nil {
+		return nil, args.Error(1)
+	}
+	return val.(map[int]*batchAccessItem), args.Error(1)
+}
+
+func (m *serviceMock) Hierarchy(_ context.Context, access *authorization.Access) (map[string]*entityHierarchy, error) {
+	args := m.Called(access)
+	val := args.Get(0)
+	if val == nil {
+		return nil, args.Error(1)
+	}
+	return val.(map[string]*entityHierarchy), args.Error(1)
+}
+
 func TestHandle(t *testing.T) {
 	testCases := []struct {
 		userID     string
@@ -142,15 +169,16 @@ func TestHandle(t *testing.T) {
 	}{
 		{userID: "108a", respWriter: httptest.NewRecorder(),
 			service: &serviceMock{},
-			status:  http.StatusInternalServerError,
+			status:  http.StatusBadRequest,
 			validate: func(w http.ResponseWriter, logs string) {
-				assert.Equal(t, `{"level":"error","rid":"","user_id":"108a","message":"userID has incorrect value"}`, strings.TrimSpace(logs))
+				assert.Equal(t, `{"level":"warn","rid":"","error":"userID has incorrect value: strconv.Atoi: parsing \"108a\": invalid syntax","code":"INVALID_REQUEST","message":"userID has incorrect value"}`, strings.TrimSpace(logs))
+				assert.Contains(t, w.(*httptest.ResponseRecorder).Body.String(), `"code":"INVALID_REQUEST"`)
 			}},
 		{userID: "108a", respWriter: &responserWriterMock{}, status: 0,
 			service: &serviceMock{},
 			validate: func(w http.ResponseWriter, logs string) {
 				split := strings.Split(logs, "\n")
-				assert.Equal(t, `{"level":"error","rid":"","user_id":"108a","message":"userID has incorrect value"}`, split[0])
+				assert.Equal(t, `{"level":"warn","rid":"","error":"userID has incorrect value: strconv.Atoi: parsing \"108a\": invalid syntax","code":"INVALID_REQUEST","message":"userID has incorrect value"}`, split[0])
 				assert.Equal(t, `{"level":"warn","rid":"","error":"write response error","message":"unable to reply: userID has incorrect value"}`, split[1])
 			}},
 		{userID: "108", respWriter: httptest.NewRecorder(),
@@ -161,7 +189,8 @@ func TestHandle(t *testing.T) {
 			}(),
 			status: http.StatusInternalServerError,
 			validate: func(w http.ResponseWriter, logs string) {
-				assert.Equal(t, `{"level":"warn","rid":"","error":"unable to fetch access data for userID 108; err=service failed","user_id":108,"message":"unable to fetch access data"}`, strings.TrimSpace(logs))
+				assert.Equal(t, `{"level":"error","rid":"","user_id":108,"error":"service failed","code":"INTERNAL","message":"internal server error"}`, strings.TrimSpace(logs))
+				assert.NotContains(t, w.(*httptest.ResponseRecorder).Body.String(), "service failed")
 			}},
 		{userID: "108", respWriter: httptest.NewRecorder(),
 			service: func() Service {
@@ -212,6 +241,340 @@ func TestHandle(t *testing.T) {
 
 }
 
+func TestHandleExpand(t *testing.T) {
+	access := &authorization.Access{Admin: &authorization.AdminType{Ent: []int64{1}}}
+	hierarchy := map[string]*entityHierarchy{"Admin": {Ent: []int64{2}, Cls: []int64{3}}}
+	testCases := []struct {
+		name     string
+		expand   string
+		service  Service
+		status   int
+		validate func(*httptest.ResponseRecorder, string)
+	}{
+		{name: "unknown expand value", expand: "children", service: &serviceMock{}, status: http.StatusBadRequest,
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, logs, `"code":"INVALID_REQUEST","message":"expand has incorrect value"`)
+			}},
+		{name: "hierarchy error", expand: expandHierarchy, status: http.StatusInternalServerError,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("Access", 108).Return(access, nil).Once()
+				m.On("Hierarchy", access).Return(nil, errors.New("hierarchy failed")).Once()
+				return m
+			}(),
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, logs, "hierarchy failed")
+			}},
+		{name: "hierarchy", expand: expandHierarchy, status: http.StatusOK,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("Access", 108).Return(access, nil).Once()
+				m.On("Hierarchy", access).Return(hierarchy, nil).Once()
+				return m
+			}(),
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, w.Body.String(), `{"SuperUser":false,"Admin":{"ent":[1]},"hierarchy":{"Admin":{"ent":[2],"cls":[3]}}}`)
+			}},
+	}
+
+	for _, testCase := range testCases {
+		var buf bytes.Buffer
+		ctx := &authrlib.AppContext{Logger: &authrlib.AppLogger{Logger: zerolog.New(&buf)}}
+		handlerFunc := NewAccessHandler(ctx, testCase.service)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/test/108?expand="+testCase.expand, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams = chi.RouteParams{Keys: []string{"userID"}, Values: []string{"108"}}
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		txn := (*newrelicApp()).StartTransaction("/test/108", w, r)
+		r = r.WithContext(context.WithValue(r.Context(), bootstraputils.ContextKey("txn"), txn))
+
+		handlerFunc.ServeHTTP(w, r)
+
+		assert.Equal(t, testCase.status, w.Code, testCase.name)
+		testCase.validate(w, buf.String())
+		testCase.service.(*serviceMock).AssertExpectations(t)
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestNewBatchAccessHandler(t *testing.T) {
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(&authrlib.Config{}).Once()
+	ctx := &authrlib.AppContext{ConfigService: mockConfigService}
+	handler := NewBatchAccessHandler(ctx, &serviceMock{})
+	assert.NotNil(t, handler)
+	mockConfigService.AssertExpectations(t)
+}
+
+func TestBatchHandle(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		service  Service
+		status   int
+		validate func(*httptest.ResponseRecorder, string)
+	}{
+		{name: "invalid body", body: `{"userIds": "abc"}`,
+			service: &serviceMock{},
+			status:  http.StatusBadRequest,
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, logs, `"code":"INVALID_REQUEST","message":"unable to decode request body`)
+			}},
+		{name: "body too large", body: `{"userIds": [` + strings.Repeat(" ", 20000) + `108]}`,
+			service: &serviceMock{},
+			status:  http.StatusRequestEntityTooLarge,
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, w.Body.String(), `"code":"REQUEST_TOO_LARGE"`)
+			}},
+		{name: "service error", body: `{"userIds": [108, 109]}`,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("BatchAccess", []int{108, 109}).Return(nil, errors.New("service failed")).Once()
+				return m
+			}(),
+			status: http.StatusInternalServerError,
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Equal(t, `{"level":"error","rid":"","users":2,"error":"service failed","code":"INTERNAL","message":"internal server error"}`, strings.TrimSpace(logs))
+			}},
+		{name: "success", body: `{"userIds": [108, 109, 108]}`,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("BatchAccess", []int{108, 109}).Return(map[int]*batchAccessItem{
+					108: {Status: http.StatusOK, Access: &authorization.Access{SuperUser: true,
+						TeamMember: &authorization.TeamMemberType{Kid: []int64{70}}}},
+					109: {Status: http.StatusNotFound, Code: authrerr.UserNotFound, Message: errNotFound.Error()},
+				}, nil).Once()
+				return m
+			}(),
+			status: http.StatusOK,
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, w.Body.String(), `"108":{"status":200,"access":{"SuperUser":true,"TeamMember":{"kid":[70]}}}`)
+				assert.Contains(t, w.Body.String(), `"109":{"status":404,"code":"USER_NOT_FOUND","message":"user not found"}`)
+			}},
+	}
+
+	for _, testCase := range testCases {
+		var buf bytes.Buffer
+		logger := &authrlib.AppLogger{Logger: zerolog.New(&buf)}
+		mockConfigService := &configServiceMock{}
+		mockConfigService.On("Config").Return(&authrlib.Config{})
+		ctx := &authrlib.AppContext{Logger: logger, ConfigService: mockConfigService}
+
+		handlerFunc := (&accessHandler{ctx: ctx, service: testCase.service}).batchHandlerFunc()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/test/batch", strings.NewReader(testCase.body))
+		txn := (*newrelicApp()).StartTransaction("/test/batch", w, r)
+		r = r.WithContext(context.WithValue(r.Context(), bootstraputils.ContextKey("txn"), txn))
+		event := &authrlib.AuditEvent{}
+		r = r.WithContext(authrlib.WithAuditEvent(r.Context(), event))
+
+		handlerFunc.ServeHTTP(w, r)
+
+		assert.Equal(t, testCase.status, w.Code)
+		testCase.validate(w, buf.String())
+		if testCase.status == http.StatusOK {
+			assert.Equal(t, []int{108, 109}, event.UserIDs)
+			assert.Equal(t, []int64{70}, event.ChildIDs)
+		}
+		testCase.service.(*serviceMock).AssertExpectations(t)
+
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestCheckHandle(t *testing.T) {
+	access := &authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{5}},
+		TeamMember: &authorization.TeamMemberType{Kid: []int64{70}}}
+	testCases := []struct {
+		name     string
+		userID   string
+		body     string
+		service  Service
+		status   int
+		validate func(*httptest.ResponseRecorder, string)
+		audit    *authrlib.AuditEvent
+	}{
+		{name: "incorrect user id", userID: "108a", body: `{}`, service: &serviceMock{}, status: http.StatusBadRequest,
+			validate: func(*httptest.ResponseRecorder, string) {}},
+		{name: "malformed body", userID: "108", body: `{`, service: &serviceMock{}, status: http.StatusBadRequest,
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, logs, `"code":"INVALID_REQUEST","message":"unexpected EOF"`)
+			}},
+		{name: "unknown resource type", userID: "108", body: `{"resourceType":"site","resourceId":5,"action":"read"}`,
+			service: &serviceMock{}, status: http.StatusBadRequest,
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, logs, `"error":"unknown resource type"`)
+			}},
+		{name: "user not found", userID: "108", body: `{"resourceType":"class","resourceId":5,"action":"read"}`,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("Access", 108).Return(nil, errNotFound).Once()
+				return m
+			}(),
+			status:   http.StatusNotFound,
+			validate: func(*httptest.ResponseRecorder, string) {}},
+		{name: "allowed", userID: "108", body: `{"resourceType":"class","resourceId":5,"action":"write"}`,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("Access", 108).Return(access, nil).Once()
+				return m
+			}(),
+			status: http.StatusOK,
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, w.Body.String(), `{"allowed":true,"role":"Teacher"}`)
+			},
+			audit: &authrlib.AuditEvent{ResourceType: "class", ResourceID: 5, ResourceAction: "write", Role: "Teacher",
+				Decision: authrlib.AuditAllow}},
+		{name: "denied", userID: "108", body: `{"resourceType":"class","resourceId":6,"action":"read"}`,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("Access", 108).Return(access, nil).Once()
+				return m
+			}(),
+			status: http.StatusOK,
+			validate: func(w *httptest.ResponseRecorder, logs string) {
+				assert.Contains(t, w.Body.String(), `{"allowed":false}`)
+			},
+			audit: &authrlib.AuditEvent{ResourceType: "class", ResourceID: 6, ResourceAction: "read", Decision: authrlib.AuditDeny}},
+		{name: "child is audited with child IDs", userID: "108", body: `{"resourceType":"child","resourceId":70,"action":"read"}`,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("Access", 108).Return(access, nil).Once()
+				return m
+			}(),
+			status:   http.StatusOK,
+			validate: func(*httptest.ResponseRecorder, string) {},
+			audit: &authrlib.AuditEvent{ResourceType: "child", ResourceAction: "read", Role: "TeamMember",
+				Decision: authrlib.AuditAllow, ChildIDs: []int64{70}}},
+	}
+
+	for _, testCase := range testCases {
+		var buf bytes.Buffer
+		ctx := &authrlib.AppContext{Logger: &authrlib.AppLogger{Logger: zerolog.New(&buf)}}
+		handlerFunc := NewAccessCheckHandler(ctx, testCase.service)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/test/"+testCase.userID+"/check", strings.NewReader(testCase.body))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams = chi.RouteParams{Keys: []string{"userID"}, Values: []string{testCase.userID}}
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		txn := (*newrelicApp()).StartTransaction("/test/check", w, r)
+		r = r.WithContext(context.WithValue(r.Context(), bootstraputils.ContextKey("txn"), txn))
+		event := &authrlib.AuditEvent{}
+		r = r.WithContext(authrlib.WithAuditEvent(r.Context(), event))
+
+		handlerFunc.ServeHTTP(w, r)
+
+		assert.Equal(t, testCase.status, w.Code, testCase.name)
+		testCase.validate(w, buf.String())
+		if testCase.audit != nil {
+			assert.Equal(t, testCase.audit, event, testCase.name)
+		}
+		testCase.service.(*serviceMock).AssertExpectations(t)
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestCheckHandlerEvaluationError(t *testing.T) {
+	defer func() { evaluate = permission.Evaluate }()
+	evaluate = func(*authorization.Access, permission.Request) (permission.Decision, error) {
+		return permission.Decision{}, permission.ErrUnknownAction
+	}
+	service := &serviceMock{}
+	service.On("Access", 108).Return(&authorization.Access{}, nil).Once()
+	var buf bytes.Buffer
+	ctx := &authrlib.AppContext{Logger: &authrlib.AppLogger{Logger: zerolog.New(&buf)}}
+	handlerFunc := NewAccessCheckHandler(ctx, service)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/test/108/check", strings.NewReader(`{"resourceType":"class","resourceId":5,"action":"read"}`))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams = chi.RouteParams{Keys: []string{"userID"}, Values: []string{"108"}}
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	txn := (*newrelicApp()).StartTransaction("/test/check", w, r)
+	r = r.WithContext(context.WithValue(r.Context(), bootstraputils.ContextKey("txn"), txn))
+
+	handlerFunc.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"INVALID_REQUEST"`)
+	assert.Contains(t, buf.String(), `"user_id":108`)
+	assert.NotContains(t, buf.String(), "permission checked")
+	service.AssertExpectations(t)
+}
+
+func TestInvalidationHandle(t *testing.T) {
+	testCases := []struct {
+		name    string
+		userID  string
+		service Service
+		status  int
+	}{
+		{name: "incorrect user id", userID: "108a", service: &serviceMock{}, status: http.StatusBadRequest},
+		{name: "cache disabled", userID: "108", service: &serviceMock{}, status: http.StatusOK},
+		{name: "cache purged", userID: "108", status: http.StatusOK,
+			service: func() Service {
+				s := newCachingService(&serviceMock{}, newLRUCache(time.Minute, 10), time.Minute, nil)
+				s.cache.Set(108, &authorization.Access{})
+				return s
+			}()},
+	}
+
+	for _, testCase := range testCases {
+		var buf bytes.Buffer
+		ctx := &authrlib.AppContext{Logger: &authrlib.AppLogger{Logger: zerolog.New(&buf)}}
+		handlerFunc := NewCacheInvalidationHandler(ctx, testCase.service)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("DELETE", "/test/"+testCase.userID+"/cache", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams = chi.RouteParams{Keys: []string{"userID"}, Values: []string{testCase.userID}}
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		txn := (*newrelicApp()).StartTransaction("/test/cache", w, r)
+		r = r.WithContext(context.WithValue(r.Context(), bootstraputils.ContextKey("txn"), txn))
+
+		handlerFunc.ServeHTTP(w, r)
+
+		assert.Equal(t, testCase.status, w.Code, testCase.name)
+		if s, ok := testCase.service.(*cachingService); ok {
+			_, cached := s.cache.Get(108)
+			assert.False(t, cached, testCase.name)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestDecodeBatchAccessRequest(t *testing.T) {
+	testCases := []struct {
+		name    string
+		body    string
+		userIDs []int
+		err     string
+	}{
+		{name: "malformed json", body: `{`, err: "unable to decode request body: unexpected EOF"},
+		{name: "empty list", body: `{"userIds": []}`, err: "userIds should not be empty"},
+		{name: "negative user id", body: `{"userIds": [1, -2]}`, err: "userID has incorrect value: -2"},
+		{name: "limit exceeded", body: `{"userIds": [1, 2, 3, 4]}`, err: "too many userIds: 4, max allowed 3"},
+		{name: "duplicates are removed", body: `{"userIds": [3, 1, 3, 2, 1]}`, userIDs: []int{3, 1, 2}},
+		{name: "body too large", body: `{"userIds": [1` + strings.Repeat(", 1", 400) + `]}`,
+			err: "request body exceeds 1120 bytes: http: request body too large"},
+	}
+	for _, testCase := range testCases {
+		r := httptest.NewRequest("POST", "/test/batch", strings.NewReader(testCase.body))
+		userIDs, err := decodeBatchAccessRequest(httptest.NewRecorder(), r, 3)
+		if testCase.err != "" {
+			assert.EqualError(t, err, testCase.err, testCase.name)
+		} else {
+			assert.NoError(t, err, testCase.name)
+			assert.Equal(t, testCase.userIDs, userIDs, testCase.name)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
 func newrelicApp() *newrelic.Application {
 	newrelicConfig := newrelic.NewConfig("testapp - test", "1234567890123456789012345678901234567890")
 	newrelicConfig.Enabled = false
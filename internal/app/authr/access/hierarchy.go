@@ -0,0 +1,231 @@
+package access
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+)
+
+// value of `expand` query parameter which adds hierarchy to access response
+const expandHierarchy = "hierarchy"
+
+// defaults of hierarchy expansion
+const (
+	defaultHierarchyMaxDepth   = 3
+	defaultHierarchyMaxResults = 2000
+)
+
+// max number of IDs passed as parameters of a single statement, MSSQL allows at most 2100
+// parameters and every level of found IDs is used as parameters of the next query
+const maxQueryIDs = 2000
+
+// levels of CCNET entity hierarchy, from top to bottom
+const (
+	organizationLevel = iota
+	programLevel
+	siteLevel
+	classLevel
+)
+
+// descendants of entities of a single admin role
+type entityHierarchy struct {
+	Ent       []int64 `json:"ent"`
+	Cls       []int64 `json:"cls"`
+	Truncated bool    `json:"truncated,omitempty"`
+}
+
+// access document extended with descendants of admin entities
+type expandedAccess struct {
+	*authorization.Access
+	Hierarchy map[string]*entityHierarchy `json:"hierarchy"`
+}
+
+// resolves levels of the given entities; %s is replaced by placeholders
+const entityRootsQuery = `
+	SELECT el.OrganizationID, el.ProgramID, el.SiteID
+	FROM dbo.G2_EntityLink el WITH (NOLOCK)
+WHERE el.EntityID IN (%s)`
+
+// children of the level; the first parameter is max number of rows, %s is replaced by placeholders
+var childrenQueries = map[int]string{
+	organizationLevel: `
+	SELECT TOP (?) p.ProgramID
+	FROM dbo.CC_Programs p WITH (NOLOCK)
+WHERE p.OrganizationID IN (%s)`,
+	programLevel: `
+	SELECT TOP (?) s.SiteID
+	FROM dbo.CC_Sites s WITH (NOLOCK)
+WHERE s.ProgramID IN (%s)`,
+	siteLevel: `
+	SELECT TOP (?) c.ClassID
+	FROM dbo.CC_Classes c WITH (NOLOCK)
+WHERE c.SiteID IN (%s)`,
+}
+
+// entity IDs of programs and sites; %s is replaced by placeholders
+var entityQueries = map[int]string{
+	programLevel: `
+	SELECT el.EntityID
+	FROM dbo.G2_EntityLink el WITH (NOLOCK)
+WHERE el.ProgramID IN (%s)`,
+	siteLevel: `
+	SELECT el.EntityID
+	FROM dbo.G2_EntityLink el WITH (NOLOCK)
+WHERE el.SiteID IN (%s)`,
+}
+
+// QueryEntityHierarchy walks organization -> program -> site -> class relationships
+// starting from entityIDs; every entity is expanded at most maxDepth levels down and
+// no more than maxResults descendants are returned
+func (r *accessRepo) QueryEntityHierarchy(ctx context.Context, entityIDs []int64, maxDepth, maxResults int) (result *entityHierarchy, err error) {
+	if len(entityIDs) == 0 || maxDepth <= 0 {
+		return &entityHierarchy{Ent: []int64{}, Cls: []int64{}}, nil
+	}
+	// the whole walk runs on a single node
+	err = r.read(ctx, func(ctx context.Context, db *sql.DB) (err error) {
+		result, err = r.queryEntityHierarchy(ctx, db, entityIDs, maxDepth, maxResults)
+		return err
+	})
+	return result, err
+}
+
+func (r *accessRepo) queryEntityHierarchy(ctx context.Context, db *sql.DB, entityIDs []int64, maxDepth, maxResults int) (*entityHierarchy, error) {
+	result := &entityHierarchy{Ent: []int64{}, Cls: []int64{}}
+	frontier := make(map[int][]int64)
+	for _, ids := range chunks(entityIDs, maxQueryIDs) {
+		roots, err := r.queryEntityRoots(ctx, db, ids)
+		if err != nil {
+			return nil, err
+		}
+		for level, ids := range roots {
+			frontier[level] = append(frontier[level], ids...)
+		}
+	}
+	// roots are visited, but aren't descendants
+	visited := map[int]map[int64]interface{}{programLevel: {}, siteLevel: {}, classLevel: {}}
+	for level, ids := range frontier {
+		for _, id := range ids {
+			if level != organizationLevel {
+				visited[level][id] = struct{}{}
+			}
+		}
+	}
+	descendants := map[int]map[int64]interface{}{programLevel: {}, siteLevel: {}, classLevel: {}}
+	found := 0
+	for depth := 0; depth < maxDepth && !result.Truncated; depth++ {
+		next := make(map[int][]int64)
+		for level := organizationLevel; level < classLevel && !result.Truncated; level++ {
+			for _, ids := range chunks(frontier[level], maxQueryIDs) {
+				// one extra row tells that the cap is exceeded
+				args := append([]interface{}{maxResults - found + 1}, int64Args(ids)...)
+				children, err := r.queryIDs(ctx, db, fmt.Sprintf(r.dialect.childrenQueries[level], r.dialect.placeholders(2, len(ids))), args)
+				if err != nil {
+					return nil, err
+				}
+				for _, id := range children {
+					if _, ok := visited[level+1][id]; ok {
+						continue
+					}
+					if found == maxResults {
+						result.Truncated = true
+						break
+					}
+					found++
+					visited[level+1][id] = struct{}{}
+					descendants[level+1][id] = struct{}{}
+					next[level+1] = append(next[level+1], id)
+				}
+				if result.Truncated {
+					break
+				}
+			}
+		}
+		frontier = next
+	}
+	result.Cls = keys(descendants[classLevel])
+	for _, level := range []int{programLevel, siteLevel} {
+		if len(descendants[level]) == 0 {
+			continue
+		}
+		for _, ids := range chunks(keys(descendants[level]), maxQueryIDs) {
+			entities, err := r.queryIDs(ctx, db, fmt.Sprintf(r.dialect.entityQueries[level], r.dialect.placeholders(1, len(ids))), int64Args(ids))
+			if err != nil {
+				return nil, err
+			}
+			result.Ent = append(result.Ent, entities...)
+		}
+	}
+	return result, nil
+}
+
+// groups the given entities by their hierarchy level
+func (r *accessRepo) queryEntityRoots(ctx context.Context, db *sql.DB, entityIDs []int64) (map[int][]int64, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(r.dialect.entityRootsQuery, r.dialect.placeholders(1, len(entityIDs))), int64Args(entityIDs)...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+	roots := make(map[int][]int64)
+	for rows.Next() {
+		var organizationID, programID, siteID sql.NullInt64
+		if err = rows.Scan(&organizationID, &programID, &siteID); err != nil {
+			return nil, queryError(ctx, err)
+		}
+		switch {
+		case siteID.Valid:
+			roots[siteLevel] = append(roots[siteLevel], siteID.Int64)
+		case programID.Valid:
+			roots[programLevel] = append(roots[programLevel], programID.Int64)
+		case organizationID.Valid:
+			roots[organizationLevel] = append(roots[organizationLevel], organizationID.Int64)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return roots, nil
+}
+
+// runs a query which returns a single column of IDs
+func (r *accessRepo) queryIDs(ctx context.Context, db *sql.DB, query string, args []interface{}) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, queryError(ctx, err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return ids, nil
+}
+
+// splits ids into chunks of at most size IDs
+func chunks(ids []int64, size int) [][]int64 {
+	var result [][]int64
+	for len(ids) > size {
+		result = append(result, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		result = append(result, ids)
+	}
+	return result
+}
+
+func int64Args(ids []int64) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
@@ -0,0 +1,116 @@
+package access
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryEntityHierarchy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create db mock: %v", err)
+	}
+	defer db.Close()
+	repo := &accessRepo{db: &dbReaderMock{db}, dialect: mssqlDialect, timeout: time.Second}
+
+	rootsQuery := regexp.QuoteMeta(fmt.Sprintf(entityRootsQuery, "?, ?"))
+	childrenQuery := func(level int) string { return regexp.QuoteMeta(fmt.Sprintf(childrenQueries[level], "?")) }
+	entityQuery := func(level int) string { return regexp.QuoteMeta(fmt.Sprintf(entityQueries[level], "?")) }
+	rootColumns := []string{"OrganizationID", "ProgramID", "SiteID"}
+
+	// nothing to expand
+	result, err := repo.QueryEntityHierarchy(context.Background(), nil, 3, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, &entityHierarchy{Ent: []int64{}, Cls: []int64{}}, result)
+
+	// roots query error
+	mock.ExpectQuery(rootsQuery).WithArgs(1, 2).WillReturnError(errors.New("roots error"))
+	_, err = repo.QueryEntityHierarchy(context.Background(), []int64{1, 2}, 3, 10)
+	assert.EqualError(t, err, "roots error")
+
+	// entity 1 is an organization, entity 2 is a site
+	expectRoots := func() {
+		mock.ExpectQuery(rootsQuery).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(rootColumns).
+			AddRow(10, nil, nil).
+			AddRow(nil, nil, 30))
+	}
+
+	// children query error
+	expectRoots()
+	mock.ExpectQuery(childrenQuery(organizationLevel)).WithArgs(11, 10).WillReturnError(errors.New("children error"))
+	_, err = repo.QueryEntityHierarchy(context.Background(), []int64{1, 2}, 3, 10)
+	assert.EqualError(t, err, "children error")
+
+	// full walk
+	expectRoots()
+	mock.ExpectQuery(childrenQuery(organizationLevel)).WithArgs(11, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"ProgramID"}).AddRow(20))
+	mock.ExpectQuery(childrenQuery(siteLevel)).WithArgs(10, 30).
+		WillReturnRows(sqlmock.NewRows([]string{"ClassID"}).AddRow(300))
+	mock.ExpectQuery(childrenQuery(programLevel)).WithArgs(9, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"SiteID"}).AddRow(30).AddRow(31))
+	mock.ExpectQuery(childrenQuery(siteLevel)).WithArgs(8, 31).
+		WillReturnRows(sqlmock.NewRows([]string{"ClassID"}).AddRow(301))
+	mock.ExpectQuery(entityQuery(programLevel)).WithArgs(20).
+		WillReturnRows(sqlmock.NewRows([]string{"EntityID"}).AddRow(2000))
+	mock.ExpectQuery(entityQuery(siteLevel)).WithArgs(31).
+		WillReturnRows(sqlmock.NewRows([]string{"EntityID"}).AddRow(3001))
+	result, err = repo.QueryEntityHierarchy(context.Background(), []int64{1, 2}, 3, 10)
+	assert.NoError(t, err)
+	sort.Slice(result.Cls, func(i, j int) bool { return result.Cls[i] < result.Cls[j] })
+	assert.Equal(t, &entityHierarchy{Ent: []int64{2000, 3001}, Cls: []int64{300, 301}}, result)
+
+	// depth limit and result cap
+	expectRoots()
+	mock.ExpectQuery(childrenQuery(organizationLevel)).WithArgs(2, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"ProgramID"}).AddRow(20).AddRow(21))
+	mock.ExpectQuery(entityQuery(programLevel)).WithArgs(20).
+		WillReturnRows(sqlmock.NewRows([]string{"EntityID"}).AddRow(2000))
+	result, err = repo.QueryEntityHierarchy(context.Background(), []int64{1, 2}, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, &entityHierarchy{Ent: []int64{2000}, Cls: []int64{}, Truncated: true}, result)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueryEntityHierarchyChunks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create db mock: %v", err)
+	}
+	defer db.Close()
+	repo := &accessRepo{db: &dbReaderMock{db}, dialect: mssqlDialect, timeout: time.Second}
+
+	// IDs over the statement parameter limit are queried in chunks
+	entityIDs, args := make([]int64, maxQueryIDs+1), make([]driver.Value, maxQueryIDs)
+	for i := range entityIDs {
+		entityIDs[i] = int64(i + 1)
+		if i < maxQueryIDs {
+			args[i] = entityIDs[i]
+		}
+	}
+	rootColumns := []string{"OrganizationID", "ProgramID", "SiteID"}
+	mock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf(entityRootsQuery, mssqlDialect.placeholders(1, maxQueryIDs)))).
+		WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows(rootColumns))
+	mock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf(entityRootsQuery, "?"))).WithArgs(maxQueryIDs + 1).
+		WillReturnRows(sqlmock.NewRows(rootColumns).AddRow(nil, nil, 30))
+	mock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf(childrenQueries[siteLevel], "?"))).WithArgs(11, 30).
+		WillReturnRows(sqlmock.NewRows([]string{"ClassID"}).AddRow(300))
+	result, err := repo.QueryEntityHierarchy(context.Background(), entityIDs, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, &entityHierarchy{Ent: []int64{}, Cls: []int64{300}}, result)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Nil(t, chunks(nil, 2))
+	assert.Equal(t, [][]int64{{1, 2}, {3, 4}, {5}}, chunks([]int64{1, 2, 3, 4, 5}, 2))
+}
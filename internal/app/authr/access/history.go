@@ -0,0 +1,303 @@
+package access
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/app/authr/webhook"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/authorization-service/pkg/permission"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/gamegos/jsend"
+	"github.com/go-chi/chi"
+)
+
+// defaults of history endpoint pagination
+const (
+	defaultHistoryPageSize    = 20
+	defaultHistoryMaxPageSize = 100
+)
+
+// resolved access flattened to sorted roles and IDs granted by them, comparable between versions
+type accessSnapshot struct {
+	Version int      `json:"version"`
+	Hash    string   `json:"hash"`
+	Roles   []string `json:"roles"`
+	// IDs by role and kind, e.g. "Teacher.cls"
+	Grants map[string][]int64 `json:"grants"`
+}
+
+// difference between two subsequent versions of user's access
+type accessChange struct {
+	Version      int                `json:"version"`
+	Hash         string             `json:"hash"`
+	ChangedAt    time.Time          `json:"changedAt"`
+	RolesAdded   []string           `json:"rolesAdded,omitempty"`
+	RolesRemoved []string           `json:"rolesRemoved,omitempty"`
+	Added        map[string][]int64 `json:"added,omitempty"`
+	Removed      map[string][]int64 `json:"removed,omitempty"`
+}
+
+// page of history endpoint
+type historyResponse struct {
+	UserID  int             `json:"userId"`
+	Changes []*accessChange `json:"changes"`
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+}
+
+// builds snapshot of access with its hash, version is assigned by store
+func newAccessSnapshot(access *authorization.Access) *accessSnapshot {
+	snapshot := &accessSnapshot{Roles: []string{}, Grants: make(map[string][]int64)}
+	if access.SuperUser {
+		snapshot.Roles = append(snapshot.Roles, permission.RoleSuperUser)
+	}
+	grant := func(role string, present bool, ids map[string][]int64) {
+		if !present {
+			return
+		}
+		snapshot.Roles = append(snapshot.Roles, role)
+		for kind, kindIDs := range ids {
+			if len(kindIDs) == 0 {
+				continue
+			}
+			sorted := append([]int64{}, kindIDs...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			snapshot.Grants[role+"."+kind] = sorted
+		}
+	}
+	for role, teacher := range map[string]*authorization.TeacherType{permission.RoleTeacher: access.Teacher,
+		permission.RoleCoTeacher: access.CoTeacher, permission.RoleAssistantTeacher: access.AssistantTeacher} {
+		grant(role, teacher != nil, map[string][]int64{"cls": permission.TeacherClasses(teacher)})
+	}
+	if access.TeamMember != nil {
+		grant(permission.RoleTeamMember, true, map[string][]int64{"kid": access.TeamMember.Kid})
+	}
+	for role, admin := range map[string]*authorization.AdminType{permission.RoleAdmin: access.Admin,
+		permission.RoleVOAdmin: access.VOAdmin, permission.RoleVONoChildAdmin: access.VONoChildAdmin} {
+		grant(role, admin != nil, map[string][]int64{"ent": permission.AdminEntities(admin)})
+	}
+	for role, admin := range map[string]*authorization.FsAdminType{permission.RoleFSAdmin: access.FSAdmin,
+		permission.RoleFSVOAdmin: access.FSVOAdmin} {
+		grant(role, admin != nil, map[string][]int64{"ent": permission.FSAdminEntities(admin), "fundSrc": permission.FundSources(admin)})
+	}
+	sort.Strings(snapshot.Roles)
+	// roles and grants are sorted, json encodes map keys sorted, so the hash is stable
+	bytes, _ := json.Marshal(struct {
+		Roles  []string           `json:"roles"`
+		Grants map[string][]int64 `json:"grants"`
+	}{snapshot.Roles, snapshot.Grants})
+	sum := sha256.Sum256(bytes)
+	snapshot.Hash = hex.EncodeToString(sum[:])
+	return snapshot
+}
+
+// diff of previous and current snapshots, previous one is nil for the first version
+func diffSnapshots(previous, current *accessSnapshot, at time.Time) *accessChange {
+	if previous == nil {
+		previous = &accessSnapshot{}
+	}
+	change := &accessChange{Version: current.Version, Hash: current.Hash, ChangedAt: at.UTC()}
+	change.RolesAdded, change.RolesRemoved = diffStrings(previous.Roles, current.Roles)
+	keys := make(map[string]struct{})
+	for key := range previous.Grants {
+		keys[key] = struct{}{}
+	}
+	for key := range current.Grants {
+		keys[key] = struct{}{}
+	}
+	for key := range keys {
+		added, removed := diffIDs(previous.Grants[key], current.Grants[key])
+		if len(added) > 0 {
+			if change.Added == nil {
+				change.Added = make(map[string][]int64)
+			}
+			change.Added[key] = added
+		}
+		if len(removed) > 0 {
+			if change.Removed == nil {
+				change.Removed = make(map[string][]int64)
+			}
+			change.Removed[key] = removed
+		}
+	}
+	return change
+}
+
+// returns elements of current which aren't in previous and vice versa, inputs are sorted
+func diffStrings(previous, current []string) (added, removed []string) {
+	seen := make(map[string]bool, len(previous))
+	for _, s := range previous {
+		seen[s] = true
+	}
+	for _, s := range current {
+		if !seen[s] {
+			added = append(added, s)
+		}
+		delete(seen, s)
+	}
+	for _, s := range previous {
+		if seen[s] {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+// the same as diffStrings for IDs
+func diffIDs(previous, current []int64) (added, removed []int64) {
+	seen := make(map[int64]bool, len(previous))
+	for _, id := range previous {
+		seen[id] = true
+	}
+	for _, id := range current {
+		if !seen[id] {
+			added = append(added, id)
+		}
+		delete(seen, id)
+	}
+	for _, id := range previous {
+		if seen[id] {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+// Service decorator which records changes of access resolved by the decorated service, users who are
+// no longer found or allowed lose all access; history is best effort, store failures are logged and never fail lookups
+type historyService struct {
+	Service
+	store     HistoryStore
+	publisher webhook.Publisher // notified about changes, nil disables notifications
+	now       func() time.Time
+	logger    *authrlib.AppLogger
+}
+
+func newHistoryService(service Service, store HistoryStore, publisher webhook.Publisher, logger *authrlib.AppLogger) *historyService {
+	return &historyService{Service: service, store: store, publisher: publisher, now: time.Now, logger: logger}
+}
+
+func (s *historyService) Access(ctx context.Context, userID int) (*authorization.Access, error) {
+	access, err := s.Service.Access(ctx, userID)
+	if err == nil {
+		s.record(ctx, userID, access)
+	} else if errors.Is(err, errNotFound) || errors.Is(err, errNotAllowed) {
+		s.revoke(ctx, userID)
+	}
+	return access, err
+}
+
+func (s *historyService) BatchAccess(ctx context.Context, userIDs []int) (map[int]*batchAccessItem, error) {
+	result, err := s.Service.BatchAccess(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for userID, item := range result {
+		switch {
+		case item.Status == http.StatusOK:
+			s.record(ctx, userID, item.Access)
+		case item.Code == errNotFound.Code || item.Code == errNotAllowed.Code:
+			s.revoke(ctx, userID)
+		}
+	}
+	return result, nil
+}
+
+func (s *historyService) record(ctx context.Context, userID int, access *authorization.Access) {
+	change, err := s.store.Record(ctx, userID, newAccessSnapshot(access), s.now())
+	s.notify(userID, change, err)
+}
+
+func (s *historyService) revoke(ctx context.Context, userID int) {
+	change, err := s.store.Revoke(ctx, userID, s.now())
+	s.notify(userID, change, err)
+}
+
+// logs recorded change and publishes it to subscribers
+func (s *historyService) notify(userID int, change *accessChange, err error) {
+	if err != nil {
+		s.logger.Warn().Err(err).Int("user_id", userID).Msg("unable to record access history")
+		return
+	}
+	if change == nil {
+		return
+	}
+	s.logger.Info().Int("user_id", userID).Int("version", change.Version).Msg("access changed")
+	// the first version is when user is seen first time, e.g. after restart with memory store
+	if s.publisher != nil && change.Version > 1 {
+		s.publisher.Publish(webhook.NewEvent(webhook.AccessChanged, userID, change))
+	}
+}
+
+// NewHistoryHandler creates new instance of handler which lists changes of user's access,
+// nil store means history is disabled
+func NewHistoryHandler(ctx *authrlib.AppContext, store HistoryStore) http.HandlerFunc {
+	return (&historyHandler{ctx, store}).handlerFunc()
+}
+
+// struct which produces http.HandlerFunc
+type historyHandler struct {
+	ctx   *authrlib.AppContext
+	store HistoryStore
+}
+
+func (hh *historyHandler) handlerFunc() http.HandlerFunc {
+	conf := hh.ctx.ConfigService.Config().History
+	pageSize, maxPageSize := conf.PageSize, conf.MaxPageSize
+	if maxPageSize <= 0 {
+		maxPageSize = defaultHistoryMaxPageSize
+	}
+	if pageSize <= 0 || pageSize > maxPageSize {
+		pageSize = defaultHistoryPageSize
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := hh.ctx.Logger.HandlerLogger(r)
+		if hh.store == nil {
+			replyError(authrerr.New(authrerr.HistoryDisabled, "access history is not enabled"), logger, w)
+			return
+		}
+		userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+		if err != nil {
+			replyError(authrerr.Wrap(err, authrerr.InvalidRequest, "userID has incorrect value"), logger, w)
+			return
+		}
+		offset, err := queryInt(r, "offset", 0)
+		if err != nil || offset < 0 {
+			replyError(authrerr.New(authrerr.InvalidRequest, "offset has incorrect value"), logger, w)
+			return
+		}
+		limit, err := queryInt(r, "limit", pageSize)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			replyError(authrerr.New(authrerr.InvalidRequest, "limit has incorrect value"), logger, w)
+			return
+		}
+		changes, total, err := hh.store.Changes(r.Context(), userID, offset, limit)
+		if err != nil {
+			replyAccessError(userID, authrerr.Wrap(err, authrerr.Internal, "unable to read access history"), logger, w)
+			return
+		}
+		resp := &historyResponse{UserID: userID, Changes: changes, Total: total, Offset: offset, Limit: limit}
+		if _, err = jsend.Wrap(w).Message("request completed").Data(resp).Status(http.StatusOK).Send(); err != nil {
+			logger.Warn().Err(err).Msg("unable to reply success")
+		}
+	}
+}
+
+// value of integer query parameter, def if it's absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,256 @@
+package access
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/go-redis/redis"
+)
+
+// historySchemaVersion is a part of every redis key of history, it has to be
+// increased whenever serialized shape of snapshots or changes changes
+const historySchemaVersion = 1
+
+// defaults of history store
+const (
+	defaultHistoryMaxEntries = 100
+	defaultHistoryMaxUsers   = 10000
+)
+
+// HistoryStore keeps changes of users' access
+type HistoryStore interface {
+	// Record stores snapshot as the next version if it differs from the last one,
+	// returns the change or nil if access hasn't changed
+	Record(ctx context.Context, userID int, snapshot *accessSnapshot, at time.Time) (*accessChange, error)
+	// Revoke records empty access as the next version of a user whose last version isn't empty,
+	// returns nil if the user has no history or no access already
+	Revoke(ctx context.Context, userID int, at time.Time) (*accessChange, error)
+	// Changes returns a page of user's changes, newest first, and the total number of them
+	Changes(ctx context.Context, userID, offset, limit int) ([]*accessChange, int, error)
+	// Close releases connections of store
+	Close() error
+}
+
+// NewHistoryStore creates configured history store, nil if history is disabled
+func NewHistoryStore(ctx *authrlib.AppContext) HistoryStore {
+	config := ctx.ConfigService.Config()
+	if !config.History.Enabled {
+		return nil
+	}
+	maxEntries := config.History.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultHistoryMaxEntries
+	}
+	if config.History.Store == authrlib.HistoryStoreRedis {
+		return newRedisHistoryStore(config.Cache.Redis, maxEntries)
+	}
+	maxUsers := config.History.MaxUsers
+	if maxUsers <= 0 {
+		maxUsers = defaultHistoryMaxUsers
+	}
+	return newMemoryHistoryStore(maxEntries, maxUsers)
+}
+
+// history of a single user kept by memoryHistoryStore
+type userHistory struct {
+	last    *accessSnapshot
+	changes []*accessChange // oldest first
+}
+
+// HistoryStore kept in the process, for development and single instance deployments;
+// history of the least recently used users is dropped when there are more than maxUsers of them
+type memoryHistoryStore struct {
+	mu         sync.RWMutex // guards histories, users is safe for concurrent use itself
+	users      *lru
+	maxEntries int
+	maxUsers   int
+}
+
+func newMemoryHistoryStore(maxEntries, maxUsers int) *memoryHistoryStore {
+	return &memoryHistoryStore{users: newLRU(0, maxUsers), maxEntries: maxEntries, maxUsers: maxUsers}
+}
+
+func (s *memoryHistoryStore) Record(_ context.Context, userID int, snapshot *accessSnapshot, at time.Time) (*accessChange, error) {
+	return s.record(userID, snapshot, at, false), nil
+}
+
+func (s *memoryHistoryStore) Revoke(_ context.Context, userID int, at time.Time) (*accessChange, error) {
+	return s.record(userID, newAccessSnapshot(&authorization.Access{}), at, true), nil
+}
+
+// appends snapshot to user's history, unknown user is skipped if onlyKnown is set
+func (s *memoryHistoryStore) record(userID int, snapshot *accessSnapshot, at time.Time, onlyKnown bool) *accessChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var history *userHistory
+	if value, ok := s.users.get(userID); ok {
+		history = value.(*userHistory)
+	} else if onlyKnown {
+		return nil
+	} else {
+		history = &userHistory{}
+		s.users.set(userID, history)
+	}
+	change := nextChange(history.last, snapshot, at)
+	if change == nil {
+		return nil
+	}
+	history.last = snapshot
+	history.changes = append(history.changes, change)
+	if len(history.changes) > s.maxEntries {
+		history.changes = history.changes[len(history.changes)-s.maxEntries:]
+	}
+	return change
+}
+
+func (s *memoryHistoryStore) Changes(_ context.Context, userID, offset, limit int) ([]*accessChange, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	changes := []*accessChange{}
+	value, ok := s.users.get(userID)
+	if !ok {
+		return changes, 0, nil
+	}
+	history := value.(*userHistory)
+	total := len(history.changes)
+	for i := total - 1 - offset; i >= 0 && len(changes) < limit; i-- {
+		changes = append(changes, history.changes[i])
+	}
+	return changes, total, nil
+}
+
+func (s *memoryHistoryStore) Close() error {
+	return nil
+}
+
+// assigns version to snapshot and diffs it with the last one, nil if hashes are equal
+func nextChange(last, snapshot *accessSnapshot, at time.Time) *accessChange {
+	snapshot.Version = 1
+	if last != nil {
+		if last.Hash == snapshot.Hash {
+			return nil
+		}
+		snapshot.Version = last.Version + 1
+	}
+	return diffSnapshots(last, snapshot, at)
+}
+
+// HistoryStore shared by replicas; the last snapshot and the list of changes,
+// newest first, are kept per user without expiration
+type redisHistoryStore struct {
+	client     *redis.Client
+	prefix     string // configured key prefix shared with access cache
+	maxEntries int
+}
+
+func newRedisHistoryStore(config authrlib.RedisConfig, maxEntries int) *redisHistoryStore {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+	prefix := config.KeyPrefix
+	if prefix == "" {
+		prefix = defaultRedisKeyPrefix
+	}
+	return &redisHistoryStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:         config.Address,
+			Password:     config.Password,
+			DB:           config.DB,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+		}),
+		prefix:     prefix,
+		maxEntries: maxEntries,
+	}
+}
+
+func (s *redisHistoryStore) Record(ctx context.Context, userID int, snapshot *accessSnapshot, at time.Time) (*accessChange, error) {
+	return s.record(ctx, userID, snapshot, at, false)
+}
+
+func (s *redisHistoryStore) Revoke(ctx context.Context, userID int, at time.Time) (*accessChange, error) {
+	return s.record(ctx, userID, newAccessSnapshot(&authorization.Access{}), at, true)
+}
+
+// compares and appends in a transaction, concurrent record of the same user fails it;
+// unknown user is skipped if onlyKnown is set
+func (s *redisHistoryStore) record(ctx context.Context, userID int, snapshot *accessSnapshot, at time.Time, onlyKnown bool) (*accessChange, error) {
+	lastKey, changesKey := s.keys(userID)
+	var change *accessChange
+	err := s.client.WithContext(ctx).Watch(func(tx *redis.Tx) error {
+		var last *accessSnapshot
+		bytes, err := tx.Get(lastKey).Bytes()
+		switch {
+		case err == redis.Nil && onlyKnown:
+			return nil
+		case err == redis.Nil:
+		case err != nil:
+			return err
+		default:
+			last = new(accessSnapshot)
+			if err = json.Unmarshal(bytes, last); err != nil {
+				return err
+			}
+		}
+		if change = nextChange(last, snapshot, at); change == nil {
+			return nil
+		}
+		snapshotBytes, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		changeBytes, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.Set(lastKey, snapshotBytes, 0)
+			pipe.LPush(changesKey, changeBytes)
+			pipe.LTrim(changesKey, 0, int64(s.maxEntries-1))
+			return nil
+		})
+		return err
+	}, lastKey)
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func (s *redisHistoryStore) Changes(ctx context.Context, userID, offset, limit int) ([]*accessChange, int, error) {
+	_, changesKey := s.keys(userID)
+	client := s.client.WithContext(ctx)
+	total, err := client.LLen(changesKey).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	values, err := client.LRange(changesKey, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	changes := make([]*accessChange, 0, len(values))
+	for _, value := range values {
+		change := new(accessChange)
+		if err = json.Unmarshal([]byte(value), change); err != nil {
+			return nil, 0, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, int(total), nil
+}
+
+func (s *redisHistoryStore) keys(userID int) (last, changes string) {
+	key := fmt.Sprintf("%s:history:v%d:%d", s.prefix, historySchemaVersion, userID)
+	return key + ":last", key + ":changes"
+}
+
+func (s *redisHistoryStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,156 @@
+package access
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHistoryStore(t *testing.T) {
+	config := &configServiceMock{}
+	config.On("Config").Return(&authrlib.Config{}).Once()
+	ctx := &authrlib.AppContext{ConfigService: config}
+	assert.Nil(t, NewHistoryStore(ctx))
+
+	config.On("Config").Return(&authrlib.Config{History: authrlib.HistoryConfig{Enabled: true}}).Once()
+	memory, ok := NewHistoryStore(ctx).(*memoryHistoryStore)
+	assert.True(t, ok)
+	assert.Equal(t, defaultHistoryMaxEntries, memory.maxEntries)
+	assert.Equal(t, defaultHistoryMaxUsers, memory.maxUsers)
+
+	config.On("Config").Return(&authrlib.Config{History: authrlib.HistoryConfig{Enabled: true, Store: authrlib.HistoryStoreRedis}}).Once()
+	shared, ok := NewHistoryStore(ctx).(*redisHistoryStore)
+	assert.True(t, ok)
+	assert.NoError(t, shared.Close())
+}
+
+func TestMemoryHistoryStore(t *testing.T) {
+	testHistoryStore(t, newMemoryHistoryStore(2, 10))
+}
+
+func TestMemoryHistoryStoreMaxUsers(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryHistoryStore(2, 2)
+	for _, userID := range []int{1, 2, 1, 3} {
+		_, err := store.Record(ctx, userID, newAccessSnapshot(&authorization.Access{}), time.Now())
+		assert.NoError(t, err)
+	}
+	// user 2 is the least recently used one
+	for userID, total := range map[int]int{1: 1, 2: 0, 3: 1} {
+		_, n, err := store.Changes(ctx, userID, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, total, n, userID)
+	}
+	assert.Equal(t, 2, store.users.len())
+}
+
+func TestRedisHistoryStore(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal("unable to start miniredis", err)
+	}
+	defer server.Close()
+	store := newRedisHistoryStore(authrlib.RedisConfig{Address: server.Addr()}, 2)
+	defer store.Close()
+
+	testHistoryStore(t, store)
+	assert.True(t, server.Exists("authr:access:history:v1:42:last"))
+	assert.True(t, server.Exists("authr:access:history:v1:42:changes"))
+
+	// deployments sharing redis are separated by configured prefix
+	other := newRedisHistoryStore(authrlib.RedisConfig{Address: server.Addr(), KeyPrefix: "other"}, 2)
+	defer other.Close()
+	changes, total, err := other.Changes(context.Background(), 42, 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Equal(t, 0, total)
+	_, err = other.Record(context.Background(), 42, newAccessSnapshot(&authorization.Access{}), time.Now())
+	assert.NoError(t, err)
+	assert.True(t, server.Exists("other:history:v1:42:last"))
+
+	// redis failure
+	server.Close()
+	_, err = store.Record(context.Background(), 42, newAccessSnapshot(&authorization.Access{}), time.Now())
+	assert.Error(t, err)
+	_, _, err = store.Changes(context.Background(), 42, 0, 10)
+	assert.Error(t, err)
+}
+
+// behavior shared by all history stores, store keeps at most 2 changes per user
+func testHistoryStore(t *testing.T, store HistoryStore) {
+	ctx := context.Background()
+	at := time.Date(2019, 12, 1, 10, 0, 0, 0, time.UTC)
+	teacher := &authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{1}}}
+	admin := &authorization.Access{Admin: &authorization.AdminType{Ent: []int64{10}}}
+
+	// unknown user has no history
+	changes, total, err := store.Changes(ctx, 42, 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Equal(t, 0, total)
+
+	// the first version
+	change, err := store.Record(ctx, 42, newAccessSnapshot(teacher), at)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, change.Version)
+	assert.Equal(t, []string{"Teacher"}, change.RolesAdded)
+
+	// the same access is not a change
+	change, err = store.Record(ctx, 42, newAccessSnapshot(teacher), at.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Nil(t, change)
+
+	// changes are diffed with the last version
+	change, err = store.Record(ctx, 42, newAccessSnapshot(admin), at.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, change.Version)
+	assert.Equal(t, []string{"Admin"}, change.RolesAdded)
+	assert.Equal(t, []string{"Teacher"}, change.RolesRemoved)
+	change, err = store.Record(ctx, 42, newAccessSnapshot(teacher), at.Add(3*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, change.Version)
+
+	// the oldest change is dropped, newest first
+	changes, total, err = store.Changes(ctx, 42, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 3, changes[0].Version)
+	assert.Equal(t, 2, changes[1].Version)
+	assert.Equal(t, at.Add(3*time.Minute), changes[0].ChangedAt)
+
+	// pagination
+	changes, total, err = store.Changes(ctx, 42, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, 2, changes[0].Version)
+	changes, _, err = store.Changes(ctx, 42, 5, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// users are independent
+	change, err = store.Record(ctx, 43, newAccessSnapshot(teacher), at)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, change.Version)
+
+	// revoked access is the next version, it's recorded once and only for known users
+	change, err = store.Revoke(ctx, 43, at.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, change.Version)
+	assert.Equal(t, []string{"Teacher"}, change.RolesRemoved)
+	assert.Equal(t, map[string][]int64{"Teacher.cls": {1}}, change.Removed)
+	change, err = store.Revoke(ctx, 43, at.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Nil(t, change)
+	change, err = store.Revoke(ctx, 44, at)
+	assert.NoError(t, err)
+	assert.Nil(t, change)
+	_, total, err = store.Changes(ctx, 44, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, total)
+}
@@ -0,0 +1,276 @@
+package access
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/app/authr/webhook"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	bootstraputils "bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type historyStoreMock struct{ mock.Mock }
+
+func (m *historyStoreMock) Record(_ context.Context, userID int, snapshot *accessSnapshot, at time.Time) (*accessChange, error) {
+	args := m.Called(userID, snapshot.Hash)
+	change, _ := args.Get(0).(*accessChange)
+	return change, args.Error(1)
+}
+
+func (m *historyStoreMock) Revoke(_ context.Context, userID int, at time.Time) (*accessChange, error) {
+	args := m.Called(userID)
+	change, _ := args.Get(0).(*accessChange)
+	return change, args.Error(1)
+}
+
+func (m *historyStoreMock) Changes(_ context.Context, userID, offset, limit int) ([]*accessChange, int, error) {
+	args := m.Called(userID, offset, limit)
+	changes, _ := args.Get(0).([]*accessChange)
+	return changes, args.Int(1), args.Error(2)
+}
+
+func (m *historyStoreMock) Close() error { return nil }
+
+type publisherMock struct{ mock.Mock }
+
+func (m *publisherMock) Publish(event *webhook.Event) { m.Called(event.Type, event.UserID, event.Data) }
+func (m *publisherMock) Close(context.Context) error  { return m.Called().Error(0) }
+
+func TestNewAccessSnapshot(t *testing.T) {
+	access := &authorization.Access{
+		SuperUser: true,
+		Teacher:   &authorization.TeacherType{Cls: []int64{3, 1, 2}},
+		Admin:     &authorization.AdminType{Ent: []int64{20, 10}},
+		FSVOAdmin: &authorization.FsAdminType{AdminType: authorization.AdminType{Ent: []int64{5}}, FundSrc: []int64{7}},
+	}
+	snapshot := newAccessSnapshot(access)
+	assert.Equal(t, []string{"Admin", "FSVOAdmin", "SuperUser", "Teacher"}, snapshot.Roles)
+	assert.Equal(t, map[string][]int64{"Teacher.cls": {1, 2, 3}, "Admin.ent": {10, 20},
+		"FSVOAdmin.ent": {5}, "FSVOAdmin.fundSrc": {7}}, snapshot.Grants)
+	assert.Equal(t, []int64{3, 1, 2}, access.Teacher.Cls) // access isn't modified
+
+	// hash doesn't depend on order of IDs
+	reordered := &authorization.Access{
+		SuperUser: true,
+		Teacher:   &authorization.TeacherType{Cls: []int64{2, 3, 1}},
+		Admin:     &authorization.AdminType{Ent: []int64{10, 20}},
+		FSVOAdmin: &authorization.FsAdminType{AdminType: authorization.AdminType{Ent: []int64{5}}, FundSrc: []int64{7}},
+	}
+	assert.Equal(t, snapshot.Hash, newAccessSnapshot(reordered).Hash)
+	assert.Len(t, snapshot.Hash, 64)
+
+	// role without IDs is a change too
+	reordered.TeamMember = &authorization.TeamMemberType{}
+	assert.NotEqual(t, snapshot.Hash, newAccessSnapshot(reordered).Hash)
+	assert.NotEqual(t, snapshot.Hash, newAccessSnapshot(&authorization.Access{}).Hash)
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	at := time.Date(2019, 12, 1, 10, 0, 0, 0, time.UTC)
+	previous := newAccessSnapshot(&authorization.Access{
+		Teacher: &authorization.TeacherType{Cls: []int64{1, 2}},
+		Admin:   &authorization.AdminType{Ent: []int64{10}},
+	})
+	current := newAccessSnapshot(&authorization.Access{
+		Teacher:    &authorization.TeacherType{Cls: []int64{2, 3}},
+		TeamMember: &authorization.TeamMemberType{Kid: []int64{50}},
+	})
+	current.Version = 2
+
+	change := diffSnapshots(previous, current, at)
+	assert.Equal(t, &accessChange{
+		Version:      2,
+		Hash:         current.Hash,
+		ChangedAt:    at,
+		RolesAdded:   []string{"TeamMember"},
+		RolesRemoved: []string{"Admin"},
+		Added:        map[string][]int64{"Teacher.cls": {3}, "TeamMember.kid": {50}},
+		Removed:      map[string][]int64{"Teacher.cls": {1}, "Admin.ent": {10}},
+	}, change)
+
+	// the first version adds everything
+	change = diffSnapshots(nil, previous, at)
+	assert.Equal(t, []string{"Admin", "Teacher"}, change.RolesAdded)
+	assert.Empty(t, change.RolesRemoved)
+	assert.Equal(t, map[string][]int64{"Teacher.cls": {1, 2}, "Admin.ent": {10}}, change.Added)
+	assert.Nil(t, change.Removed)
+}
+
+func TestHistoryService(t *testing.T) {
+	var buf bytes.Buffer
+	service, store := &serviceMock{}, &historyStoreMock{}
+	history := newHistoryService(service, store, nil, &authrlib.AppLogger{Logger: zerolog.New(&buf)})
+	access := &authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{1}}}
+	hash := newAccessSnapshot(access).Hash
+
+	// change is recorded
+	service.On("Access", 42).Return(access, nil).Once()
+	store.On("Record", 42, hash).Return(&accessChange{Version: 2}, nil).Once()
+	reply, err := history.Access(context.Background(), 42)
+	assert.NoError(t, err)
+	assert.Equal(t, access, reply)
+	assert.Contains(t, buf.String(), `"user_id":42,"version":2,"message":"access changed"`)
+
+	// store failure doesn't fail lookup
+	buf.Reset()
+	service.On("Access", 42).Return(access, nil).Once()
+	store.On("Record", 42, hash).Return(nil, errors.New("redis down")).Once()
+	_, err = history.Access(context.Background(), 42)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `"error":"redis down","user_id":42,"message":"unable to record access history"`)
+
+	// users who are no longer found or allowed lose all access
+	buf.Reset()
+	service.On("Access", 43).Return(nil, errNotFound).Once()
+	store.On("Revoke", 43).Return(&accessChange{Version: 3}, nil).Once()
+	_, err = history.Access(context.Background(), 43)
+	assert.Equal(t, errNotFound, err)
+	assert.Contains(t, buf.String(), `"user_id":43,"version":3,"message":"access changed"`)
+	service.On("Access", 43).Return(nil, errNotAllowed).Once()
+	store.On("Revoke", 43).Return(nil, nil).Once()
+	_, err = history.Access(context.Background(), 43)
+	assert.Equal(t, errNotAllowed, err)
+
+	// failed lookup isn't recorded
+	service.On("Access", 44).Return(nil, errors.New("db error")).Once()
+	_, err = history.Access(context.Background(), 44)
+	assert.EqualError(t, err, "db error")
+
+	// found users of batch are recorded, not found ones are revoked
+	service.On("BatchAccess", []int{42, 43}).Return(map[int]*batchAccessItem{
+		42: {Status: http.StatusOK, Access: access},
+		43: {Status: http.StatusNotFound, Code: errNotFound.Code},
+	}, nil).Once()
+	store.On("Record", 42, hash).Return(nil, nil).Once()
+	store.On("Revoke", 43).Return(nil, nil).Once()
+	result, err := history.BatchAccess(context.Background(), []int{42, 43})
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+
+	service.On("BatchAccess", []int{44}).Return(nil, errors.New("db error")).Once()
+	_, err = history.BatchAccess(context.Background(), []int{44})
+	assert.EqualError(t, err, "db error")
+
+	service.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func TestHistoryServicePublish(t *testing.T) {
+	service, store, publisher := &serviceMock{}, &historyStoreMock{}, &publisherMock{}
+	history := newHistoryService(service, store, publisher, &authrlib.AppLogger{Logger: zerolog.Nop()})
+	access := &authorization.Access{SuperUser: true}
+	hash := newAccessSnapshot(access).Hash
+	service.On("Access", 42).Return(access, nil)
+
+	// user seen first time isn't a change for subscribers
+	store.On("Record", 42, hash).Return(&accessChange{Version: 1}, nil).Once()
+	_, err := history.Access(context.Background(), 42)
+	assert.NoError(t, err)
+
+	// unchanged access
+	store.On("Record", 42, hash).Return(nil, nil).Once()
+	_, err = history.Access(context.Background(), 42)
+	assert.NoError(t, err)
+
+	change := &accessChange{Version: 2, RolesAdded: []string{"SuperUser"}}
+	store.On("Record", 42, hash).Return(change, nil).Once()
+	publisher.On("Publish", webhook.AccessChanged, 42, change).Once()
+	_, err = history.Access(context.Background(), 42)
+	assert.NoError(t, err)
+
+	// revoked access is published as well
+	revoked := &accessChange{Version: 3, RolesRemoved: []string{"SuperUser"}}
+	service.On("Access", 43).Return(nil, errNotFound).Once()
+	store.On("Revoke", 43).Return(revoked, nil).Once()
+	publisher.On("Publish", webhook.AccessChanged, 43, revoked).Once()
+	_, err = history.Access(context.Background(), 43)
+	assert.Equal(t, errNotFound, err)
+
+	store.AssertExpectations(t)
+	publisher.AssertExpectations(t)
+}
+
+func TestNewAccessServiceDetectsChanges(t *testing.T) {
+	config := &configServiceMock{}
+	config.On("Config").Return(&authrlib.Config{})
+	ctx := &authrlib.AppContext{ConfigService: config}
+
+	store := &historyStoreMock{}
+	history, ok := NewAccessService(ctx, store, &publisherMock{}).(*historyService)
+	assert.True(t, ok)
+	assert.Equal(t, store, history.store)
+	assert.NotNil(t, history.publisher)
+
+	// history is disabled, there are no last versions to detect changes against
+	_, ok = NewAccessService(ctx, nil, &publisherMock{}).(*accessService)
+	assert.True(t, ok)
+}
+
+func TestHistoryHandler(t *testing.T) {
+	changes := []*accessChange{{Version: 2, Hash: "abc", ChangedAt: time.Date(2019, 12, 1, 10, 0, 0, 0, time.UTC),
+		RolesAdded: []string{"Teacher"}}}
+	testCases := []struct {
+		name    string
+		userID  string
+		query   string
+		disable bool
+		mock    func(*historyStoreMock)
+		status  int
+		body    string
+	}{
+		{name: "history disabled", userID: "108", disable: true, status: http.StatusNotImplemented,
+			body: `"code":"HISTORY_DISABLED"`},
+		{name: "incorrect user id", userID: "108a", status: http.StatusBadRequest, body: `"code":"INVALID_REQUEST"`},
+		{name: "incorrect offset", userID: "108", query: "?offset=-1", status: http.StatusBadRequest,
+			body: `"message":"offset has incorrect value"`},
+		{name: "limit exceeds max page size", userID: "108", query: "?limit=51", status: http.StatusBadRequest,
+			body: `"message":"limit has incorrect value"`},
+		{name: "store error", userID: "108", status: http.StatusInternalServerError, body: `"code":"INTERNAL"`,
+			mock: func(m *historyStoreMock) { m.On("Changes", 108, 0, 10).Return(nil, 0, errors.New("redis down")) }},
+		{name: "default page", userID: "108", status: http.StatusOK,
+			body: `"data":{"userId":108,"changes":[{"version":2,"hash":"abc","changedAt":"2019-12-01T10:00:00Z","rolesAdded":["Teacher"]}],"total":3,"offset":0,"limit":10}`,
+			mock: func(m *historyStoreMock) { m.On("Changes", 108, 0, 10).Return(changes, 3, nil) }},
+		{name: "requested page", userID: "108", query: "?offset=2&limit=1", status: http.StatusOK, body: `"total":3,"offset":2,"limit":1`,
+			mock: func(m *historyStoreMock) { m.On("Changes", 108, 2, 1).Return([]*accessChange{}, 3, nil) }},
+	}
+
+	for _, testCase := range testCases {
+		var buf bytes.Buffer
+		config := &configServiceMock{}
+		config.On("Config").Return(&authrlib.Config{History: authrlib.HistoryConfig{PageSize: 10, MaxPageSize: 50}})
+		ctx := &authrlib.AppContext{Logger: &authrlib.AppLogger{Logger: zerolog.New(&buf)}, ConfigService: config}
+		store := &historyStoreMock{}
+		if testCase.mock != nil {
+			testCase.mock(store)
+		}
+		handlerFunc := NewHistoryHandler(ctx, store)
+		if testCase.disable {
+			handlerFunc = NewHistoryHandler(ctx, nil)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/test/"+testCase.userID+"/history"+testCase.query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams = chi.RouteParams{Keys: []string{"userID"}, Values: []string{testCase.userID}}
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		txn := (*newrelicApp()).StartTransaction("/test/history", w, r)
+		r = r.WithContext(context.WithValue(r.Context(), bootstraputils.ContextKey("txn"), txn))
+
+		handlerFunc.ServeHTTP(w, r)
+
+		assert.Equal(t, testCase.status, w.Code, testCase.name)
+		assert.Contains(t, w.Body.String(), testCase.body, testCase.name)
+		store.AssertExpectations(t)
+		t.Log("test case ok:", testCase.name)
+	}
+}
@@ -0,0 +1,100 @@
+package access
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// in-process values by int key with LRU eviction and TTL, zero TTL keeps values until they're evicted;
+// it backs access cache, delegation scopes and memory history store
+type lru struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	items   map[int]*list.Element
+	order   *list.List // front is the most recently used entry
+	now     func() time.Time
+}
+
+// item of lru.order
+type lruEntry struct {
+	key       int
+	value     interface{}
+	expiresAt time.Time // zero if entry doesn't expire
+}
+
+func newLRU(ttl time.Duration, maxSize int) *lru {
+	return &lru{
+		ttl:     ttl,
+		maxSize: maxSize,
+		items:   make(map[int]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// returns value and true if entry exists and is not expired
+func (c *lru) get(key int) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && c.now().After(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// stores value, the least recently used entries are evicted above max size
+func (c *lru) set(key int, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = c.now().Add(c.ttl)
+	}
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key, value, expiresAt})
+	for c.order.Len() > c.maxSize {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lru) delete(key int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// changes ttl of entries stored from now on
+func (c *lru) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// number of entries including expired ones which haven't been read since
+func (c *lru) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// removes element from both list and map, must be called under lock
+func (c *lru) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*lruEntry).key)
+}
@@ -0,0 +1,26 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUWithoutTTL(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	cache := newLRU(0, 2)
+	cache.now = func() time.Time { return now }
+
+	// entries don't expire, only the least recently used one is evicted
+	cache.set(1, "first")
+	cache.set(2, "second")
+	now = now.Add(24 * time.Hour)
+	value, ok := cache.get(1)
+	assert.True(t, ok)
+	assert.Equal(t, "first", value)
+	cache.set(3, "third")
+	_, ok = cache.get(2)
+	assert.False(t, ok)
+	assert.Equal(t, 2, cache.len())
+}
@@ -1,52 +1,216 @@
 package access
 
 import (
-	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi"
 
 	jwt "github.com/dgrijalva/jwt-go"
 
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
 	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
 	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
 )
 
-// NewAccessValidationMiddlewares creates a middleware function to check jwt client id and userID from path
+// default scopes a service token must have to call service-to-service endpoints
+const (
+	defaultBatchScope      = "access:read:batch"
+	defaultCachePurgeScope = "access:cache:purge"
+	defaultReadAnyScope    = "access:read:any"
+	defaultDebugScope      = "authr:debug:read"
+)
+
+// rules which authorize access lookups, logged for every request
+const (
+	ruleSelf              = "self"
+	ruleClientCredentials = "client-credentials"
+	ruleDelegatedAdmin    = "delegated-admin"
+)
+
+// bootstrap errors with codes
+var (
+	errSubNotFound = authrerr.Wrap(authorization.ErrSubNotFound, authrerr.TokenSubMissing, "token sub is missing")
+	errSubInvalid  = authrerr.Wrap(authorization.ErrSubInvalid, authrerr.TokenSubMismatch, "token sub doesn't match userID")
+)
+
+var errScopeNotGranted = authrerr.New(authrerr.ScopeNotGranted, "token scope is not granted")
+
+// NewAccessValidationMiddlewares creates a middleware function to check that token sub is userID from path;
+// client credentials tokens aren't accepted, so tokens minted by /token can't impersonate users
 func NewAccessValidationMiddlewares(ctx *authrlib.AppContext) []func(next http.Handler) http.Handler {
-	return (&accessValidationMiddleware{ctx.ConfigService.Config().App.KeysServer}).middlewares()
+	return newAccessValidationMiddleware(ctx, false).middlewares()
+}
+
+// NewDelegatedAccessValidationMiddlewares creates a middleware function which in addition allows
+// allowed clients to read access of any user and admins to read access of users within their entities;
+// entities of admins are cached as long as access if cache is enabled
+func NewDelegatedAccessValidationMiddlewares(ctx *authrlib.AppContext, service Service) []func(next http.Handler) http.Handler {
+	m := newAccessValidationMiddleware(ctx, true)
+	m.service = service
+	if cacheConfig := ctx.ConfigService.Config().Cache; cacheConfig.Enabled {
+		maxSize := cacheConfig.MaxSize
+		if maxSize <= 0 {
+			maxSize = defaultCacheMaxSize
+		}
+		m.scopes = newScopeCache(cacheTTL(cacheConfig), maxSize)
+		ctx.ConfigService.Subscribe(func(old, new *authrlib.Config) {
+			if ttl := cacheTTL(new.Cache); ttl != cacheTTL(old.Cache) {
+				m.scopes.setTTL(ttl)
+			}
+		})
+	}
+	return m.middlewares()
+}
+
+func newAccessValidationMiddleware(ctx *authrlib.AppContext, allowClients bool) *accessValidationMiddleware {
+	config := ctx.ConfigService.Config().App
+	m := &accessValidationMiddleware{
+		logger:       ctx.Logger,
+		keys:         ctx.KeySet,
+		verify:       config.Verify,
+		clientIDs:    make(map[string]bool, len(config.ClientIDs)),
+		readAnyScope: config.ReadAnyScope,
+	}
+	if allowClients {
+		for _, clientID := range config.ClientIDs {
+			m.clientIDs[clientID] = true
+		}
+	}
+	if m.readAnyScope == "" {
+		m.readAnyScope = defaultReadAnyScope
+	}
+	return m
+}
+
+// NewBatchAccessValidationMiddlewares creates a middleware function to check jwt scope of batch calls
+func NewBatchAccessValidationMiddlewares(ctx *authrlib.AppContext) []func(next http.Handler) http.Handler {
+	config := ctx.ConfigService.Config()
+	return newScopeValidationMiddlewares(ctx, config, config.App.BatchScope, defaultBatchScope)
+}
+
+// NewCacheInvalidationValidationMiddlewares creates a middleware function to check jwt scope of cache purge calls
+func NewCacheInvalidationValidationMiddlewares(ctx *authrlib.AppContext) []func(next http.Handler) http.Handler {
+	config := ctx.ConfigService.Config()
+	return newScopeValidationMiddlewares(ctx, config, config.Cache.PurgeScope, defaultCachePurgeScope)
+}
+
+// NewDebugValidationMiddlewares creates a middleware function to check jwt scope of internal diagnostics calls
+func NewDebugValidationMiddlewares(ctx *authrlib.AppContext) []func(next http.Handler) http.Handler {
+	config := ctx.ConfigService.Config()
+	return newScopeValidationMiddlewares(ctx, config, config.App.DebugScope, defaultDebugScope)
+}
+
+func newScopeValidationMiddlewares(ctx *authrlib.AppContext, config *authrlib.Config, scope, defaultScope string) []func(next http.Handler) http.Handler {
+	if scope == "" {
+		scope = defaultScope
+	}
+	return (&accessValidationMiddleware{logger: ctx.Logger, keys: ctx.KeySet, verify: config.App.Verify, scope: scope}).scopeMiddlewares()
 }
 
 type accessValidationMiddleware struct {
-	keysServerURL string
+	logger       *authrlib.AppLogger
+	keys         authrlib.KeySet // cached keys server keys
+	verify       authrlib.VerifyConfig
+	scope        string          // scope required for service-to-service calls
+	clientIDs    map[string]bool // clients allowed to read access of any user
+	readAnyScope string
+	service      Service     // resolves access of delegated admins, nil disables the rule
+	scopes       *scopeCache // entities of delegated admins, nil if cache is disabled
 }
 
 func (m *accessValidationMiddleware) middlewares() []func(next http.Handler) http.Handler {
 	return []func(next http.Handler) http.Handler{
 		authorization.FindTokenMiddleware(),
-		authorization.VerifyTokenMiddleware(m.keysServerURL, authorization.ClaimsValidator(m.validateClaims)),
+		authrlib.VerifyTokenMiddleware(m.logger, m.keys, m.verify, authorization.ClaimsValidator(m.validateClaims)),
 	}
 }
 
-func (*accessValidationMiddleware) validateClaims(claims jwt.MapClaims, r *http.Request) (*http.Request, error) {
+func (m *accessValidationMiddleware) scopeMiddlewares() []func(next http.Handler) http.Handler {
+	return []func(next http.Handler) http.Handler{
+		authorization.FindTokenMiddleware(),
+		authrlib.VerifyTokenMiddleware(m.logger, m.keys, m.verify, authorization.ClaimsValidator(m.validateScope)),
+	}
+}
+
+// machine tokens of allowed clients may read any user, end-user tokens only their own access
+// or, if delegation is enabled, access of users within caller's admin entities
+func (m *accessValidationMiddleware) validateClaims(claims jwt.MapClaims, r *http.Request) (*http.Request, error) {
+	if clientID, ok := claims["client_id"].(string); ok && m.clientIDs[clientID] && hasScope(claims, m.readAnyScope) {
+		m.logger.HandlerLogger(r).Info().Str("rule", ruleClientCredentials).Str("client_id", clientID).
+			Str("user_id", chi.URLParam(r, "userID")).Msg("access authorized")
+		auditRule(r, ruleClientCredentials)
+		return r, nil
+	}
+
 	var subscription string
 	var ok bool
 	if sub, ok := claims["sub"]; !ok || sub == nil {
-		return r, authorization.ErrSubNotFound
+		return r, errSubNotFound
 	}
 	if subscription, ok = claims["sub"].(string); !ok {
-		return r, authorization.ErrSubNotFound
+		return r, errSubNotFound
 	}
 
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		return r, fmt.Errorf("internal server error: %v", err)
+		return r, authrerr.Wrap(err, authrerr.InvalidRequest, "userID has incorrect value")
 	}
 
 	if strconv.Itoa(userID) != subscription {
-		return r, authorization.ErrSubInvalid
+		callerID, err := strconv.Atoi(subscription)
+		if err != nil || m.service == nil {
+			return r, errSubInvalid
+		}
+		logger := m.logger.HandlerLogger(r)
+		if err = authorizeDelegatedAdmin(r.Context(), m.service, m.scopes, callerID, userID); err != nil {
+			logger.Warn().Err(err).Str("rule", ruleDelegatedAdmin).Int("caller_id", callerID).Int("user_id", userID).
+				Msg("access denied")
+			return r, err
+		}
+		logger.Info().Str("rule", ruleDelegatedAdmin).Int("caller_id", callerID).Int("user_id", userID).
+			Msg("access authorized")
+		auditRule(r, ruleDelegatedAdmin)
+		return r, nil
 	}
 
+	m.logger.HandlerLogger(r).Info().Str("rule", ruleSelf).Int("user_id", userID).Msg("access authorized")
+	auditRule(r, ruleSelf)
 	return r, nil
 }
+
+// records rule which authorized the request in its audit event
+func auditRule(r *http.Request, rule string) {
+	if event := authrlib.AuditEventFrom(r.Context()); event != nil {
+		event.Rule = rule
+	}
+}
+
+func (m *accessValidationMiddleware) validateScope(claims jwt.MapClaims, r *http.Request) (*http.Request, error) {
+	if !hasScope(claims, m.scope) {
+		return r, errScopeNotGranted
+	}
+	return r, nil
+}
+
+// checks `scope` claim, which is either space delimited string (OAuth 2.0) or list of strings
+func hasScope(claims jwt.MapClaims, scope string) bool {
+	var granted []string
+	switch scopes := claims["scope"].(type) {
+	case string:
+		granted = strings.Fields(scopes)
+	case []interface{}:
+		for _, s := range scopes {
+			if str, ok := s.(string); ok {
+				granted = append(granted, str)
+			}
+		}
+	}
+	for _, s := range granted {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
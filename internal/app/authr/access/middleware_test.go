@@ -1,9 +1,15 @@
 package access
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"net/http/httptest"
 
@@ -12,12 +18,19 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
 	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
 	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	bootstraputils "bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/mock"
 )
 
-type configServiceMock struct{ mock.Mock }
+// listeners are recorded, so tests can notify them
+type configServiceMock struct {
+	mock.Mock
+	listeners []authrlib.ConfigListener
+}
 
 func (m *configServiceMock) Config() *authrlib.Config {
 	args := m.Called()
@@ -27,9 +40,13 @@ func (m *configServiceMock) Config() *authrlib.Config {
 	return args.Get(0).(*authrlib.Config)
 }
 func (m *configServiceMock) IsProduction() bool { return m.Called().Bool(0) }
+func (m *configServiceMock) Reload() error      { return m.Called().Error(0) }
+func (m *configServiceMock) Subscribe(listener authrlib.ConfigListener) {
+	m.listeners = append(m.listeners, listener)
+}
 
 func TestMiddlewares(t *testing.T) {
-	config := &authrlib.Config{App: authrlib.AppConfig{KeysServer: "http://notrealuri:3333/dev"}}
+	config := &authrlib.Config{App: authrlib.AppConfig{ClientIDs: []string{"reports-job"}}}
 	mockConfigService := &configServiceMock{}
 	mockConfigService.On("Config").Return(config).Once()
 	ctx := &authrlib.AppContext{ConfigService: mockConfigService}
@@ -38,32 +55,246 @@ func TestMiddlewares(t *testing.T) {
 	mockConfigService.AssertExpectations(t)
 }
 
-func TestValidateClaims(t *testing.T) {
-	middlware := &accessValidationMiddleware{}
+func TestDelegatedMiddlewares(t *testing.T) {
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(&authrlib.Config{}).Twice()
+	ctx := &authrlib.AppContext{ConfigService: mockConfigService}
+	validationMiddlewares := NewDelegatedAccessValidationMiddlewares(ctx, &serviceMock{})
+	assert.Equal(t, 2, len(validationMiddlewares))
+	assert.Empty(t, mockConfigService.listeners)
+
+	// admins' entities are cached with access
+	mockConfigService.On("Config").Return(&authrlib.Config{Cache: authrlib.CacheConfig{Enabled: true}}).Twice()
+	validationMiddlewares = NewDelegatedAccessValidationMiddlewares(ctx, &serviceMock{})
+	assert.Equal(t, 2, len(validationMiddlewares))
+	assert.Len(t, mockConfigService.listeners, 1)
+	mockConfigService.AssertExpectations(t)
+}
+
+func TestBatchMiddlewares(t *testing.T) {
+	config := &authrlib.Config{App: authrlib.AppConfig{KeysServer: "http://notrealuri:3333/dev"}}
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(config).Once()
+	ctx := &authrlib.AppContext{ConfigService: mockConfigService}
+	validationMiddlewares := NewBatchAccessValidationMiddlewares(ctx)
+	assert.Equal(t, 2, len(validationMiddlewares))
+	mockConfigService.AssertExpectations(t)
+}
+
+func TestCacheInvalidationMiddlewares(t *testing.T) {
+	config := &authrlib.Config{App: authrlib.AppConfig{KeysServer: "http://notrealuri:3333/dev"}}
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(config).Once()
+	ctx := &authrlib.AppContext{ConfigService: mockConfigService}
+	validationMiddlewares := NewCacheInvalidationValidationMiddlewares(ctx)
+	assert.Equal(t, 2, len(validationMiddlewares))
+	mockConfigService.AssertExpectations(t)
+}
+
+// key set of a single key, whatever kid is requested
+type keySetMock struct{ key crypto.PublicKey }
+
+func (m *keySetMock) Key(string) (crypto.PublicKey, error) { return m.key, nil }
+func (m *keySetMock) LastError() error                     { return nil }
+
+func TestDebugMiddlewares(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("unable to generate key", err)
+	}
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(&authrlib.Config{}).Once()
+	ctx := &authrlib.AppContext{ConfigService: mockConfigService, KeySet: &keySetMock{&key.PublicKey},
+		Logger: &authrlib.AppLogger{Logger: zerolog.Nop()}}
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	middlewares := NewDebugValidationMiddlewares(ctx)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	sign := func(scope string) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"client_id": "ops", "scope": scope,
+			"exp": time.Now().Add(time.Minute).Unix()}).SignedString(key)
+		if err != nil {
+			t.Fatal("unable to sign token", err)
+		}
+		return "Bearer " + signed
+	}
+
 	testCases := []struct {
 		name   string
-		userID string
-		sub    interface{}
-		err    string
+		header string
+		status int
+		body   string
 	}{
-		{name: "subscription not found", userID: "", sub: nil, err: authorization.ErrSubNotFound.Error()},                                                   //sub doen't exist
-		{name: "subscription not found (wrong format)", userID: "", sub: 108, err: authorization.ErrSubNotFound.Error()},                                    // sub has wrong type
-		{name: "userID path param is not a number", userID: "abc", sub: "108", err: "internal server error: strconv.Atoi: parsing \"abc\": invalid syntax"}, // {userID} path param is not a number
-		{name: "subscription <> userID", userID: "1008", sub: "108", err: authorization.ErrSubInvalid.Error()},                                              //sub <> userID
-		{name: "success case", userID: "1008", sub: "1008", err: ""},                                                                                        // success
+		{name: "unauthenticated", status: http.StatusUnauthorized, body: `"code":"TOKEN_MISSING"`},
+		{name: "scope not granted", header: sign(defaultReadAnyScope), status: http.StatusForbidden, body: `"code":"SCOPE_NOT_GRANTED"`},
+		{name: "debug scope", header: sign(defaultDebugScope), status: http.StatusOK},
+	}
+	for _, testCase := range testCases {
+		req := httptest.NewRequest("GET", "/debug/db", nil)
+		txn := (*newrelicApp()).StartTransaction("/debug/db", httptest.NewRecorder(), req)
+		req = req.WithContext(context.WithValue(req.Context(), bootstraputils.ContextKey("txn"), txn))
+		if testCase.header != "" {
+			req.Header.Set("Authorization", testCase.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testCase.status, w.Code, testCase.name)
+		assert.Contains(t, w.Body.String(), testCase.body, testCase.name)
+		t.Log("test case ok:", testCase.name)
+	}
+	mockConfigService.AssertExpectations(t)
+}
+
+func TestValidateScope(t *testing.T) {
+	middlware := &accessValidationMiddleware{scope: defaultBatchScope}
+	testCases := []struct {
+		name  string
+		scope interface{}
+		err   error
+	}{
+		{name: "scope not found", scope: nil, err: errScopeNotGranted},
+		{name: "scope has wrong type", scope: 108, err: errScopeNotGranted},
+		{name: "scope not granted", scope: "access:read", err: errScopeNotGranted},
+		{name: "scope granted (string)", scope: "openid " + defaultBatchScope, err: nil},
+		{name: "scope granted (list)", scope: []interface{}{"openid", defaultBatchScope}, err: nil},
 	}
 
 	for _, testCase := range testCases {
+		claims := map[string]interface{}{}
+		if testCase.scope != nil {
+			claims["scope"] = testCase.scope
+		}
+		if _, err := middlware.validateScope(jwt.MapClaims(claims), createRequest("")); err != testCase.err {
+			t.Fatalf("test case failed: '%s' [expected '%v'; got '%v']", testCase.name, testCase.err, err)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	var buf bytes.Buffer
+	middlware := &accessValidationMiddleware{
+		logger:       &authrlib.AppLogger{Logger: zerolog.New(&buf)},
+		clientIDs:    map[string]bool{"reports-job": true},
+		readAnyScope: defaultReadAnyScope,
+	}
+	testCases := []struct {
+		name     string
+		userID   string
+		sub      interface{}
+		clientID string
+		scope    string
+		err      error
+		rule     string
+	}{
+		{name: "subscription not found", userID: "", sub: nil, err: authorization.ErrSubNotFound},                                         //sub doen't exist
+		{name: "subscription not found (wrong format)", userID: "", sub: 108, err: authorization.ErrSubNotFound},                          // sub has wrong type
+		{name: "userID path param is not a number", userID: "abc", sub: "108", err: authrerr.New(authrerr.InvalidRequest, "")},            // {userID} path param is not a number
+		{name: "subscription <> userID", userID: "1008", sub: "108", err: authorization.ErrSubInvalid},                                    //sub <> userID
+		{name: "success case", userID: "1008", sub: "1008", rule: ruleSelf},                                                               // success
+		{name: "client not allowed", userID: "1008", sub: "108", clientID: "unknown-job", scope: defaultReadAnyScope, err: errSubInvalid}, // not in allow-list
+		{name: "client scope not granted", userID: "1008", sub: "108", clientID: "reports-job", scope: "access:read", err: errSubInvalid},
+		{name: "end-user token of allowed client", userID: "1008", sub: "1008", clientID: "reports-job", rule: ruleSelf},
+		{name: "client credentials", userID: "1008", clientID: "reports-job", scope: "openid " + defaultReadAnyScope, rule: ruleClientCredentials},
+	}
+
+	for _, testCase := range testCases {
+		buf.Reset()
 		req := createRequest(testCase.userID)
+		txn := (*newrelicApp()).StartTransaction("/test", httptest.NewRecorder(), req)
+		req = req.WithContext(context.WithValue(req.Context(), bootstraputils.ContextKey("txn"), txn))
+		event := &authrlib.AuditEvent{}
+		req = req.WithContext(authrlib.WithAuditEvent(req.Context(), event))
 		claims := map[string]interface{}{}
 		if testCase.sub != nil {
 			claims["sub"] = testCase.sub
 		}
-		if _, err := middlware.validateClaims(jwt.MapClaims(claims), req); testCase.err != "" && (err == nil || err.Error() != testCase.err) {
-			t.Fatalf("test case failed: '%s' [expected '%s'; got '%v']", testCase.name, testCase.err, err)
+		if testCase.clientID != "" {
+			claims["client_id"] = testCase.clientID
+		}
+		if testCase.scope != "" {
+			claims["scope"] = testCase.scope
+		}
+		_, err := middlware.validateClaims(jwt.MapClaims(claims), req)
+		if testCase.err != nil && !errors.Is(err, testCase.err) {
+			t.Fatalf("test case failed: '%s' [expected '%v'; got '%v']", testCase.name, testCase.err, err)
+		}
+		if testCase.rule != "" {
+			assert.NoError(t, err, testCase.name)
+			assert.Contains(t, buf.String(), `"rule":"`+testCase.rule+`"`, testCase.name)
 		}
+		assert.Equal(t, testCase.rule, event.Rule, testCase.name)
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestValidateClaimsTokenEndpoint(t *testing.T) {
+	config := &authrlib.Config{App: authrlib.AppConfig{ClientIDs: []string{"reports-job"}, ReadAnyScope: defaultReadAnyScope}}
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(config).Once()
+	ctx := &authrlib.AppContext{ConfigService: mockConfigService, Logger: &authrlib.AppLogger{Logger: zerolog.Nop()}}
+	middlware := newAccessValidationMiddleware(ctx, false)
+	testCases := []struct {
+		name   string
+		claims jwt.MapClaims
+		err    error
+	}{
+		{name: "client credentials", claims: jwt.MapClaims{"client_id": "reports-job", "scope": defaultReadAnyScope}, err: errSubNotFound},
+		{name: "client credentials with sub", claims: jwt.MapClaims{"client_id": "reports-job", "scope": defaultReadAnyScope, "sub": "reports-job"}, err: errSubInvalid},
+		{name: "end-user token of allowed client", claims: jwt.MapClaims{"client_id": "reports-job", "sub": "1008"}},
+	}
+
+	for _, testCase := range testCases {
+		req := createRequest("1008")
+		txn := (*newrelicApp()).StartTransaction("/test", httptest.NewRecorder(), req)
+		req = req.WithContext(context.WithValue(req.Context(), bootstraputils.ContextKey("txn"), txn))
+		_, err := middlware.validateClaims(testCase.claims, req)
+		if testCase.err == nil {
+			assert.NoError(t, err, testCase.name)
+		} else if !errors.Is(err, testCase.err) {
+			t.Fatalf("test case failed: '%s' [expected '%v'; got '%v']", testCase.name, testCase.err, err)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+	mockConfigService.AssertExpectations(t)
+}
+
+func TestValidateClaimsDelegatedAdmin(t *testing.T) {
+	var buf bytes.Buffer
+	service := &serviceMock{}
+	middlware := &accessValidationMiddleware{logger: &authrlib.AppLogger{Logger: zerolog.New(&buf)}, service: service}
+	admin := &authorization.Access{Admin: &authorization.AdminType{Ent: []int64{100}}}
+	service.On("Access", 7).Return(admin, nil)
+	service.On("Access", 108).Return(&authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{1001}}}, nil).Once()
+	service.On("Access", 109).Return(&authorization.Access{Teacher: &authorization.TeacherType{Cls: []int64{3001}}}, nil).Once()
+	service.On("Hierarchy", admin).Return(map[string]*entityHierarchy{"Admin": {Cls: []int64{1001}}}, nil).Twice()
+
+	testCases := []struct {
+		name   string
+		userID string
+		sub    string
+		err    error
+		log    string
+	}{
+		{name: "sub is not a user", userID: "108", sub: "client-1", err: errSubInvalid},
+		{name: "user within entities", userID: "108", sub: "7",
+			log: `"rule":"delegated-admin","caller_id":7,"user_id":108,"message":"access authorized"`},
+		{name: "user outside of entities", userID: "109", sub: "7", err: errUserOutsideEntities,
+			log: `"error":"user is outside of caller's entities","rule":"delegated-admin","caller_id":7,"user_id":109,"message":"access denied"`},
+	}
+
+	for _, testCase := range testCases {
+		buf.Reset()
+		req := createRequest(testCase.userID)
+		txn := (*newrelicApp()).StartTransaction("/test", httptest.NewRecorder(), req)
+		req = req.WithContext(context.WithValue(req.Context(), bootstraputils.ContextKey("txn"), txn))
+		_, err := middlware.validateClaims(jwt.MapClaims{"sub": testCase.sub}, req)
+		assert.Equal(t, testCase.err, err, testCase.name)
+		assert.Contains(t, buf.String(), testCase.log, testCase.name)
 		t.Log("test case ok:", testCase.name)
 	}
+	service.AssertExpectations(t)
 }
 
 func createRequest(userID string) *http.Request {
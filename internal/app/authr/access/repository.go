@@ -1,53 +1,132 @@
 package access
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
 )
 
+// default limit of a db lookup run time
+const defaultQueryTimeout = 30 * time.Second
+
 // Dao describes operations which can be done on the CCNET db
 type Dao interface {
 	// Qeries access data for user with given ID
-	QueryAccessData(int) ([]*accessDataRow, error)
+	QueryAccessData(context.Context, int) ([]*accessDataRow, error)
+	// Queries access data for several users at once, rows are grouped by user ID
+	QueryBatchAccessData(context.Context, []int) (map[int][]*accessDataRow, error)
+	// Queries descendants of entities limited by depth and max number of results
+	QueryEntityHierarchy(context.Context, []int64, int, int) (*entityHierarchy, error)
 }
 
 // DAO object which does logic related to quering db
 // keeps dbmanager to get db connection
 type accessRepo struct {
-	db *sql.DB
+	db      authrlib.DbReader
+	dialect *dialect
+	timeout time.Duration // limit of a lookup, shared by replica and primary attempts
 }
 
-func (r *accessRepo) QueryAccessData(userID int) ([]*accessDataRow, error) {
-	stmt, err := r.db.Prepare(query)
+// runs read on a replica, falling back to primary, within a single deadline
+func (r *accessRepo) read(ctx context.Context, read func(context.Context, *sql.DB) error) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.db.Read(ctx, func(db *sql.DB) error {
+		return read(ctx, db)
+	})
+}
+
+// queries are read-only, so they are routed to replicas
+func (r *accessRepo) QueryAccessData(ctx context.Context, userID int) (accessData []*accessDataRow, err error) {
+	err = r.read(ctx, func(ctx context.Context, db *sql.DB) (err error) {
+		accessData, err = r.queryAccessData(ctx, db, userID)
+		return err
+	})
+	return accessData, err
+}
+
+func (r *accessRepo) queryAccessData(ctx context.Context, db *sql.DB, userID int) ([]*accessDataRow, error) {
+	stmt, err := db.PrepareContext(ctx, r.dialect.query)
 	if err != nil {
-		return nil, err
+		return nil, queryError(ctx, err)
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(userID)
+	rows, err := stmt.QueryContext(ctx, userID)
 	if err != nil {
-		return nil, err
+		return nil, queryError(ctx, err)
 	}
 	defer rows.Close()
 	accessData := make([]*accessDataRow, 0)
 	for rows.Next() {
 		var row = new(accessDataRow)
-		err = rows.Scan(&row.userTypeID,
-			&row.adminTypeID,
-			&row.fundSourceAdminTypeID,
-			&row.superUserTypeID,
-			&row.fundSourceID,
-			&row.adminEntityID,
-			&row.fsAdminEntityID,
-			&row.classID,
-			&row.teacherTypeID,
-			&row.teamChildID)
-		if err != nil {
-			return nil, err
+		if err = rows.Scan(row.destinations()...); err != nil {
+			return nil, queryError(ctx, err)
 		}
 		accessData = append(accessData, row)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
 	return accessData, nil
 }
 
+func (r *accessRepo) QueryBatchAccessData(ctx context.Context, userIDs []int) (accessData map[int][]*accessDataRow, err error) {
+	if len(userIDs) == 0 {
+		return map[int][]*accessDataRow{}, nil
+	}
+	err = r.read(ctx, func(ctx context.Context, db *sql.DB) (err error) {
+		accessData, err = r.queryBatchAccessData(ctx, db, userIDs)
+		return err
+	})
+	return accessData, err
+}
+
+func (r *accessRepo) queryBatchAccessData(ctx context.Context, db *sql.DB, userIDs []int) (map[int][]*accessDataRow, error) {
+	accessData := make(map[int][]*accessDataRow, len(userIDs))
+	args := make([]interface{}, len(userIDs))
+	for i, userID := range userIDs {
+		args[i] = userID
+	}
+	stmt, err := db.PrepareContext(ctx, fmt.Sprintf(r.dialect.batchQuery, r.dialect.placeholders(1, len(userIDs))))
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int
+		var row = new(accessDataRow)
+		if err = rows.Scan(append([]interface{}{&userID}, row.destinations()...)...); err != nil {
+			return nil, queryError(ctx, err)
+		}
+		accessData[userID] = append(accessData[userID], row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return accessData, nil
+}
+
+// errors of statements which exceeded the deadline, either own or request's one, are timeouts;
+// statements cancelled by caller aren't db failures
+func queryError(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return authrerr.Wrap(err, authrerr.DBTimeout, "access data query timed out")
+	case context.Canceled:
+		return authrerr.Wrap(err, authrerr.RequestCancelled, "request is cancelled")
+	}
+	return err
+}
+
 type accessDataRow struct {
 	userTypeID            sql.NullInt64
 	adminTypeID           sql.NullInt64
@@ -61,8 +140,22 @@ type accessDataRow struct {
 	teamChildID           sql.NullInt64
 }
 
-const query = `
-	SELECT u.UserTypeID,
+// returns pointers to the row fields in the order of accessColumns
+func (row *accessDataRow) destinations() []interface{} {
+	return []interface{}{&row.userTypeID,
+		&row.adminTypeID,
+		&row.fundSourceAdminTypeID,
+		&row.superUserTypeID,
+		&row.fundSourceID,
+		&row.adminEntityID,
+		&row.fsAdminEntityID,
+		&row.classID,
+		&row.teacherTypeID,
+		&row.teamChildID}
+}
+
+// list of columns shared by single and batch access queries
+const accessColumns = `u.UserTypeID,
 		u.AdminTypeID,
 		u.FundSourceAdminTypeID,
 		u.SuperUserTypeID,
@@ -71,7 +164,10 @@ const query = `
 		COALESCE(efs.EntityID, efp.EntityID, efo.EntityID) AS FSAdminEntityID,
 		ct.ClassID,
 		ct.TeacherTypeID,
-		tci.ChildID AS TeamChildID
+		tci.ChildID AS TeamChildID`
+
+// joins shared by single and batch access queries
+const accessTables = `
 	FROM dbo.CC_Users u WITH (NOLOCK)
 	 LEFT JOIN dbo.CC_UserAssoc ua WITH (NOLOCK) ON ua.UserID = u.UserID
 	 LEFT JOIN dbo.CC_AdminFundSources fsa WITH (NOLOCK) ON fsa.UserID = u.UserID
@@ -83,5 +179,14 @@ const query = `
 	 LEFT JOIN dbo.G2_EntityLink efs WITH (NOLOCK) ON fsua.SiteID = efs.SiteID
 	 LEFT JOIN dbo.G2_EntityLink efp WITH (NOLOCK) ON fsua.ProgramID = efp.ProgramID
 	 LEFT JOIN dbo.G2_EntityLink efo WITH (NOLOCK) ON fsua.OrganizationID = efo.OrganizationID
-	 LEFT JOIN dbo.CC_ClassesTeachers ct WITH (NOLOCK) ON ct.TeacherID = u.UserID
+	 LEFT JOIN dbo.CC_ClassesTeachers ct WITH (NOLOCK) ON ct.TeacherID = u.UserID`
+
+const query = `
+	SELECT ` + accessColumns + accessTables + `
 WHERE u.UserID = ?`
+
+// set-based variant of query, the first column is UserID; %s is replaced by placeholders
+const batchQuery = `
+	SELECT u.UserID,
+		` + accessColumns + accessTables + `
+WHERE u.UserID IN (%s)`
@@ -1,15 +1,35 @@
 package access
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 )
 
+// reads from a single db
+type dbReaderMock struct{ db *sql.DB }
+
+func (m *dbReaderMock) Read(_ context.Context, read func(*sql.DB) error) error { return read(m.db) }
+
+// reader which repeats failed read on primary regardless of deadline
+type failoverReaderMock struct{ replica, primary *sql.DB }
+
+func (m *failoverReaderMock) Read(_ context.Context, read func(*sql.DB) error) error {
+	if err := read(m.replica); err == nil {
+		return nil
+	}
+	return read(m.primary)
+}
+
 func TestQueryAccessData(t *testing.T) {
 	var columns = []string{"UserTypeID", "AdminTypeID", "FundSourceAdminTypeID",
 		"SuperUserTypeID", "FundSourceID", "AdminEntityID", "FSAdminEntityID",
@@ -23,7 +43,7 @@ func TestQueryAccessData(t *testing.T) {
 	if err != nil {
 		t.Errorf("unable to create db mock: %v", err)
 	}
-	repo = &accessRepo{db}
+	repo = &accessRepo{db: &dbReaderMock{db}, dialect: mssqlDialect, timeout: time.Second}
 
 	testCases := []struct {
 		name        string
@@ -61,7 +81,7 @@ func TestQueryAccessData(t *testing.T) {
 	for _, testCase := range testCases {
 		testErr := errors.New(testCase.err)
 		testCase.prepareMock(testCase.err)
-		if data, err := repo.QueryAccessData(333); testCase.err != "" && (err == nil || err.Error() != testCase.err) {
+		if data, err := repo.QueryAccessData(context.Background(), 333); testCase.err != "" && (err == nil || err.Error() != testCase.err) {
 			t.Fatalf("test case failed: '%s' [expect %v; got %v]", testCase.name, testErr, err)
 		} else if testCase.err == "" {
 			if result := assert.Equal(t, len(data), 2); !result {
@@ -73,3 +93,115 @@ func TestQueryAccessData(t *testing.T) {
 		t.Log("test case ok:", testCase.name)
 	}
 }
+
+func TestQueryBatchAccessData(t *testing.T) {
+	var columns = []string{"UserID", "UserTypeID", "AdminTypeID", "FundSourceAdminTypeID",
+		"SuperUserTypeID", "FundSourceID", "AdminEntityID", "FSAdminEntityID",
+		"ClassID", "TeacherTypeID", "TeamChildID"}
+
+	sqlQuery := regexp.QuoteMeta(fmt.Sprintf(batchQuery, "?, ?"))
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create db mock: %v", err)
+	}
+	defer db.Close()
+	repo := &accessRepo{db: &dbReaderMock{db}, dialect: mssqlDialect, timeout: time.Second}
+
+	// empty input does not hit db
+	data, err := repo.QueryBatchAccessData(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	testCases := []struct {
+		name        string
+		err         string
+		prepareMock func(string)
+	}{
+		{
+			name: "sql prepare query error",
+			err:  "prepare error", prepareMock: func(err string) {
+				mock.ExpectPrepare(sqlQuery).WillReturnError(errors.New(err))
+			}},
+		{
+			name: "sql query error",
+			err:  "query error",
+			prepareMock: func(err string) {
+				mock.ExpectPrepare(sqlQuery).WillBeClosed().ExpectQuery().WithArgs(333, 444).WillReturnError(errors.New(err))
+			}},
+		{
+			name: "sql rows scan error",
+			err:  "sql: Scan error on column index 10, name \"TeamChildID\": converting driver.Value type string (\"\") to a int64: invalid syntax",
+			prepareMock: func(string) {
+				rows := sqlmock.NewRows(columns).AddRow(333, 10, 9, 8, 7, 6, 5, 4, 3, 2, "")
+				mock.ExpectPrepare(sqlQuery).WillBeClosed().ExpectQuery().WithArgs(333, 444).WillReturnRows(rows)
+			}},
+		{
+			name: "success case",
+			err:  "",
+			prepareMock: func(string) {
+				rows := sqlmock.NewRows(columns).
+					AddRow(333, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10).
+					AddRow(333, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1).
+					AddRow(444, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+				mock.ExpectPrepare(sqlQuery).WillBeClosed().ExpectQuery().WithArgs(333, 444).WillReturnRows(rows)
+			}},
+	}
+
+	for _, testCase := range testCases {
+		testCase.prepareMock(testCase.err)
+		data, err := repo.QueryBatchAccessData(context.Background(), []int{333, 444})
+		if testCase.err != "" {
+			if err == nil || err.Error() != testCase.err {
+				t.Fatalf("test case failed: '%s' [expect %v; got %v]", testCase.name, testCase.err, err)
+			}
+		} else if result := assert.Equal(t, 2, len(data[333])) && assert.Equal(t, 1, len(data[444])); !result {
+			t.Logf("test case failed: %s", testCase.name)
+			continue
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestQueryAccessDataTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create db mock: %v", err)
+	}
+	defer db.Close()
+	repo := &accessRepo{db: &dbReaderMock{db}, dialect: mssqlDialect, timeout: 10 * time.Millisecond}
+
+	// statement exceeding own timeout
+	mock.ExpectPrepare(regexp.QuoteMeta(query)).ExpectQuery().
+		WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"UserTypeID"}))
+	_, err = repo.QueryAccessData(context.Background(), 333)
+	assert.True(t, errors.Is(err, authrerr.New(authrerr.DBTimeout, "")))
+	assert.Equal(t, 504, authrerr.Status(err))
+
+	// cancelled request is not a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = repo.QueryAccessData(ctx, 333)
+	assert.True(t, errors.Is(err, authrerr.New(authrerr.RequestCancelled, "")))
+	assert.Equal(t, authrerr.StatusClientClosedRequest, authrerr.Status(err))
+}
+
+func TestQueryAccessDataFailoverDeadline(t *testing.T) {
+	replica, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create db mock: %v", err)
+	}
+	defer replica.Close()
+	primary, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create db mock: %v", err)
+	}
+	defer primary.Close()
+	repo := &accessRepo{db: &failoverReaderMock{replica, primary}, dialect: mssqlDialect, timeout: 20 * time.Millisecond}
+
+	// replica exhausts the deadline, primary doesn't get a fresh one
+	replicaMock.ExpectPrepare(regexp.QuoteMeta(query)).ExpectQuery().
+		WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"UserTypeID"}))
+	_, err = repo.QueryAccessData(context.Background(), 333)
+	assert.True(t, errors.Is(err, authrerr.New(authrerr.DBTimeout, "")))
+	assert.NoError(t, primaryMock.ExpectationsWereMet())
+}
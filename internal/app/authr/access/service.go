@@ -1,41 +1,231 @@
 package access
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
 
+	"bitbucket.org/teachingstrategies/authorization-service/internal/app/authr/webhook"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/authorization-service/pkg/permission"
 	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
 )
 
+// defaults of in-process cache
+const (
+	defaultCacheTTL     = 5 * time.Minute
+	defaultCacheMaxSize = 10000
+)
+
 // Service represents access service functionality
 type Service interface {
 	// fetch access data and convert it to *authorization.Access object
-	Access(int) (*authorization.Access, error)
+	Access(context.Context, int) (*authorization.Access, error)
+	// fetch access data for several users, every user gets its own status
+	BatchAccess(context.Context, []int) (map[int]*batchAccessItem, error)
+	// expand entities of admin roles down the CCNET hierarchy
+	Hierarchy(context.Context, *authorization.Access) (map[string]*entityHierarchy, error)
 }
 
 // holds objects required to manage flow
 type accessService struct {
-	conv Converter // dbobject to rest object coverter
-	repo Dao       // dao
+	mu    sync.RWMutex         // guards roles mapping, which is replaced on config reload
+	conv  Converter            // dbobject to rest object coverter
+	repo  Dao                  // dao
+	roles authrlib.RolesConfig // keeps user types allowed to request permissions
+
+	maxDepth   int // how many hierarchy levels are expanded below admin entity
+	maxResults int // max number of descendants per admin role
+}
+
+// NewAccessService creates access service, wrapped by cache if it's enabled in configuration;
+// changes of access fetched from db are recorded to history unless it's nil and published unless publisher is nil.
+// Roles mapping and cache TTL follow reloaded config, cached access expires by its TTL
+func NewAccessService(ctx *authrlib.AppContext, history HistoryStore, publisher webhook.Publisher) Service {
+	config := ctx.ConfigService.Config()
+	queryTimeout := config.MsSQL.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = defaultQueryTimeout
+	}
+	var repo Dao
+	if config.MsSQL.DriverName() == authrlib.BackendFixture {
+		fixtureDao, err := NewFixtureDao(config.MsSQL.FixturesFile)
+		if err != nil {
+			panic("unable to load db fixtures: " + err.Error())
+		}
+		repo = fixtureDao
+	} else {
+		dialect, ok := dialects[config.MsSQL.DriverName()]
+		if !ok {
+			dialect = mssqlDialect
+		}
+		repo = &accessRepo{db: ctx.DbManager, dialect: dialect, timeout: queryTimeout}
+	}
+	plain := &accessService{
+		conv:       &accessConverter{config.Roles},
+		repo:       repo,
+		roles:      config.Roles,
+		maxDepth:   config.Hierarchy.MaxDepth,
+		maxResults: config.Hierarchy.MaxResults,
+	}
+	if plain.maxDepth <= 0 {
+		plain.maxDepth = defaultHierarchyMaxDepth
+	}
+	if plain.maxResults <= 0 {
+		plain.maxResults = defaultHierarchyMaxResults
+	}
+	ctx.ConfigService.Subscribe(func(old, new *authrlib.Config) {
+		if !reflect.DeepEqual(old.Roles, new.Roles) {
+			plain.setRoles(new.Roles)
+			ctx.Logger.Info().Msg("roles mapping reloaded")
+		}
+	})
+	var service Service = plain
+	// changes are detected against the last version kept in history, config validation requires it for webhooks
+	if history != nil {
+		service = newHistoryService(service, history, publisher, ctx.Logger)
+	}
+	cacheConfig := config.Cache
+	if !cacheConfig.Enabled {
+		return service
+	}
+	maxSize := cacheConfig.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultCacheMaxSize
+	}
+	local := newLRUCache(cacheTTL(cacheConfig), maxSize)
+	ctx.ConfigService.Subscribe(func(old, new *authrlib.Config) {
+		if ttl := cacheTTL(new.Cache); ttl != cacheTTL(old.Cache) {
+			local.setTTL(ttl)
+			ctx.Logger.Info().Dur("ttl", ttl).Msg("cache ttl reloaded")
+		}
+	})
+	var cache Cache = local
+	if cacheConfig.Redis.Enabled {
+		shared := newRedisCache(cacheConfig.Redis, ctx.Logger)
+		shared.subscribe(cache.Delete)
+		// lookups fall back to db while redis is down
+		ctx.Probes.AddCheck("Redis", false, shared.ping)
+		cache = &tieredCache{local: cache, shared: shared}
+	}
+	return newCachingService(service, cache, queryTimeout, ctx.NewRelicService)
+}
+
+// ttl of in-process cache, default if it's not configured
+func cacheTTL(config authrlib.CacheConfig) time.Duration {
+	if config.TTL <= 0 {
+		return defaultCacheTTL
+	}
+	return config.TTL
+}
+
+// per user result of batch access lookup
+type batchAccessItem struct {
+	Status  int                   `json:"status"`
+	Code    authrerr.Code         `json:"code,omitempty"`
+	Message string                `json:"message,omitempty"`
+	Access  *authorization.Access `json:"access,omitempty"`
 }
 
 var (
-	errNotFound   = errors.New("user not found")
-	errNotAllowed = errors.New("user not allowed")
-	// a map which contains user type IDs allowed to request permissions
-	allowedUserTypeIDs = map[int64]struct{}{1: {}, 3: {}, 4:{}, 5: {}, 7: {}}
+	errNotFound   = authrerr.New(authrerr.UserNotFound, "user not found")
+	errNotAllowed = authrerr.New(authrerr.UserTypeNotAllowed, "user not allowed")
 )
 
+// db errors are reported to clients without details
+const errDBUnavailableMessage = "access data is temporarily unavailable"
+
 // Access operates flow
-func (serv *accessService) Access(userID int) (*authorization.Access, error) {
-	relationalAccess, err := serv.repo.QueryAccessData(userID)
+func (serv *accessService) Access(ctx context.Context, userID int) (*authorization.Access, error) {
+	relationalAccess, err := serv.repo.QueryAccessData(ctx, userID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return serv.convert(ctx, relationalAccess)
+}
+
+// BatchAccess operates flow for several users; a db error fails the whole batch,
+// not found and not allowed users are reported per user
+func (serv *accessService) BatchAccess(ctx context.Context, userIDs []int) (map[int]*batchAccessItem, error) {
+	relationalAccess, err := serv.repo.QueryBatchAccessData(ctx, userIDs)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
+	}
+	result := make(map[int]*batchAccessItem, len(userIDs))
+	for _, userID := range userIDs {
+		access, err := serv.convert(ctx, relationalAccess[userID])
+		if err != nil {
+			e := authrerr.From(err)
+			result[userID] = &batchAccessItem{Status: e.Code.Status(), Code: e.Code, Message: e.Message}
+			continue
+		}
+		result[userID] = &batchAccessItem{Status: http.StatusOK, Access: access}
+	}
+	return result, nil
+}
+
+// Hierarchy expands entities of every admin role; roles without entities are skipped
+func (serv *accessService) Hierarchy(ctx context.Context, access *authorization.Access) (map[string]*entityHierarchy, error) {
+	roles := make(map[string][]int64)
+	for role, admin := range map[string]*authorization.AdminType{
+		permission.RoleAdmin:          access.Admin,
+		permission.RoleVOAdmin:        access.VOAdmin,
+		permission.RoleVONoChildAdmin: access.VONoChildAdmin,
+	} {
+		if admin != nil && len(admin.Ent) > 0 {
+			roles[role] = admin.Ent
+		}
 	}
+	for role, admin := range map[string]*authorization.FsAdminType{
+		permission.RoleFSAdmin:   access.FSAdmin,
+		permission.RoleFSVOAdmin: access.FSVOAdmin,
+	} {
+		if admin != nil && len(admin.Ent) > 0 {
+			roles[role] = admin.Ent
+		}
+	}
+	result := make(map[string]*entityHierarchy, len(roles))
+	for role, entityIDs := range roles {
+		hierarchy, err := serv.repo.QueryEntityHierarchy(ctx, entityIDs, serv.maxDepth, serv.maxResults)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		result[role] = hierarchy
+	}
+	return result, nil
+}
+
+// checks user type and converts rows of a single user
+func (serv *accessService) convert(ctx context.Context, relationalAccess []*accessDataRow) (*authorization.Access, error) {
 	if len(relationalAccess) == 0 || !relationalAccess[0].userTypeID.Valid {
 		return nil, errNotFound
 	}
-	if _, ok := allowedUserTypeIDs[relationalAccess[0].userTypeID.Int64]; !ok {
+	serv.mu.RLock()
+	roles, conv := serv.roles, serv.conv
+	serv.mu.RUnlock()
+	if !roles.IsAllowed(relationalAccess[0].userTypeID.Int64) {
 		return nil, errNotAllowed
 	}
-	return serv.conv.Convert(relationalAccess), nil
+	return conv.Convert(ctx, relationalAccess), nil
+}
+
+// replaces roles mapping of user types check and converter
+func (serv *accessService) setRoles(roles authrlib.RolesConfig) {
+	serv.mu.Lock()
+	defer serv.mu.Unlock()
+	serv.roles = roles
+	serv.conv = &accessConverter{roles}
+}
+
+// db errors which aren't classified by repo are reported as unavailable db
+func dbError(err error) error {
+	var e *authrerr.Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return authrerr.Wrap(err, authrerr.DBUnavailable, errDBUnavailableMessage)
 }
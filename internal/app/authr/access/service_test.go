@@ -1,22 +1,30 @@
 package access
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"net/http"
 	"testing"
+	"time"
 
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
 	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/health"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 type accessServiceDepsMock struct{ mock.Mock }
 
-func (m *accessServiceDepsMock) Convert(rows []*accessDataRow) *authorization.Access { //mock converter's method
+func (m *accessServiceDepsMock) Convert(_ context.Context, rows []*accessDataRow) *authorization.Access { //mock converter's method
 	args := m.Called(rows)
 	return args.Get(0).(*authorization.Access)
 }
 
-func (m *accessServiceDepsMock) QueryAccessData(userID int) ([]*accessDataRow, error) { // mock dao method
+func (m *accessServiceDepsMock) QueryAccessData(_ context.Context, userID int) ([]*accessDataRow, error) { // mock dao method
 	args := m.Called(userID)
 	data := args.Get(0)
 	if data == nil {
@@ -25,6 +33,97 @@ func (m *accessServiceDepsMock) QueryAccessData(userID int) ([]*accessDataRow, e
 	return data.([]*accessDataRow), args.Error(1)
 }
 
+func (m *accessServiceDepsMock) QueryBatchAccessData(_ context.Context, userIDs []int) (map[int][]*accessDataRow, error) { // mock dao method
+	args := m.Called(userIDs)
+	data := args.Get(0)
+	if data == nil {
+		return nil, args.Error(1)
+	}
+	return data.(map[int][]*accessDataRow), args.Error(1)
+}
+
+func (m *accessServiceDepsMock) QueryEntityHierarchy(_ context.Context, entityIDs []int64, maxDepth, maxResults int) (*entityHierarchy, error) { // mock dao method
+	args := m.Called(entityIDs, maxDepth, maxResults)
+	data := args.Get(0)
+	if data == nil {
+		return nil, args.Error(1)
+	}
+	return data.(*entityHierarchy), args.Error(1)
+}
+
+func TestNewAccessService(t *testing.T) {
+	ctx := &authrlib.AppContext{DbManager: &dbManagerMock{}, ConfigService: configWithCache(authrlib.CacheConfig{})}
+	plain, ok := NewAccessService(ctx, nil, nil).(*accessService)
+	assert.True(t, ok)
+	assert.Equal(t, defaultHierarchyMaxDepth, plain.maxDepth)
+	assert.Equal(t, defaultHierarchyMaxResults, plain.maxResults)
+
+	ctx.ConfigService = configWithCache(authrlib.CacheConfig{Enabled: true})
+	service, ok := NewAccessService(ctx, nil, nil).(*cachingService)
+	assert.True(t, ok)
+	cache := service.cache.(*lruCache)
+	assert.Equal(t, defaultCacheTTL, cache.ttl)
+	assert.Equal(t, defaultCacheMaxSize, cache.maxSize)
+
+	ctx.ConfigService = configWithCache(authrlib.CacheConfig{Enabled: true, Redis: authrlib.RedisConfig{Enabled: true}})
+	ctx.Logger = &authrlib.AppLogger{Logger: zerolog.Nop()}
+	ctx.Healthchecks = health.NewHealthCheckCollection()
+	ctx.Probes = authrlib.NewProbes(ctx)
+	service, ok = NewAccessService(ctx, nil, nil).(*cachingService)
+	assert.True(t, ok)
+	tiered, ok := service.cache.(*tieredCache)
+	assert.True(t, ok)
+	// redis isn't running, lookups fall back to db
+	results := ctx.Probes.Results()
+	assert.Equal(t, "Redis", results[0].Name)
+	assert.False(t, results[0].Critical)
+	assert.False(t, results[0].Healthy)
+	assert.NoError(t, tiered.shared.(*redisCache).Close())
+
+	// fixture db backend
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(&authrlib.Config{MsSQL: authrlib.MsSQLConfig{
+		Backend: authrlib.BackendFixture, FixturesFile: "testdata/conformance/fixtures.yml"}})
+	ctx = &authrlib.AppContext{ConfigService: mockConfigService}
+	plain, ok = NewAccessService(ctx, nil, nil).(*accessService)
+	assert.True(t, ok)
+	_, ok = plain.repo.(*fixtureDao)
+	assert.True(t, ok)
+
+	mockConfigService = &configServiceMock{}
+	mockConfigService.On("Config").Return(&authrlib.Config{MsSQL: authrlib.MsSQLConfig{
+		Backend: authrlib.BackendFixture, FixturesFile: "testdata/missing.yml"}})
+	ctx.ConfigService = mockConfigService
+	assert.Panics(t, func() { NewAccessService(ctx, nil, nil) })
+}
+
+func TestNewAccessServiceReload(t *testing.T) {
+	old := &authrlib.Config{Cache: authrlib.CacheConfig{Enabled: true}, Roles: authrlib.DefaultRolesConfig()}
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(old)
+	ctx := &authrlib.AppContext{DbManager: &dbManagerMock{}, ConfigService: mockConfigService,
+		Logger: &authrlib.AppLogger{Logger: zerolog.Nop()}}
+	service := NewAccessService(ctx, nil, nil).(*cachingService)
+	plain, cache := service.Service.(*accessService), service.cache.(*lruCache)
+	assert.Equal(t, 2, len(mockConfigService.listeners))
+
+	roles := authrlib.DefaultRolesConfig()
+	roles.AllowedUserTypes = []int64{1}
+	reloaded := &authrlib.Config{Cache: authrlib.CacheConfig{Enabled: true, TTL: time.Minute}, Roles: roles}
+	for _, listener := range mockConfigService.listeners {
+		listener(old, reloaded)
+	}
+	assert.Equal(t, roles, plain.roles)
+	assert.Equal(t, &accessConverter{roles}, plain.conv)
+	assert.Equal(t, time.Minute, cache.ttl)
+
+	// default ttl is restored when it's removed from config
+	for _, listener := range mockConfigService.listeners {
+		listener(reloaded, old)
+	}
+	assert.Equal(t, defaultCacheTTL, cache.ttl)
+}
+
 func TestAccess(t *testing.T) {
 	testCases := []struct {
 		name      string
@@ -34,10 +133,16 @@ func TestAccess(t *testing.T) {
 	}{
 		{
 			name:      "db error",
-			err:       "my query db error",
+			err:       "access data is temporarily unavailable: my query db error",
 			rows:      nil,
 			accessErr: errors.New("my query db error"),
 		},
+		{
+			name:      "db timeout",
+			err:       "access data query timed out: context deadline exceeded",
+			rows:      nil,
+			accessErr: authrerr.Wrap(context.DeadlineExceeded, authrerr.DBTimeout, "access data query timed out"),
+		},
 		{
 			name:      "not found (empty db result)",
 			err:       errNotFound.Error(),
@@ -65,7 +170,7 @@ func TestAccess(t *testing.T) {
 	}
 	for _, testCase := range testCases {
 		mock := &accessServiceDepsMock{}
-		service := &accessService{conv: mock, repo: mock}
+		service := &accessService{conv: mock, repo: mock, roles: authrlib.DefaultRolesConfig()}
 		testErr := errors.New(testCase.err)
 		convReply := &authorization.Access{}
 
@@ -74,7 +179,7 @@ func TestAccess(t *testing.T) {
 		}
 
 		mock.On("QueryAccessData", 42).Return(testCase.rows, testCase.accessErr).Once()
-		if reply, err := service.Access(42); testCase.err != "" && (err == nil || err.Error() != testCase.err) {
+		if reply, err := service.Access(context.Background(), 42); testCase.err != "" && (err == nil || err.Error() != testCase.err) {
 			t.Fatalf("test case failed: '%s' [expect '%v'; got '%v']", testCase.name, testErr, err)
 			continue
 		} else if testCase.err == "" && reply != convReply {
@@ -89,3 +194,63 @@ func TestAccess(t *testing.T) {
 
 	}
 }
+
+func TestBatchAccess(t *testing.T) {
+	// db error fails whole batch
+	mock := &accessServiceDepsMock{}
+	service := &accessService{conv: mock, repo: mock, roles: authrlib.DefaultRolesConfig()}
+	mock.On("QueryBatchAccessData", []int{1, 2}).Return(nil, errors.New("my query db error")).Once()
+	reply, err := service.BatchAccess(context.Background(), []int{1, 2})
+	assert.Nil(t, reply)
+	assert.EqualError(t, err, "access data is temporarily unavailable: my query db error")
+	assert.True(t, errors.Is(err, authrerr.New(authrerr.DBUnavailable, "")))
+	mock.AssertExpectations(t)
+
+	// per user statuses
+	mock = &accessServiceDepsMock{}
+	service = &accessService{conv: mock, repo: mock, roles: authrlib.DefaultRolesConfig()}
+	allowedRows := []*accessDataRow{{userTypeID: sql.NullInt64{Int64: 1, Valid: true}}}
+	rows := map[int][]*accessDataRow{
+		1: allowedRows,
+		2: {{userTypeID: sql.NullInt64{Int64: 6, Valid: true}}},
+		3: {{userTypeID: sql.NullInt64{Int64: 0, Valid: false}}},
+	}
+	convReply := &authorization.Access{}
+	mock.On("QueryBatchAccessData", []int{1, 2, 3, 4}).Return(rows, nil).Once()
+	mock.On("Convert", allowedRows).Return(convReply).Once()
+	reply, err = service.BatchAccess(context.Background(), []int{1, 2, 3, 4})
+	assert.NoError(t, err)
+	assert.Equal(t, &batchAccessItem{Status: http.StatusOK, Access: convReply}, reply[1])
+	assert.Equal(t, &batchAccessItem{Status: http.StatusForbidden, Code: authrerr.UserTypeNotAllowed, Message: errNotAllowed.Error()}, reply[2])
+	assert.Equal(t, &batchAccessItem{Status: http.StatusNotFound, Code: authrerr.UserNotFound, Message: errNotFound.Error()}, reply[3])
+	assert.Equal(t, &batchAccessItem{Status: http.StatusNotFound, Code: authrerr.UserNotFound, Message: errNotFound.Error()}, reply[4])
+	mock.AssertExpectations(t)
+}
+
+func TestHierarchy(t *testing.T) {
+	access := &authorization.Access{
+		Teacher:   &authorization.TeacherType{Cls: []int64{1}},
+		Admin:     &authorization.AdminType{Ent: []int64{10}},
+		VOAdmin:   &authorization.AdminType{},
+		FSVOAdmin: &authorization.FsAdminType{AdminType: authorization.AdminType{Ent: []int64{20}}},
+	}
+
+	// db error
+	mock := &accessServiceDepsMock{}
+	service := &accessService{conv: mock, repo: mock, maxDepth: 2, maxResults: 5}
+	mock.On("QueryEntityHierarchy", []int64{10}, 2, 5).Return(nil, errors.New("db error")).Once()
+	_, err := service.Hierarchy(context.Background(), &authorization.Access{Admin: access.Admin})
+	assert.EqualError(t, err, "access data is temporarily unavailable: db error")
+	mock.AssertExpectations(t)
+
+	// only admin roles with entities are expanded
+	mock = &accessServiceDepsMock{}
+	service = &accessService{conv: mock, repo: mock, maxDepth: 2, maxResults: 5}
+	admin, fsvoadmin := &entityHierarchy{Ent: []int64{11}}, &entityHierarchy{Cls: []int64{21}}
+	mock.On("QueryEntityHierarchy", []int64{10}, 2, 5).Return(admin, nil).Once()
+	mock.On("QueryEntityHierarchy", []int64{20}, 2, 5).Return(fsvoadmin, nil).Once()
+	result, err := service.Hierarchy(context.Background(), access)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*entityHierarchy{"Admin": admin, "FSVOAdmin": fsvoadmin}, result)
+	mock.AssertExpectations(t)
+}
@@ -0,0 +1,56 @@
+-- minimal CCNET schema and fixtures of the Dao conformance suite, shared by all backends
+-- tables are dropped and recreated, never point the suite to a real CCNET db
+DROP TABLE IF EXISTS dbo.CC_Users;
+DROP TABLE IF EXISTS dbo.CC_UserAssoc;
+DROP TABLE IF EXISTS dbo.CC_AdminFundSources;
+DROP TABLE IF EXISTS dbo.CC_FSUserAssoc;
+DROP TABLE IF EXISTS dbo.CC_TC_Invitations;
+DROP TABLE IF EXISTS dbo.G2_EntityLink;
+DROP TABLE IF EXISTS dbo.CC_ClassesTeachers;
+DROP TABLE IF EXISTS dbo.CC_Programs;
+DROP TABLE IF EXISTS dbo.CC_Sites;
+DROP TABLE IF EXISTS dbo.CC_Classes;
+
+CREATE TABLE dbo.CC_Users (UserID INT PRIMARY KEY, UserTypeID INT, AdminTypeID INT, FundSourceAdminTypeID INT, SuperUserTypeID INT);
+CREATE TABLE dbo.CC_UserAssoc (UserID INT, SiteID INT, ProgramID INT, OrganizationID INT);
+CREATE TABLE dbo.CC_AdminFundSources (UserID INT, FundSourceID INT);
+CREATE TABLE dbo.CC_FSUserAssoc (UserID INT, SiteID INT, ProgramID INT, OrganizationID INT);
+CREATE TABLE dbo.CC_TC_Invitations (UserID INT, ChildID INT, InvitationStatusID INT);
+CREATE TABLE dbo.G2_EntityLink (EntityID INT PRIMARY KEY, OrganizationID INT, ProgramID INT, SiteID INT);
+CREATE TABLE dbo.CC_ClassesTeachers (TeacherID INT, ClassID INT, TeacherTypeID INT);
+CREATE TABLE dbo.CC_Programs (ProgramID INT PRIMARY KEY, OrganizationID INT);
+CREATE TABLE dbo.CC_Sites (SiteID INT PRIMARY KEY, ProgramID INT);
+CREATE TABLE dbo.CC_Classes (ClassID INT PRIMARY KEY, SiteID INT);
+
+-- organization 31 -> program 21 -> sites 11, 12 -> classes 101, 102, 103; site 12 has no entity
+INSERT INTO dbo.G2_EntityLink (EntityID, OrganizationID, ProgramID, SiteID) VALUES (1031, 31, NULL, NULL);
+INSERT INTO dbo.G2_EntityLink (EntityID, OrganizationID, ProgramID, SiteID) VALUES (1021, NULL, 21, NULL);
+INSERT INTO dbo.G2_EntityLink (EntityID, OrganizationID, ProgramID, SiteID) VALUES (1011, NULL, NULL, 11);
+INSERT INTO dbo.CC_Programs (ProgramID, OrganizationID) VALUES (21, 31);
+INSERT INTO dbo.CC_Sites (SiteID, ProgramID) VALUES (11, 21);
+INSERT INTO dbo.CC_Sites (SiteID, ProgramID) VALUES (12, 21);
+INSERT INTO dbo.CC_Classes (ClassID, SiteID) VALUES (101, 11);
+INSERT INTO dbo.CC_Classes (ClassID, SiteID) VALUES (102, 11);
+INSERT INTO dbo.CC_Classes (ClassID, SiteID) VALUES (103, 12);
+
+-- 1: teacher of class 101 and co-teacher of class 102
+INSERT INTO dbo.CC_Users (UserID, UserTypeID, AdminTypeID, FundSourceAdminTypeID, SuperUserTypeID) VALUES (1, 1, NULL, NULL, 0);
+INSERT INTO dbo.CC_ClassesTeachers (TeacherID, ClassID, TeacherTypeID) VALUES (1, 101, 1);
+INSERT INTO dbo.CC_ClassesTeachers (TeacherID, ClassID, TeacherTypeID) VALUES (1, 102, 2);
+
+-- 2: admin of site 11
+INSERT INTO dbo.CC_Users (UserID, UserTypeID, AdminTypeID, FundSourceAdminTypeID, SuperUserTypeID) VALUES (2, 3, 0, NULL, 0);
+INSERT INTO dbo.CC_UserAssoc (UserID, SiteID, ProgramID, OrganizationID) VALUES (2, 11, NULL, NULL);
+
+-- 3: team member of child 501, invitation to child 502 is not accepted
+INSERT INTO dbo.CC_Users (UserID, UserTypeID, AdminTypeID, FundSourceAdminTypeID, SuperUserTypeID) VALUES (3, 5, NULL, NULL, 0);
+INSERT INTO dbo.CC_TC_Invitations (UserID, ChildID, InvitationStatusID) VALUES (3, 501, 4);
+INSERT INTO dbo.CC_TC_Invitations (UserID, ChildID, InvitationStatusID) VALUES (3, 502, 1);
+
+-- 4: fund source admin of fund source 9001 in program 21
+INSERT INTO dbo.CC_Users (UserID, UserTypeID, AdminTypeID, FundSourceAdminTypeID, SuperUserTypeID) VALUES (4, 7, NULL, 0, 0);
+INSERT INTO dbo.CC_AdminFundSources (UserID, FundSourceID) VALUES (4, 9001);
+INSERT INTO dbo.CC_FSUserAssoc (UserID, SiteID, ProgramID, OrganizationID) VALUES (4, NULL, 21, NULL);
+
+-- 5: superuser
+INSERT INTO dbo.CC_Users (UserID, UserTypeID, AdminTypeID, FundSourceAdminTypeID, SuperUserTypeID) VALUES (5, 3, 0, NULL, 1);
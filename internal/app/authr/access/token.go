@@ -0,0 +1,88 @@
+package access
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gamegos/jsend"
+	"github.com/go-chi/chi"
+)
+
+// default lifetime of issued access tokens
+const defaultTokenTTL = 5 * time.Minute
+
+// claims of access token issued by the service
+type accessClaims struct {
+	jwt.StandardClaims
+	Access *authorization.Access `json:"access"`
+}
+
+// reply of token endpoint
+type tokenResponse struct {
+	Token     string `json:"token"`
+	TokenType string `json:"tokenType"`
+	ExpiresIn int64  `json:"expiresIn"`
+}
+
+// NewTokenHandler creates new instance of handler which mints tokens with resolved access of a user
+func NewTokenHandler(ctx *authrlib.AppContext, service Service) http.HandlerFunc {
+	return (&tokenHandler{ctx: ctx, service: service, signer: ctx.TokenSigner, now: time.Now}).handlerFunc()
+}
+
+// struct which produces http.HandlerFunc
+type tokenHandler struct {
+	ctx     *authrlib.AppContext
+	service Service
+	signer  authrlib.TokenSigner
+	now     func() time.Time
+}
+
+func (th *tokenHandler) handlerFunc() http.HandlerFunc {
+	conf := th.ctx.ConfigService.Config().App.Token
+	ttl := conf.TTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := th.ctx.Logger.HandlerLogger(r)
+		if th.signer == nil {
+			replyError(authrerr.New(authrerr.TokenIssuingDisabled, "token issuing is not configured"), logger, w)
+			return
+		}
+		userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+		if err != nil {
+			replyError(authrerr.Wrap(err, authrerr.InvalidRequest, "userID has incorrect value"), logger, w)
+			return
+		}
+		access, err := th.service.Access(r.Context(), userID)
+		if err != nil {
+			replyAccessError(userID, err, logger, w)
+			return
+		}
+		auditChildren(r, access)
+		now := th.now()
+		token, err := th.signer.Sign(&accessClaims{
+			StandardClaims: jwt.StandardClaims{
+				Subject:   strconv.Itoa(userID),
+				Issuer:    conf.Issuer,
+				Audience:  conf.Audience,
+				IssuedAt:  now.Unix(),
+				ExpiresAt: now.Add(ttl).Unix(),
+			},
+			Access: access,
+		})
+		if err != nil {
+			replyAccessError(userID, authrerr.Wrap(err, authrerr.Internal, "unable to sign token"), logger, w)
+			return
+		}
+		resp := &tokenResponse{Token: token, TokenType: "Bearer", ExpiresIn: int64(ttl / time.Second)}
+		if _, err = jsend.Wrap(w).Message("request completed").Data(resp).Status(http.StatusOK).Send(); err != nil {
+			logger.Warn().Err(err).Msg("unable to reply success")
+		}
+	}
+}
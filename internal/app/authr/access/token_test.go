@@ -0,0 +1,115 @@
+package access
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	bootstraputils "bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type signerMock struct{ mock.Mock }
+
+func (m *signerMock) Sign(claims jwt.Claims) (string, error) {
+	args := m.Called(claims)
+	return args.String(0), args.Error(1)
+}
+func (m *signerMock) JWKS() *authrlib.JWKS { return nil }
+
+func TestTokenHandle(t *testing.T) {
+	now := time.Unix(1570000000, 0)
+	access := &authorization.Access{SuperUser: true}
+	claims := &accessClaims{
+		StandardClaims: jwt.StandardClaims{Subject: "108", Issuer: "authr", Audience: "portal", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()},
+		Access:         access,
+	}
+	testCases := []struct {
+		name    string
+		userID  string
+		service Service
+		signer  authrlib.TokenSigner
+		status  int
+		body    string
+	}{
+		{name: "not configured", userID: "108", service: &serviceMock{}, status: http.StatusNotImplemented},
+		{name: "incorrect user id", userID: "108a", service: &serviceMock{}, signer: &signerMock{}, status: http.StatusBadRequest},
+		{name: "user not found", userID: "108", signer: &signerMock{}, status: http.StatusNotFound,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("Access", 108).Return(nil, errNotFound).Once()
+				return m
+			}()},
+		{name: "sign error", userID: "108", status: http.StatusInternalServerError,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("Access", 108).Return(access, nil).Once()
+				return m
+			}(),
+			signer: func() authrlib.TokenSigner {
+				m := &signerMock{}
+				m.On("Sign", claims).Return("", errors.New("sign failed")).Once()
+				return m
+			}()},
+		{name: "success", userID: "108", status: http.StatusOK,
+			body: `{"token":"signed.jwt.token","tokenType":"Bearer","expiresIn":60}`,
+			service: func() Service {
+				m := &serviceMock{}
+				m.On("Access", 108).Return(access, nil).Once()
+				return m
+			}(),
+			signer: func() authrlib.TokenSigner {
+				m := &signerMock{}
+				m.On("Sign", claims).Return("signed.jwt.token", nil).Once()
+				return m
+			}()},
+	}
+
+	for _, testCase := range testCases {
+		var buf bytes.Buffer
+		config := &authrlib.Config{App: authrlib.AppConfig{Token: authrlib.TokenConfig{Issuer: "authr", Audience: "portal", TTL: time.Minute}}}
+		mockConfigService := &configServiceMock{}
+		mockConfigService.On("Config").Return(config)
+		ctx := &authrlib.AppContext{Logger: &authrlib.AppLogger{Logger: zerolog.New(&buf)}, ConfigService: mockConfigService}
+		handlerFunc := (&tokenHandler{ctx: ctx, service: testCase.service, signer: testCase.signer,
+			now: func() time.Time { return now }}).handlerFunc()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/test/"+testCase.userID+"/token", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams = chi.RouteParams{Keys: []string{"userID"}, Values: []string{testCase.userID}}
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		txn := (*newrelicApp()).StartTransaction("/test/token", w, r)
+		r = r.WithContext(context.WithValue(r.Context(), bootstraputils.ContextKey("txn"), txn))
+
+		handlerFunc.ServeHTTP(w, r)
+
+		assert.Equal(t, testCase.status, w.Code, testCase.name)
+		if testCase.body != "" {
+			assert.Contains(t, w.Body.String(), testCase.body, testCase.name)
+		}
+		testCase.service.(*serviceMock).AssertExpectations(t)
+		if signer, ok := testCase.signer.(*signerMock); ok {
+			signer.AssertExpectations(t)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestNewTokenHandler(t *testing.T) {
+	mockConfigService := &configServiceMock{}
+	mockConfigService.On("Config").Return(&authrlib.Config{}).Once()
+	handler := NewTokenHandler(&authrlib.AppContext{ConfigService: mockConfigService}, &serviceMock{})
+	assert.NotNil(t, handler)
+	mockConfigService.AssertExpectations(t)
+}
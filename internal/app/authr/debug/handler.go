@@ -0,0 +1,56 @@
+package debug
+
+import (
+	"database/sql"
+	"net/http"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"github.com/gamegos/jsend"
+)
+
+// connection pool statistics reported to on-call engineers
+type dbStats struct {
+	MaxOpenConnections int    `json:"maxOpenConnections"`
+	OpenConnections    int    `json:"openConnections"`
+	InUse              int    `json:"inUse"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"waitCount"`
+	WaitDuration       string `json:"waitDuration"`
+	MaxIdleClosed      int64  `json:"maxIdleClosed"`
+	MaxLifetimeClosed  int64  `json:"maxLifetimeClosed"`
+}
+
+// NewDBStatsHandler creates a handler which reports statistics of db connection pools per node
+func NewDBStatsHandler(ctx *authrlib.AppContext) http.HandlerFunc {
+	return (&dbStatsHandler{ctx}).handlerFunc()
+}
+
+// struct which produces http.HandlerFunc
+type dbStatsHandler struct {
+	ctx *authrlib.AppContext
+}
+
+func (h *dbStatsHandler) handlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var pools map[string]sql.DBStats
+		if h.ctx.DbManager != nil { // there is no db in fixture mode
+			pools = h.ctx.DbManager.Stats()
+		}
+		data := make(map[string]*dbStats, len(pools))
+		for node, stats := range pools {
+			data[node] = &dbStats{
+				MaxOpenConnections: stats.MaxOpenConnections,
+				OpenConnections:    stats.OpenConnections,
+				InUse:              stats.InUse,
+				Idle:               stats.Idle,
+				WaitCount:          stats.WaitCount,
+				WaitDuration:       stats.WaitDuration.String(),
+				MaxIdleClosed:      stats.MaxIdleClosed,
+				MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+			}
+		}
+		if _, err := jsend.Wrap(w).Message("request completed").Data(data).Status(http.StatusOK).Send(); err != nil {
+			h.ctx.Logger.HandlerLogger(r).Warn().Err(err).Msg("unable to reply success")
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package debug
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type dbManagerMock struct{ db *sql.DB }
+
+func (m *dbManagerMock) Db() *sql.DB                                     { return m.db }
+func (m *dbManagerMock) Read(context.Context, func(*sql.DB) error) error { return nil }
+func (m *dbManagerMock) Release()                                        {}
+func (m *dbManagerMock) Stats() map[string]sql.DBStats {
+	return map[string]sql.DBStats{"primary": m.db.Stats(), "replica db-2:1433": {}}
+}
+
+func TestDBStatsHandler(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create db mock: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(20)
+
+	ctx := &authrlib.AppContext{DbManager: &dbManagerMock{db}}
+	w := httptest.NewRecorder()
+	NewDBStatsHandler(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/debug/db", nil))
+	assert.Equal(t, 200, w.Code)
+	body := strings.TrimSpace(w.Body.String())
+	assert.Contains(t, body, `"primary":{`)
+	assert.Contains(t, body, `"replica db-2:1433":{`)
+	assert.Contains(t, body, `"maxOpenConnections":20`)
+	assert.Contains(t, body, `"inUse":0`)
+	assert.Contains(t, body, `"waitCount":0`)
+	assert.Contains(t, body, `"waitDuration":"0s"`)
+
+	// fixture db mode has no db
+	ctx.DbManager = nil
+	w = httptest.NewRecorder()
+	NewDBStatsHandler(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/debug/db", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"data":{}`)
+}
@@ -0,0 +1,33 @@
+package keys
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+)
+
+// NewJWKSHandler creates a handler which publishes public keys of the service as JWKS document
+func NewJWKSHandler(ctx *authrlib.AppContext) http.HandlerFunc {
+	return (&jwksHandler{ctx}).handlerFunc()
+}
+
+// struct which produces http.HandlerFunc
+type jwksHandler struct {
+	ctx *authrlib.AppContext
+}
+
+// JWKS document is not wrapped by jsend, consumers expect plain RFC 7517 format
+func (h *jwksHandler) handlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jwks := &authrlib.JWKS{Keys: []authrlib.JWK{}}
+		if h.ctx.TokenSigner != nil {
+			jwks = h.ctx.TokenSigner.JWKS()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := json.NewEncoder(w).Encode(jwks); err != nil {
+			h.ctx.Logger.HandlerLogger(r).Warn().Err(err).Msg("unable to reply jwks")
+		}
+	}
+}
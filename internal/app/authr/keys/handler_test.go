@@ -0,0 +1,32 @@
+package keys
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type signerMock struct{ jwks *authrlib.JWKS }
+
+func (*signerMock) Sign(jwt.Claims) (string, error) { return "", nil }
+func (m *signerMock) JWKS() *authrlib.JWKS          { return m.jwks }
+
+func TestJWKSHandler(t *testing.T) {
+	// token issuing is not configured
+	ctx := &authrlib.AppContext{}
+	w := httptest.NewRecorder()
+	NewJWKSHandler(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `{"keys":[]}`, strings.TrimSpace(w.Body.String()))
+
+	// published keys
+	ctx.TokenSigner = &signerMock{&authrlib.JWKS{Keys: []authrlib.JWK{{Kty: "RSA", Kid: "kid-1", Use: "sig", Alg: "RS256", N: "q83v", E: "AQAB"}}}}
+	w = httptest.NewRecorder()
+	NewJWKSHandler(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"keys":[{"kty":"RSA","kid":"kid-1","use":"sig","alg":"RS256","n":"q83v","e":"AQAB"}]}`, strings.TrimSpace(w.Body.String()))
+}
@@ -0,0 +1,83 @@
+package probes
+
+import (
+	"net/http"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"github.com/gamegos/jsend"
+)
+
+// statuses of readiness
+const (
+	StatusReady    = "ready"
+	StatusDegraded = "degraded"
+	StatusNotReady = "not ready"
+)
+
+// liveness of the process
+type liveness struct {
+	StartedAt time.Time `json:"startedAt"`
+	UptimeSec float64   `json:"uptimeSec"`
+}
+
+// readiness with results of dependency checks
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks []authrlib.CheckResult `json:"checks"`
+}
+
+// NewLivenessHandler creates a handler which reports that process is able to serve requests,
+// dependencies aren't checked, so their outages don't restart the service
+func NewLivenessHandler(ctx *authrlib.AppContext) http.HandlerFunc {
+	return (&livenessHandler{ctx: ctx, startedAt: time.Now()}).handlerFunc()
+}
+
+// struct which produces http.HandlerFunc
+type livenessHandler struct {
+	ctx       *authrlib.AppContext
+	startedAt time.Time
+}
+
+func (h *livenessHandler) handlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := &liveness{StartedAt: h.startedAt.UTC(), UptimeSec: time.Since(h.startedAt).Seconds()}
+		if _, err := jsend.Wrap(w).Message("alive").Data(data).Status(http.StatusOK).Send(); err != nil {
+			h.ctx.Logger.HandlerLogger(r).Warn().Err(err).Msg("unable to reply liveness")
+		}
+	}
+}
+
+// NewReadinessHandler creates a handler which reports results of dependency checks, service is not ready
+// if a critical check fails and degraded if a non-critical one fails
+func NewReadinessHandler(ctx *authrlib.AppContext) http.HandlerFunc {
+	return (&readinessHandler{ctx}).handlerFunc()
+}
+
+// struct which produces http.HandlerFunc
+type readinessHandler struct {
+	ctx *authrlib.AppContext
+}
+
+func (h *readinessHandler) handlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := &readiness{Status: StatusReady, Checks: h.ctx.Probes.Results()}
+		for _, result := range data.Checks {
+			if result.Healthy {
+				continue
+			}
+			if result.Critical {
+				data.Status = StatusNotReady
+				break
+			}
+			data.Status = StatusDegraded
+		}
+		status := http.StatusOK
+		if data.Status == StatusNotReady {
+			status = http.StatusServiceUnavailable
+		}
+		if _, err := jsend.Wrap(w).Message(data.Status).Data(data).Status(status).Send(); err != nil {
+			h.ctx.Logger.HandlerLogger(r).Warn().Err(err).Msg("unable to reply readiness")
+		}
+	}
+}
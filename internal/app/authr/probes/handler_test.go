@@ -0,0 +1,67 @@
+package probes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type probesMock struct{ mock.Mock }
+
+func (m *probesMock) AddCheck(name string, critical bool, check func() error)      {}
+func (m *probesMock) AddStateCheck(name string, critical bool, check func() error) {}
+func (m *probesMock) Results() []authrlib.CheckResult {
+	return m.Called().Get(0).([]authrlib.CheckResult)
+}
+
+func TestLivenessHandler(t *testing.T) {
+	ctx := &authrlib.AppContext{}
+	w := httptest.NewRecorder()
+	NewLivenessHandler(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/health/live", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"message":"alive"`)
+	assert.Contains(t, w.Body.String(), `"uptimeSec":`)
+}
+
+func TestReadinessHandler(t *testing.T) {
+	checkedAt := time.Date(2019, 12, 1, 10, 0, 0, 0, time.UTC)
+	db := authrlib.CheckResult{Name: "CCNet", Critical: true, Healthy: true, LatencyMs: 1.5, CheckedAt: checkedAt}
+	failedDB := authrlib.CheckResult{Name: "CCNet", Critical: true, Error: "connection refused", LatencyMs: 2,
+		CheckedAt: checkedAt, LastError: "connection refused", LastErrorAt: &checkedAt}
+	keys := authrlib.CheckResult{Name: "KeysServer", Healthy: true, CheckedAt: checkedAt}
+	failedKeys := authrlib.CheckResult{Name: "KeysServer", Error: "timeout", CheckedAt: checkedAt}
+
+	testCases := []struct {
+		name    string
+		results []authrlib.CheckResult
+		status  int
+		body    string
+	}{
+		{name: "ready", results: []authrlib.CheckResult{db, keys}, status: http.StatusOK,
+			body: `"message":"ready","status":"success"`},
+		{name: "no checks", results: []authrlib.CheckResult{}, status: http.StatusOK,
+			body: `{"data":{"status":"ready","checks":[]}`},
+		{name: "non-critical check failed", results: []authrlib.CheckResult{db, failedKeys}, status: http.StatusOK,
+			body: `"message":"degraded","status":"success"`},
+		{name: "critical check failed", results: []authrlib.CheckResult{failedDB, failedKeys}, status: http.StatusServiceUnavailable,
+			body: `{"name":"CCNet","critical":true,"healthy":false,"error":"connection refused","latencyMs":2,` +
+				`"checkedAt":"2019-12-01T10:00:00Z","lastError":"connection refused","lastErrorAt":"2019-12-01T10:00:00Z"}`},
+	}
+
+	for _, testCase := range testCases {
+		probes := &probesMock{}
+		probes.On("Results").Return(testCase.results).Once()
+		ctx := &authrlib.AppContext{Probes: probes}
+		w := httptest.NewRecorder()
+		NewReadinessHandler(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+		assert.Equal(t, testCase.status, w.Code, testCase.name)
+		assert.Contains(t, w.Body.String(), testCase.body, testCase.name)
+		probes.AssertExpectations(t)
+		t.Log("test case ok:", testCase.name)
+	}
+}
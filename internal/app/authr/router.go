@@ -42,16 +42,41 @@ func CreateRouter(ctx *authrlib.AppContext) http.Handler {
 	// This is a JSON API, thus set that content type for everything
 	r.Use(render.SetContentType(render.ContentTypeJSON))
 
+	// access lookups are audited before token validation, so rejected calls are recorded too
+	audit := func(action string) func(next http.Handler) http.Handler {
+		return authrlib.AuditMiddleware(ctx.AuditSink, action)
+	}
+
 	// access handler with middleware
 	r.Route("/access", func(r chi.Router) {
-		r.With(ctx.AccessValidationMiddlewares...).Route("/{userID:^[0-9]+$}", func(r chi.Router) {
-			r.Get("/", ctx.AccessHandler)
+		r.With(audit(authrlib.AuditBatchLookup)).With(ctx.BatchAccessValidationMiddlewares...).
+			Post("/batch", ctx.BatchAccessHandler)
+		r.Route("/{userID:^[0-9]+$}", func(r chi.Router) {
+			// admins may read access of users within their entities
+			r.With(audit(authrlib.AuditAccessLookup)).With(ctx.DelegatedAccessValidationMiddlewares...).
+				Get("/", ctx.AccessHandler)
+			r.With(audit(authrlib.AuditPermissionCheck)).With(ctx.DelegatedAccessValidationMiddlewares...).
+				Post("/check", ctx.AccessCheckHandler)
+			r.With(audit(authrlib.AuditTokenIssue)).With(ctx.AccessValidationMiddlewares...).
+				Post("/token", ctx.TokenHandler)
+			r.With(audit(authrlib.AuditHistoryLookup)).With(ctx.DelegatedAccessValidationMiddlewares...).
+				Get("/history", ctx.AccessHistoryHandler)
+			r.With(ctx.CacheInvalidationValidationMiddlewares...).Delete("/cache", ctx.CacheInvalidationHandler)
 		})
 	})
 
+	// public keys which verify tokens issued by /access/{userID}/token
+	r.Get("/.well-known/jwks.json", ctx.JWKSHandler)
+
+	// internal statistics of db connection pool for on-call engineers, node names reveal db hosts
+	r.With(ctx.DebugValidationMiddlewares...).Get("/debug/db", ctx.DBStatsHandler)
+
 	// /health aggregates the status of a collection of health checks,
 	// and reports back to the nagging ELB.
 	r.Get("/health", health.GetServiceHealth(ctx.Healthchecks, appConfig.Name))
+	// kubernetes probes, liveness doesn't depend on db, so its outage doesn't restart pods
+	r.Get("/health/live", ctx.LivenessHandler)
+	r.Get("/health/ready", ctx.ReadinessHandler)
 
 	return r
 }
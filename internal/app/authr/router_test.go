@@ -1,6 +1,8 @@
 package authr
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/mock"
@@ -13,8 +15,10 @@ import (
 
 type mockConfigService struct{ mock.Mock }
 
-func (m *mockConfigService) Config() *authrlib.Config { return m.Called().Get(0).(*authrlib.Config) }
-func (m *mockConfigService) IsProduction() bool       { return m.Called().Bool(0) }
+func (m *mockConfigService) Config() *authrlib.Config          { return m.Called().Get(0).(*authrlib.Config) }
+func (m *mockConfigService) IsProduction() bool                { return m.Called().Bool(0) }
+func (m *mockConfigService) Reload() error                     { return m.Called().Error(0) }
+func (m *mockConfigService) Subscribe(authrlib.ConfigListener) {}
 
 func TestCreateRouter(t *testing.T) {
 	mockConfigSvc := &mockConfigService{}
@@ -28,7 +32,23 @@ func TestCreateRouter(t *testing.T) {
 	assert.NotNil(t, handler)
 	mux := handler.(*chi.Mux)
 	assert.Equal(t, 8, len(mux.Middlewares()))
-	assert.Equal(t, 2, len(mux.Routes())) // /access and /health
+	assert.Equal(t, 6, len(mux.Routes())) // /access, /.well-known/jwks.json, /debug/db, /health, /health/live and /health/ready
 	mockConfigSvc.AssertExpectations(t)
 
 }
+
+func TestDebugRouteIsValidated(t *testing.T) {
+	mockConfigSvc := &mockConfigService{}
+	mockConfigSvc.On("Config").Return(&authrlib.Config{})
+	ctx := &authrlib.AppContext{ConfigService: mockConfigSvc, Logger: authrlib.NewLogger(false)}
+	var err error
+	ctx.NewRelicService, err = authrlib.CreateNewRelicService(ctx)
+	assert.Nil(t, err)
+	ctx.DBStatsHandler = func(w http.ResponseWriter, r *http.Request) { t.Error("db stats served without token") }
+	ctx.DebugValidationMiddlewares = []func(next http.Handler) http.Handler{func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusUnauthorized) })
+	}}
+	w := httptest.NewRecorder()
+	CreateRouter(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/debug/db", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
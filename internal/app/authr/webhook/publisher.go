@@ -0,0 +1,348 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+)
+
+// AccessChanged is a type of event published when resolved access of a user differs from its previous version
+const AccessChanged = "access.changed"
+
+// headers of delivery requests
+const (
+	HeaderEvent     = "X-Authr-Event"
+	HeaderDelivery  = "X-Authr-Delivery"
+	HeaderTimestamp = "X-Authr-Timestamp"
+	HeaderSignature = "X-Authr-Signature"
+)
+
+// defaults of delivery
+const (
+	defaultWorkers     = 2
+	defaultQueueSize   = 1000
+	defaultTimeout     = 5 * time.Second
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = time.Minute
+)
+
+var (
+	errClosed    = errors.New("publisher is closed")
+	errAbandoned = errors.New("publisher is closed, shutdown deadline exceeded")
+)
+
+// Event is a notification delivered to every subscription
+type Event struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	UserID     int         `json:"userId"`
+	OccurredAt time.Time   `json:"occurredAt"`
+	Data       interface{} `json:"data"`
+}
+
+// NewEvent creates event with unique ID
+func NewEvent(eventType string, userID int, data interface{}) *Event {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &Event{ID: hex.EncodeToString(id), Type: eventType, UserID: userID, OccurredAt: time.Now().UTC(), Data: data}
+}
+
+// Signature returns value of signature header, HMAC-SHA256 of timestamp and body joined by dot;
+// receivers recompute it with the secret of their subscription and reject stale timestamps
+func Signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publisher delivers events to subscriptions in background
+type Publisher interface {
+	// Publish queues event for delivery, it never blocks
+	Publish(*Event)
+	// Close stops accepting events and waits for queued deliveries, they aren't retried anymore;
+	// deliveries which aren't completed when ctx expires are aborted and dead-lettered
+	Close(ctx context.Context) error
+}
+
+// impl of Publisher
+type publisher struct {
+	subscriptions []authrlib.WebhookSubscription
+	client        *http.Client
+	logger        *authrlib.AppLogger
+	maxAttempts   int
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	now           func() time.Time
+
+	mu     sync.RWMutex // guards queue against publishing after close
+	closed bool
+	queue  chan *delivery
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	// canceled when close deadline expires, aborts posts in flight
+	abandoned context.Context
+	abandon   context.CancelFunc
+
+	deadLetterMu sync.Mutex
+	deadLetter   io.WriteCloser
+}
+
+// event to a single subscription
+type delivery struct {
+	subscription authrlib.WebhookSubscription
+	event        *Event
+	body         []byte
+}
+
+// undelivered event as it's written to dead letter file
+type deadLetterRecord struct {
+	Subscription string          `json:"subscription"`
+	URL          string          `json:"url"`
+	Event        json.RawMessage `json:"event"`
+	Attempts     int             `json:"attempts"`
+	Error        string          `json:"error"`
+	FailedAt     time.Time       `json:"failedAt"`
+}
+
+// unexpected response status of receiver
+type statusError struct {
+	status    int
+	retryable bool
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.status)
+}
+
+// NewPublisher creates publisher of configured subscriptions and starts its workers,
+// nil publisher is returned if webhooks are disabled or there are no subscriptions
+func NewPublisher(ctx *authrlib.AppContext) (Publisher, error) {
+	conf := ctx.ConfigService.Config().Webhooks
+	if !conf.Enabled || len(conf.Subscriptions) == 0 {
+		return nil, nil
+	}
+	for _, subscription := range conf.Subscriptions {
+		if u, err := url.Parse(subscription.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook subscription %s has invalid url: %s", subscription.ID, subscription.URL)
+		}
+		if subscription.Secret == "" {
+			return nil, fmt.Errorf("webhook subscription %s has no secret", subscription.ID)
+		}
+	}
+	p := &publisher{
+		subscriptions: conf.Subscriptions,
+		client:        &http.Client{Timeout: conf.Timeout},
+		logger:        ctx.Logger,
+		maxAttempts:   conf.MaxAttempts,
+		backoff:       conf.Backoff,
+		maxBackoff:    conf.MaxBackoff,
+		now:           time.Now,
+		stop:          make(chan struct{}),
+	}
+	p.abandoned, p.abandon = context.WithCancel(context.Background())
+	if p.client.Timeout <= 0 {
+		p.client.Timeout = defaultTimeout
+	}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = defaultMaxAttempts
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultBackoff
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultMaxBackoff
+	}
+	if conf.DeadLetterFile != "" {
+		if err := os.MkdirAll(filepath.Dir(conf.DeadLetterFile), 0755); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(conf.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, err
+		}
+		p.deadLetter = file
+	}
+	queueSize, workers := conf.QueueSize, conf.Workers
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	p.queue = make(chan *delivery, queueSize)
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p, nil
+}
+
+func (p *publisher) Publish(event *Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		p.logger.Error().Err(err).Str("event_id", event.ID).Msg("unable to encode webhook event")
+		return
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, subscription := range p.subscriptions {
+		d := &delivery{subscription: subscription, event: event, body: body}
+		if p.closed {
+			p.fail(d, 0, errClosed)
+			continue
+		}
+		select {
+		case p.queue <- d:
+		default:
+			p.fail(d, 0, errors.New("delivery queue is full"))
+		}
+	}
+}
+
+func (p *publisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	close(p.queue)
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// the rest of queue is dead-lettered without posting
+		p.abandon()
+		<-done
+	}
+	p.abandon()
+	p.deadLetterMu.Lock()
+	defer p.deadLetterMu.Unlock()
+	if p.deadLetter == nil {
+		return nil
+	}
+	err := p.deadLetter.Close()
+	p.deadLetter = nil
+	return err
+}
+
+func (p *publisher) work() {
+	defer p.wg.Done()
+	for d := range p.queue {
+		if p.abandoned.Err() != nil {
+			p.fail(d, 0, errAbandoned)
+			continue
+		}
+		p.deliver(d)
+	}
+}
+
+// posts event until it's accepted, attempts are exhausted or publisher is closed
+func (p *publisher) deliver(d *delivery) {
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := p.post(d)
+		if err == nil {
+			p.logger.Debug().Str("subscription", d.subscription.ID).Str("event_id", d.event.ID).Int("attempt", attempt).
+				Msg("webhook delivered")
+			return
+		}
+		if statusErr, ok := err.(*statusError); (ok && !statusErr.retryable) || attempt >= p.maxAttempts {
+			p.fail(d, attempt, err)
+			return
+		}
+		p.logger.Warn().Err(err).Str("subscription", d.subscription.ID).Str("event_id", d.event.ID).
+			Int("attempt", attempt).Dur("backoff", backoff).Msg("webhook delivery failed, retrying")
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-p.stop:
+			timer.Stop()
+			closedErr := errClosed
+			if p.abandoned.Err() != nil {
+				closedErr = errAbandoned
+			}
+			p.fail(d, attempt, fmt.Errorf("%s, last error: %v", closedErr, err))
+			return
+		}
+		if backoff *= 2; backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+func (p *publisher) post(d *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, d.subscription.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(p.abandoned)
+	timestamp := p.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.event.Type)
+	req.Header.Set(HeaderDelivery, d.event.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Signature(d.subscription.Secret, timestamp, d.body))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	// drain body so connection is reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return &statusError{
+		status:    resp.StatusCode,
+		retryable: resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests,
+	}
+}
+
+// logs undelivered event and appends it to dead letter file
+func (p *publisher) fail(d *delivery, attempts int, err error) {
+	p.logger.Error().Err(err).Str("subscription", d.subscription.ID).Str("event_id", d.event.ID).
+		Int("attempts", attempts).Msg("webhook not delivered")
+	line, _ := json.Marshal(&deadLetterRecord{
+		Subscription: d.subscription.ID,
+		URL:          d.subscription.URL,
+		Event:        d.body,
+		Attempts:     attempts,
+		Error:        err.Error(),
+		FailedAt:     p.now().UTC(),
+	})
+	p.deadLetterMu.Lock()
+	defer p.deadLetterMu.Unlock()
+	if p.deadLetter == nil {
+		return
+	}
+	if _, err = p.deadLetter.Write(append(line, '\n')); err != nil {
+		p.logger.Error().Err(err).Str("event_id", d.event.ID).Msg("unable to write webhook dead letter")
+	}
+}
@@ -0,0 +1,318 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrlib"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type configServiceMock struct{ mock.Mock }
+
+func (m *configServiceMock) Config() *authrlib.Config          { return m.Called().Get(0).(*authrlib.Config) }
+func (m *configServiceMock) IsProduction() bool                { return m.Called().Bool(0) }
+func (m *configServiceMock) Reload() error                     { return m.Called().Error(0) }
+func (m *configServiceMock) Subscribe(authrlib.ConfigListener) {}
+
+// local receiver which replies with given statuses one by one, the last status is repeated
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	status := rc.statuses[0]
+	if len(rc.statuses) > 1 {
+		rc.statuses = rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+func newTestPublisher(t *testing.T, conf authrlib.WebhooksConfig) (*publisher, *bytes.Buffer) {
+	var buf bytes.Buffer
+	config := &configServiceMock{}
+	config.On("Config").Return(&authrlib.Config{Webhooks: conf})
+	p, err := NewPublisher(&authrlib.AppContext{ConfigService: config, Logger: &authrlib.AppLogger{Logger: zerolog.New(zerolog.SyncWriter(&buf))}})
+	if err != nil {
+		t.Fatal("unable to create publisher", err)
+	}
+	return p.(*publisher), &buf
+}
+
+func readDeadLetters(t *testing.T, file string) []*deadLetterRecord {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal("unable to read dead letter file", err)
+	}
+	var records []*deadLetterRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		record := &deadLetterRecord{}
+		assert.NoError(t, json.Unmarshal([]byte(line), record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestSignature(t *testing.T) {
+	signature := Signature("secret", 1575194400, []byte(`{"id":"1"}`))
+	assert.True(t, strings.HasPrefix(signature, "sha256="))
+	assert.Len(t, signature, 71)
+	assert.Equal(t, signature, Signature("secret", 1575194400, []byte(`{"id":"1"}`)))
+	assert.NotEqual(t, signature, Signature("other", 1575194400, []byte(`{"id":"1"}`)))
+	assert.NotEqual(t, signature, Signature("secret", 1575194401, []byte(`{"id":"1"}`)))
+	assert.NotEqual(t, signature, Signature("secret", 1575194400, []byte(`{"id":"2"}`)))
+}
+
+func TestNewEvent(t *testing.T) {
+	event := NewEvent(AccessChanged, 108, map[string]int{"version": 2})
+	assert.Len(t, event.ID, 32)
+	assert.NotEqual(t, event.ID, NewEvent(AccessChanged, 108, nil).ID)
+	assert.Equal(t, AccessChanged, event.Type)
+	assert.Equal(t, 108, event.UserID)
+	assert.False(t, event.OccurredAt.IsZero())
+}
+
+func TestNewPublisher(t *testing.T) {
+	testCases := []struct {
+		name  string
+		conf  authrlib.WebhooksConfig
+		nilOK bool
+		err   string
+	}{
+		{name: "disabled", conf: authrlib.WebhooksConfig{Subscriptions: []authrlib.WebhookSubscription{{ID: "a", URL: "http://a", Secret: "s"}}},
+			nilOK: true},
+		{name: "no subscriptions", conf: authrlib.WebhooksConfig{Enabled: true}, nilOK: true},
+		{name: "invalid url", conf: authrlib.WebhooksConfig{Enabled: true,
+			Subscriptions: []authrlib.WebhookSubscription{{ID: "a", URL: "ftp://a", Secret: "s"}}},
+			err: "webhook subscription a has invalid url: ftp://a"},
+		{name: "no secret", conf: authrlib.WebhooksConfig{Enabled: true,
+			Subscriptions: []authrlib.WebhookSubscription{{ID: "a", URL: "https://a/hook"}}},
+			err: "webhook subscription a has no secret"},
+		{name: "defaults", conf: authrlib.WebhooksConfig{Enabled: true,
+			Subscriptions: []authrlib.WebhookSubscription{{ID: "a", URL: "https://a/hook", Secret: "s"}}}},
+	}
+	for _, testCase := range testCases {
+		config := &configServiceMock{}
+		config.On("Config").Return(&authrlib.Config{Webhooks: testCase.conf})
+		p, err := NewPublisher(&authrlib.AppContext{ConfigService: config})
+		if testCase.err != "" {
+			assert.EqualError(t, err, testCase.err, testCase.name)
+		} else {
+			assert.NoError(t, err, testCase.name)
+			assert.Equal(t, testCase.nilOK, p == nil, testCase.name)
+		}
+		if p != nil {
+			impl := p.(*publisher)
+			assert.Equal(t, defaultTimeout, impl.client.Timeout)
+			assert.Equal(t, defaultMaxAttempts, impl.maxAttempts)
+			assert.Equal(t, defaultQueueSize, cap(impl.queue))
+			assert.NoError(t, p.Close(context.Background()))
+			assert.NoError(t, p.Close(context.Background())) // closing twice is fine
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	testCases := []struct {
+		name     string
+		statuses []int
+		requests int
+		dead     string
+		attempts int
+	}{
+		{name: "delivered", statuses: []int{http.StatusNoContent}, requests: 1},
+		{name: "retried until delivered", statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK},
+			requests: 3},
+		{name: "attempts exhausted", statuses: []int{http.StatusBadGateway}, requests: 3, dead: "unexpected status 502", attempts: 3},
+		{name: "rejected by receiver", statuses: []int{http.StatusBadRequest}, requests: 1, dead: "unexpected status 400", attempts: 1},
+	}
+
+	for _, testCase := range testCases {
+		dir, err := ioutil.TempDir("", "webhook")
+		if err != nil {
+			t.Fatal("unable to create temp dir", err)
+		}
+		rc := &receiver{statuses: testCase.statuses}
+		server := httptest.NewServer(rc)
+		deadLetterFile := filepath.Join(dir, "dead", "letter.log")
+		p, _ := newTestPublisher(t, authrlib.WebhooksConfig{
+			Enabled:        true,
+			Subscriptions:  []authrlib.WebhookSubscription{{ID: "reports", URL: server.URL + "/hook", Secret: "secret"}},
+			MaxAttempts:    3,
+			Backoff:        time.Millisecond,
+			MaxBackoff:     2 * time.Millisecond,
+			DeadLetterFile: deadLetterFile,
+		})
+		event := NewEvent(AccessChanged, 108, map[string]int{"version": 2})
+		p.Publish(event)
+		// wait for deliveries before close, which stops retries
+		for i := 0; i < 200 && rc.count() < testCase.requests; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.NoError(t, p.Close(context.Background()), testCase.name)
+		server.Close()
+
+		assert.Equal(t, testCase.requests, rc.count(), testCase.name)
+		r, body := rc.requests[0], rc.bodies[0]
+		assert.Equal(t, "/hook", r.URL.Path, testCase.name)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"), testCase.name)
+		assert.Equal(t, AccessChanged, r.Header.Get(HeaderEvent), testCase.name)
+		assert.Equal(t, event.ID, r.Header.Get(HeaderDelivery), testCase.name)
+		timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		assert.NoError(t, err, testCase.name)
+		assert.Equal(t, Signature("secret", timestamp, body), r.Header.Get(HeaderSignature), testCase.name)
+		assert.Contains(t, string(body), `"type":"access.changed","userId":108,`, testCase.name)
+		assert.Contains(t, string(body), `"data":{"version":2}`, testCase.name)
+
+		records := readDeadLetters(t, deadLetterFile)
+		if testCase.dead == "" {
+			assert.Empty(t, records, testCase.name)
+		} else {
+			assert.Len(t, records, 1, testCase.name)
+			assert.Equal(t, "reports", records[0].Subscription, testCase.name)
+			assert.Equal(t, server.URL+"/hook", records[0].URL, testCase.name)
+			assert.Equal(t, testCase.dead, records[0].Error, testCase.name)
+			assert.Equal(t, testCase.attempts, records[0].Attempts, testCase.name)
+			assert.JSONEq(t, string(body), string(records[0].Event), testCase.name)
+		}
+		os.RemoveAll(dir)
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestPublishToEverySubscription(t *testing.T) {
+	first, second := &receiver{statuses: []int{http.StatusOK}}, &receiver{statuses: []int{http.StatusOK}}
+	firstServer, secondServer := httptest.NewServer(first), httptest.NewServer(second)
+	defer firstServer.Close()
+	defer secondServer.Close()
+	p, _ := newTestPublisher(t, authrlib.WebhooksConfig{Enabled: true, Subscriptions: []authrlib.WebhookSubscription{
+		{ID: "first", URL: firstServer.URL, Secret: "first-secret"},
+		{ID: "second", URL: secondServer.URL, Secret: "second-secret"},
+	}})
+	p.Publish(NewEvent(AccessChanged, 108, nil))
+	assert.NoError(t, p.Close(context.Background()))
+
+	assert.Equal(t, 1, first.count())
+	assert.Equal(t, 1, second.count())
+	timestamp, _ := strconv.ParseInt(second.requests[0].Header.Get(HeaderTimestamp), 10, 64)
+	assert.Equal(t, Signature("second-secret", timestamp, second.bodies[0]), second.requests[0].Header.Get(HeaderSignature))
+}
+
+func TestPublishUndelivered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal("unable to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	deadLetterFile := filepath.Join(dir, "letter.log")
+	rc := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	p, buf := newTestPublisher(t, authrlib.WebhooksConfig{
+		Enabled:        true,
+		Subscriptions:  []authrlib.WebhookSubscription{{ID: "reports", URL: server.URL, Secret: "secret"}},
+		Workers:        1,
+		QueueSize:      1,
+		Backoff:        time.Hour,
+		DeadLetterFile: deadLetterFile,
+	})
+
+	// the first event waits for retry, the second one is queued, the third one doesn't fit the queue
+	p.Publish(NewEvent(AccessChanged, 1, nil))
+	for i := 0; i < 200 && rc.count() < 1; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.Publish(NewEvent(AccessChanged, 2, nil))
+	p.Publish(NewEvent(AccessChanged, 3, nil))
+	// close interrupts backoff, the queued event is attempted once and isn't retried
+	assert.NoError(t, p.Close(context.Background()))
+	p.Publish(NewEvent(AccessChanged, 4, nil))
+
+	errs := map[int]string{}
+	for _, record := range readDeadLetters(t, deadLetterFile) {
+		var event Event
+		assert.NoError(t, json.Unmarshal(record.Event, &event))
+		errs[event.UserID] = record.Error
+	}
+	assert.Equal(t, map[int]string{
+		1: "publisher is closed, last error: unexpected status 503",
+		2: "publisher is closed, last error: unexpected status 503",
+		3: "delivery queue is full",
+	}, errs)
+	assert.Equal(t, 2, rc.count())
+	assert.Contains(t, buf.String(), `"error":"publisher is closed","subscription":"reports"`)
+}
+
+func TestCloseDeadline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal("unable to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	deadLetterFile := filepath.Join(dir, "letter.log")
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	// receiver hangs until the test ends
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	p, _ := newTestPublisher(t, authrlib.WebhooksConfig{
+		Enabled:        true,
+		Subscriptions:  []authrlib.WebhookSubscription{{ID: "reports", URL: server.URL, Secret: "secret"}},
+		Workers:        1,
+		Timeout:        time.Minute,
+		Backoff:        time.Hour,
+		DeadLetterFile: deadLetterFile,
+	})
+
+	// the first event is in flight, the rest are queued
+	p.Publish(NewEvent(AccessChanged, 1, nil))
+	<-started
+	p.Publish(NewEvent(AccessChanged, 2, nil))
+	p.Publish(NewEvent(AccessChanged, 3, nil))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, p.Close(ctx))
+
+	attempts := map[int]int{}
+	for _, record := range readDeadLetters(t, deadLetterFile) {
+		var event Event
+		assert.NoError(t, json.Unmarshal(record.Event, &event))
+		assert.Contains(t, record.Error, errAbandoned.Error())
+		attempts[event.UserID] = record.Attempts
+	}
+	assert.Equal(t, map[int]int{1: 1, 2: 0, 3: 0}, attempts)
+}
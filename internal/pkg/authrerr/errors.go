@@ -0,0 +1,125 @@
+// Package authrerr defines errors with stable machine-readable codes shared by
+// middlewares and handlers; clients get code and sanitized message, wrapped
+// causes are kept for logs only
+package authrerr
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gamegos/jsend"
+)
+
+// Code is a stable machine-readable error code, values must never change
+type Code string
+
+// error codes returned to clients
+const (
+	UserNotFound         Code = "USER_NOT_FOUND"
+	UserTypeNotAllowed   Code = "USER_TYPE_NOT_ALLOWED"
+	InvalidRequest       Code = "INVALID_REQUEST"
+	RequestTooLarge      Code = "REQUEST_TOO_LARGE"
+	TokenMissing         Code = "TOKEN_MISSING"
+	TokenInvalid         Code = "TOKEN_INVALID"
+	TokenSubMissing      Code = "TOKEN_SUB_MISSING"
+	TokenSubMismatch     Code = "TOKEN_SUB_MISMATCH"
+	ScopeNotGranted      Code = "SCOPE_NOT_GRANTED"
+	CallerNotAdmin       Code = "CALLER_NOT_ADMIN"
+	UserOutsideEntities  Code = "USER_OUTSIDE_ENTITIES"
+	HierarchyTooLarge    Code = "HIERARCHY_TOO_LARGE"
+	TokenIssuingDisabled Code = "TOKEN_ISSUING_DISABLED"
+	HistoryDisabled      Code = "HISTORY_DISABLED"
+	DBUnavailable        Code = "DB_UNAVAILABLE"
+	DBTimeout            Code = "DB_TIMEOUT"
+	RequestCancelled     Code = "REQUEST_CANCELLED"
+	Internal             Code = "INTERNAL"
+)
+
+// StatusClientClosedRequest is a non-standard status of requests cancelled by client, it's only logged
+const StatusClientClosedRequest = 499
+
+// http statuses of codes, unknown codes are internal errors
+var statuses = map[Code]int{
+	UserNotFound:         http.StatusNotFound,
+	UserTypeNotAllowed:   http.StatusForbidden,
+	InvalidRequest:       http.StatusBadRequest,
+	RequestTooLarge:      http.StatusRequestEntityTooLarge,
+	TokenMissing:         http.StatusUnauthorized,
+	TokenInvalid:         http.StatusUnauthorized,
+	TokenSubMissing:      http.StatusUnauthorized,
+	TokenSubMismatch:     http.StatusForbidden,
+	ScopeNotGranted:      http.StatusForbidden,
+	CallerNotAdmin:       http.StatusForbidden,
+	UserOutsideEntities:  http.StatusForbidden,
+	HierarchyTooLarge:    http.StatusInternalServerError,
+	TokenIssuingDisabled: http.StatusNotImplemented,
+	HistoryDisabled:      http.StatusNotImplemented,
+	DBUnavailable:        http.StatusServiceUnavailable,
+	DBTimeout:            http.StatusGatewayTimeout,
+	RequestCancelled:     StatusClientClosedRequest,
+	Internal:             http.StatusInternalServerError,
+}
+
+// Status returns http status of the code
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error with a code and a message safe to send to clients
+type Error struct {
+	Code    Code
+	Message string
+	// internal cause, never sent to clients
+	Err error
+}
+
+// New creates an error without cause, usually a sentinel
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap creates an error with internal cause
+func Wrap(err error, code Code, message string) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+// Unwrap returns internal cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches errors by code, so errors.Is(err, sentinel) holds for wrapped sentinels too
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// From finds *Error in the chain of err, any other error is an internal one
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Wrap(err, Internal, "internal server error")
+}
+
+// Status returns http status of err
+func Status(err error) int {
+	return From(err).Code.Status()
+}
+
+// Reply sends jsend reply with status, code and sanitized message of err
+func Reply(w http.ResponseWriter, err error) (int, error) {
+	e := From(err)
+	return jsend.Wrap(w).Message(e.Message).Data(map[string]Code{"code": e.Code}).Status(e.Code.Status()).Send()
+}
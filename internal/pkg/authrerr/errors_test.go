@@ -0,0 +1,80 @@
+package authrerr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError(t *testing.T) {
+	sentinel := New(UserNotFound, "user not found")
+	cause := errors.New("connection refused")
+	wrapped := Wrap(cause, DBUnavailable, "access data is temporarily unavailable")
+
+	assert.Equal(t, "user not found", sentinel.Error())
+	assert.Equal(t, "access data is temporarily unavailable: connection refused", wrapped.Error())
+
+	// sentinels are matched by code through wrapping
+	assert.True(t, errors.Is(fmt.Errorf("lookup failed: %w", sentinel), sentinel))
+	assert.True(t, errors.Is(Wrap(cause, UserNotFound, "user 42 not found"), sentinel))
+	assert.False(t, errors.Is(wrapped, sentinel))
+	assert.True(t, errors.Is(wrapped, cause))
+
+	var e *Error
+	assert.True(t, errors.As(fmt.Errorf("lookup failed: %w", wrapped), &e))
+	assert.Equal(t, DBUnavailable, e.Code)
+}
+
+func TestStatus(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "not found", err: New(UserNotFound, "user not found"), status: http.StatusNotFound},
+		{name: "wrapped", err: fmt.Errorf("lookup failed: %w", New(TokenSubMismatch, "sub mismatch")), status: http.StatusForbidden},
+		{name: "db", err: Wrap(errors.New("timeout"), DBUnavailable, "unavailable"), status: http.StatusServiceUnavailable},
+		{name: "db timeout", err: Wrap(errors.New("deadline"), DBTimeout, "timed out"), status: http.StatusGatewayTimeout},
+		{name: "request cancelled", err: Wrap(errors.New("canceled"), RequestCancelled, "cancelled"), status: StatusClientClosedRequest},
+		{name: "history disabled", err: New(HistoryDisabled, "disabled"), status: http.StatusNotImplemented},
+		{name: "unknown code", err: New(Code("UNKNOWN"), "unknown"), status: http.StatusInternalServerError},
+		{name: "plain error", err: errors.New("boom"), status: http.StatusInternalServerError},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.status, Status(testCase.err), testCase.name)
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestReply(t *testing.T) {
+	testCases := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{name: "sentinel", err: New(UserNotFound, "user not found"), status: http.StatusNotFound,
+			code: `"code":"USER_NOT_FOUND"`, message: `"message":"user not found"`},
+		{name: "cause is not sent", err: Wrap(errors.New("login failed for user sa"), DBUnavailable, "access data is temporarily unavailable"),
+			status: http.StatusServiceUnavailable, code: `"code":"DB_UNAVAILABLE"`, message: `"message":"access data is temporarily unavailable"`},
+		{name: "plain error is sanitized", err: errors.New("login failed for user sa"), status: http.StatusInternalServerError,
+			code: `"code":"INTERNAL"`, message: `"message":"internal server error"`},
+	}
+
+	for _, testCase := range testCases {
+		w := httptest.NewRecorder()
+		_, err := Reply(w, testCase.err)
+		assert.NoError(t, err, testCase.name)
+		assert.Equal(t, testCase.status, w.Code, testCase.name)
+		assert.Contains(t, w.Body.String(), testCase.code, testCase.name)
+		assert.Contains(t, w.Body.String(), testCase.message, testCase.name)
+		assert.NotContains(t, w.Body.String(), "login failed", testCase.name)
+		t.Log("test case ok:", testCase.name)
+	}
+}
@@ -0,0 +1,343 @@
+package authrlib
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// audited actions
+const (
+	AuditAccessLookup    = "access.lookup"
+	AuditBatchLookup     = "access.batch"
+	AuditPermissionCheck = "access.check"
+	AuditTokenIssue      = "access.token"
+	AuditHistoryLookup   = "access.history"
+)
+
+// decisions of audited requests
+const (
+	AuditAllow = "allow"
+	AuditDeny  = "deny"
+	AuditError = "error"
+)
+
+// default rotation of audit file
+const (
+	defaultAuditMaxSize    = 100 // megabytes
+	defaultAuditMaxBackups = 5
+	// failed rotation is retried after the interval, events are appended to current file meanwhile
+	auditRotateRetryInterval = time.Minute
+)
+
+// opens audit file, replaced in tests
+var openAuditFile = os.OpenFile
+
+type auditContextKey struct{}
+
+// AuditEvent describes a single access lookup or permission check, it's filled in by
+// middlewares and handlers of the request and emitted once the request is served
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	RequestID string    `json:"requestId,omitempty"`
+	// caller token claims
+	Sub      string `json:"sub,omitempty"`
+	ClientID string `json:"clientId,omitempty"`
+	Rule     string `json:"rule,omitempty"`
+	// users whose access is looked up
+	UserIDs        []int  `json:"userIds,omitempty"`
+	ResourceType   string `json:"resourceType,omitempty"`
+	ResourceID     int64  `json:"resourceId,omitempty"`
+	ResourceAction string `json:"resourceAction,omitempty"`
+	Role           string `json:"role,omitempty"`
+	// AuditAllow, AuditDeny or AuditError, derived from status unless set by handler
+	Decision  string  `json:"decision"`
+	Status    int     `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	// IDs of children are redacted by the sink
+	ChildIDs []int64 `json:"-"`
+}
+
+// AuditSink writes audit events
+type AuditSink interface {
+	Emit(*AuditEvent)
+	Close() error
+}
+
+// AuditEventFrom returns audit event of the request, nil if the request isn't audited
+func AuditEventFrom(ctx context.Context) *AuditEvent {
+	event, _ := ctx.Value(auditContextKey{}).(*AuditEvent)
+	return event
+}
+
+// WithAuditEvent returns copy of context which carries audit event of the request
+func WithAuditEvent(ctx context.Context, event *AuditEvent) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, event)
+}
+
+// AuditMiddleware emits audit event of the action for every request,
+// requests pass through if sink is nil
+func AuditMiddleware(sink AuditSink, action string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if sink == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			event := &AuditEvent{Time: start.UTC(), Action: action, RequestID: middleware.GetReqID(r.Context())}
+			if userID, err := strconv.Atoi(chi.URLParam(r, "userID")); err == nil {
+				event.UserIDs = []int{userID}
+			}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(WithAuditEvent(r.Context(), event)))
+
+			event.Status = ww.Status()
+			if event.Status == 0 {
+				event.Status = http.StatusOK
+			}
+			event.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+			if event.Decision == "" {
+				event.Decision = auditDecision(event.Status)
+			}
+			sink.Emit(event)
+		})
+	}
+}
+
+func auditDecision(status int) string {
+	switch {
+	case status < http.StatusBadRequest:
+		return AuditAllow
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return AuditDeny
+	default:
+		return AuditError
+	}
+}
+
+// NewAuditSink creates sink configured in audit section, nil sink is returned if audit is disabled
+func NewAuditSink(ctx *AppContext) (AuditSink, error) {
+	conf := ctx.ConfigService.Config().Audit
+	if !conf.Enabled {
+		return nil, nil
+	}
+	redact, err := newChildIDRedactor(conf.ChildIDs, conf.HashKey)
+	if err != nil {
+		return nil, err
+	}
+	sink := &jsonAuditSink{redact: redact, logger: ctx.Logger}
+	switch conf.Output {
+	case "", AuditOutputStdout:
+		sink.out = nopCloser{os.Stdout}
+	case AuditOutputFile:
+		file, err := newRotatingFile(conf.File, ctx.Logger)
+		if err != nil {
+			return nil, err
+		}
+		sink.out = file
+		// events are still written when rotation fails, but the file grows over max size
+		ctx.Probes.AddStateCheck("Audit", false, sink.rotationError)
+	default:
+		return nil, fmt.Errorf("unsupported audit output: %s", conf.Output)
+	}
+	return sink, nil
+}
+
+// impl of AuditSink writing an event per line
+type jsonAuditSink struct {
+	mu     sync.Mutex
+	out    io.WriteCloser
+	redact func([]int64) []string
+	logger *AppLogger
+}
+
+// event as it's written, child IDs are redacted
+type auditRecord struct {
+	*AuditEvent
+	ChildIDs   []string `json:"childIds,omitempty"`
+	ChildCount int      `json:"childCount,omitempty"`
+}
+
+func (s *jsonAuditSink) Emit(event *AuditEvent) {
+	line, err := json.Marshal(&auditRecord{
+		AuditEvent: event,
+		ChildIDs:   s.redact(event.ChildIDs),
+		ChildCount: len(event.ChildIDs),
+	})
+	if err == nil {
+		s.mu.Lock()
+		_, err = s.out.Write(append(line, '\n'))
+		s.mu.Unlock()
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("action", event.Action).Str("rid", event.RequestID).
+			Msg("unable to write audit event")
+	}
+}
+
+// the last rotation failure of audit file, nil if it's rotated or isn't a file
+func (s *jsonAuditSink) rotationError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if file, ok := s.out.(*rotatingFile); ok {
+		return file.failed
+	}
+	return nil
+}
+
+func (s *jsonAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.out.Close()
+}
+
+// returns function which redacts child IDs according to policy
+func newChildIDRedactor(policy, hashKey string) (func([]int64) []string, error) {
+	switch policy {
+	case "", AuditRedactOmit:
+		return func([]int64) []string { return nil }, nil
+	case AuditRedactNone:
+		return func(ids []int64) []string {
+			return mapIDs(ids, func(id int64) string { return strconv.FormatInt(id, 10) })
+		}, nil
+	case AuditRedactHash:
+		// plain hash of a number is reversed by brute force
+		if hashKey == "" {
+			return nil, fmt.Errorf("audit hash-key is required by %s redaction", AuditRedactHash)
+		}
+		return func(ids []int64) []string {
+			return mapIDs(ids, func(id int64) string {
+				mac := hmac.New(sha256.New, []byte(hashKey))
+				mac.Write([]byte(strconv.FormatInt(id, 10)))
+				return hex.EncodeToString(mac.Sum(nil))[:16]
+			})
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported child IDs redaction: %s", policy)
+	}
+}
+
+func mapIDs(ids []int64, f func(int64) string) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	result := make([]string, len(ids))
+	for i, id := range ids {
+		result[i] = f(id)
+	}
+	return result
+}
+
+// stdout must stay open when sink is closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// file which is rotated when it exceeds max size, rotated files get suffixes .1 (the newest) to .maxBackups
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	failed     error     // the last rotation failure, nil once rotation succeeds
+	retryAt    time.Time // failed rotation isn't retried before
+	now        func() time.Time
+	logger     *AppLogger
+}
+
+func newRotatingFile(conf AuditFileConfig, logger *AppLogger) (*rotatingFile, error) {
+	if conf.Path == "" {
+		return nil, fmt.Errorf("audit file path is required by %s output", AuditOutputFile)
+	}
+	f := &rotatingFile{path: conf.Path, maxSize: int64(conf.MaxSize) << 20, maxBackups: conf.MaxBackups,
+		now: time.Now, logger: logger}
+	if conf.MaxSize <= 0 {
+		f.maxSize = defaultAuditMaxSize << 20
+	}
+	if conf.MaxBackups <= 0 {
+		f.maxBackups = defaultAuditMaxBackups
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := openAuditFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// not safe for concurrent use, sink serializes writes; failed rotation doesn't fail writes,
+// events are appended to the current file until rotation succeeds
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize && !f.now().Before(f.retryAt) {
+		f.failed = f.rotate()
+		if f.failed != nil {
+			f.retryAt = f.now().Add(auditRotateRetryInterval)
+			f.logger.Error().Err(f.failed).Str("path", f.path).Msg("unable to rotate audit file")
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// current file is closed only after the new one is opened, so writes never go to a closed file
+func (f *rotatingFile) rotate() error {
+	for i := f.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(f.backup(i), f.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// the file is already renamed if the previous rotation failed to open the new one
+	if err := os.Rename(f.path, f.backup(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	current := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	// nothing is written to the old file anymore, so rotation succeeded anyway
+	if err := current.Close(); err != nil {
+		f.logger.Warn().Err(err).Str("path", f.path).Msg("unable to close rotated audit file")
+	}
+	return nil
+}
+
+func (f *rotatingFile) backup(i int) string {
+	return f.path + "." + strconv.Itoa(i)
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,283 @@
+package authrlib
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/health"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditSinkMock struct {
+	events []*AuditEvent
+}
+
+func (m *auditSinkMock) Emit(event *AuditEvent) { m.events = append(m.events, event) }
+func (m *auditSinkMock) Close() error           { return nil }
+
+// buffer which fails writes after close
+type closableBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closableBuffer) Write(p []byte) (int, error) {
+	if b.closed {
+		return 0, errors.New("closed")
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *closableBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		handler  http.HandlerFunc
+		userIDs  []int
+		decision string
+		status   int
+	}{
+		{name: "allowed lookup", path: "/access/108", handler: func(w http.ResponseWriter, r *http.Request) {}, userIDs: []int{108},
+			decision: AuditAllow, status: http.StatusOK},
+		{name: "rejected token", path: "/access/108", userIDs: []int{108}, decision: AuditDeny, status: http.StatusForbidden,
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusForbidden) }},
+		{name: "db failure", path: "/access/108", userIDs: []int{108}, decision: AuditError, status: http.StatusServiceUnavailable,
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) }},
+		{name: "decision of handler", path: "/access/108", userIDs: []int{108}, decision: AuditDeny, status: http.StatusOK,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				AuditEventFrom(r.Context()).Decision = AuditDeny
+				w.WriteHeader(http.StatusOK)
+			}},
+		{name: "no user in path", path: "/access/batch", decision: AuditAllow, status: http.StatusOK,
+			handler: func(w http.ResponseWriter, r *http.Request) {}},
+	}
+
+	for _, testCase := range testCases {
+		sink := &auditSinkMock{}
+		r := chi.NewRouter()
+		r.Use(middleware.RequestID)
+		r.With(AuditMiddleware(sink, AuditAccessLookup)).Get("/access/{userID}", testCase.handler)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", testCase.path, nil))
+
+		assert.Len(t, sink.events, 1, testCase.name)
+		event := sink.events[0]
+		assert.Equal(t, AuditAccessLookup, event.Action, testCase.name)
+		assert.NotEmpty(t, event.RequestID, testCase.name)
+		assert.Equal(t, testCase.userIDs, event.UserIDs, testCase.name)
+		assert.Equal(t, testCase.decision, event.Decision, testCase.name)
+		assert.Equal(t, testCase.status, event.Status, testCase.name)
+		assert.False(t, event.Time.IsZero(), testCase.name)
+		t.Log("test case ok:", testCase.name)
+	}
+
+	// nil sink audits nothing
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { assert.Nil(t, AuditEventFrom(r.Context())) })
+	AuditMiddleware(nil, AuditAccessLookup)(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestNewAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal("unable to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		name   string
+		config AuditConfig
+		nilOK  bool
+		err    string
+	}{
+		{name: "disabled", nilOK: true},
+		{name: "stdout", config: AuditConfig{Enabled: true}},
+		{name: "file", config: AuditConfig{Enabled: true, Output: AuditOutputFile, File: AuditFileConfig{Path: filepath.Join(dir, "log", "audit.log")}}},
+		{name: "file without path", config: AuditConfig{Enabled: true, Output: AuditOutputFile},
+			err: "audit file path is required by file output"},
+		{name: "unknown output", config: AuditConfig{Enabled: true, Output: "kafka"}, err: "unsupported audit output: kafka"},
+		{name: "hash without key", config: AuditConfig{Enabled: true, ChildIDs: AuditRedactHash},
+			err: "audit hash-key is required by hash redaction"},
+		{name: "unknown redaction", config: AuditConfig{Enabled: true, ChildIDs: "mask"}, err: "unsupported child IDs redaction: mask"},
+	}
+	for _, testCase := range testCases {
+		ctx := &AppContext{ConfigService: &configService{config: &Config{Audit: testCase.config}},
+			Healthchecks: health.NewHealthCheckCollection()}
+		ctx.Probes = NewProbes(ctx)
+		sink, err := NewAuditSink(ctx)
+		if testCase.err != "" {
+			assert.EqualError(t, err, testCase.err, testCase.name)
+		} else {
+			assert.NoError(t, err, testCase.name)
+			assert.Equal(t, testCase.nilOK, sink == nil, testCase.name)
+			if sink != nil {
+				assert.NoError(t, sink.Close(), testCase.name)
+			}
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+	_, err = os.Stat(filepath.Join(dir, "log", "audit.log"))
+	assert.NoError(t, err)
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   string
+		childIDs []string
+	}{
+		{name: "omit", policy: AuditRedactOmit},
+		{name: "none", policy: AuditRedactNone, childIDs: []string{"7", "9"}},
+		{name: "hash", policy: AuditRedactHash, childIDs: hmacIDs("secret", "7", "9")},
+	}
+	for _, testCase := range testCases {
+		redact, err := newChildIDRedactor(testCase.policy, "secret")
+		assert.NoError(t, err, testCase.name)
+		var out closableBuffer
+		sink := &jsonAuditSink{out: &out, redact: redact, logger: &AppLogger{Logger: zerolog.Nop()}}
+		sink.Emit(&AuditEvent{Action: AuditPermissionCheck, Sub: "108", UserIDs: []int{108}, ResourceType: "child",
+			ChildIDs: []int64{7, 9}, Decision: AuditAllow, Status: http.StatusOK})
+
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &record), testCase.name)
+		assert.True(t, strings.HasSuffix(out.String(), "}\n"), testCase.name)
+		assert.Equal(t, "108", record["sub"], testCase.name)
+		assert.Equal(t, float64(2), record["childCount"], testCase.name)
+		if testCase.childIDs == nil {
+			assert.NotContains(t, record, "childIds", testCase.name)
+		} else {
+			assert.Equal(t, testCase.childIDs, toStrings(record["childIds"]), testCase.name)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+
+	// write failure is logged
+	var buf bytes.Buffer
+	out := &closableBuffer{}
+	sink := &jsonAuditSink{out: out, redact: func([]int64) []string { return nil }, logger: &AppLogger{Logger: zerolog.New(&buf)}}
+	assert.NoError(t, sink.Close())
+	sink.Emit(&AuditEvent{Action: AuditAccessLookup, RequestID: "rid-1"})
+	assert.Contains(t, buf.String(), `"error":"closed","action":"access.lookup","rid":"rid-1","message":"unable to write audit event"`)
+}
+
+func TestChildIDRedactorHash(t *testing.T) {
+	redact, _ := newChildIDRedactor(AuditRedactHash, "secret")
+	other, _ := newChildIDRedactor(AuditRedactHash, "other")
+	hashed := redact([]int64{7, 9, 7})
+	assert.Len(t, hashed[0], 16)
+	assert.Equal(t, hashed[0], hashed[2]) // stable between events
+	assert.NotEqual(t, hashed[0], hashed[1])
+	assert.NotEqual(t, hashed[0], other([]int64{7})[0])
+	assert.Nil(t, redact(nil))
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal("unable to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("0123456789\n"), 0640))
+
+	f, err := newRotatingFile(AuditFileConfig{Path: path, MaxBackups: 2}, &AppLogger{Logger: zerolog.Nop()})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), f.size) // existing file is appended
+	f.maxSize = 20
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n", "fifth\n"} {
+		_, err = f.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, f.Close())
+
+	read := func(name string) string {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		return string(data)
+	}
+	assert.Equal(t, "fifth\n", read("audit.log"))
+	assert.Equal(t, "second\nthird\nfourth\n", read("audit.log.1"))
+	assert.Equal(t, "0123456789\nfirst\n", read("audit.log.2"))
+	_, err = os.Stat(path + ".3") // the oldest backup is dropped
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotatingFileFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal("unable to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	var buf bytes.Buffer
+	f, err := newRotatingFile(AuditFileConfig{Path: path, MaxBackups: 2}, &AppLogger{Logger: zerolog.New(&buf)})
+	assert.NoError(t, err)
+	now := time.Date(2019, 12, 1, 10, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	f.maxSize = 10
+	sink := &jsonAuditSink{out: f}
+	_, err = f.Write([]byte("first\n"))
+	assert.NoError(t, err)
+
+	// new file can't be opened, events are written to the renamed one
+	openAuditFile = func(string, int, os.FileMode) (*os.File, error) { return nil, errors.New("disk failed") }
+	_, err = f.Write([]byte("second\n"))
+	openAuditFile = os.OpenFile
+	assert.NoError(t, err)
+	assert.Error(t, sink.rotationError())
+	assert.Contains(t, buf.String(), `"message":"unable to rotate audit file"`)
+
+	// rotation is retried after the interval
+	_, err = f.Write([]byte("third\n"))
+	assert.NoError(t, err)
+	assert.Error(t, sink.rotationError())
+	now = now.Add(auditRotateRetryInterval)
+	_, err = f.Write([]byte("fourth\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, sink.rotationError())
+	assert.NoError(t, f.Close())
+
+	read := func(name string) string {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		return string(data)
+	}
+	assert.Equal(t, "fourth\n", read("audit.log"))
+	assert.Equal(t, "first\nsecond\nthird\n", read("audit.log.2"))
+}
+
+func toStrings(values interface{}) []string {
+	var result []string
+	for _, value := range values.([]interface{}) {
+		result = append(result, value.(string))
+	}
+	return result
+}
+
+// expected keyed hashes of IDs
+func hmacIDs(key string, ids ...string) []string {
+	var result []string
+	for _, id := range ids {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(id))
+		result = append(result, hex.EncodeToString(mac.Sum(nil))[:16])
+	}
+	return result
+}
@@ -5,15 +5,23 @@ import (
 	"io/ioutil"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 // Config is the collection of disparate configurations needed to run authorization service
 type Config struct {
-	App      AppConfig      `yaml:"app"`
-	NewRelic NewRelicConfig `yaml:"newrelic"`
-	MsSQL    MsSQLConfig    `yaml:"mssql"`
+	App       AppConfig       `yaml:"app"`
+	NewRelic  NewRelicConfig  `yaml:"newrelic"`
+	MsSQL     MsSQLConfig     `yaml:"mssql"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Hierarchy HierarchyConfig `yaml:"hierarchy"`
+	Roles     RolesConfig     `yaml:"roles"`
+	History   HistoryConfig   `yaml:"history"`
+	Audit     AuditConfig     `yaml:"audit"`
+	Webhooks  WebhooksConfig  `yaml:"webhooks"`
 }
 
 // AppConfig is the environment specific definition of this service
@@ -23,6 +31,82 @@ type AppConfig struct {
 	Port       int    `yaml:"port"`
 	ID         string `yaml:"id"`
 	KeysServer string `yaml:"keys-server"`
+	// scope a service token must have to call batch endpoint
+	BatchScope string `yaml:"batch-scope"`
+	// client credentials tokens of these clients with ReadAnyScope may read access of any user
+	ClientIDs    []string `yaml:"client-ids"`
+	ReadAnyScope string   `yaml:"read-any-scope"`
+	// scope a service token must have to read internal diagnostics, e.g. /debug/db
+	DebugScope string `yaml:"debug-scope"`
+	// max number of user IDs in a single batch request
+	BatchLimit int             `yaml:"batch-limit"`
+	Token      TokenConfig     `yaml:"token"`
+	KeysCache  KeysCacheConfig `yaml:"keys-cache"`
+	Verify     VerifyConfig    `yaml:"verify"`
+	// one of LogLevels, empty level logs everything
+	LogLevel string `yaml:"log-level"`
+	// config file is checked for changes every interval, zero disables checks, SIGHUP reloads config anyway
+	ConfigWatchInterval time.Duration `yaml:"config-watch-interval"`
+	Server              ServerConfig  `yaml:"server"`
+	Health              HealthConfig  `yaml:"health"`
+}
+
+// HealthConfig keeps settings of readiness checks
+type HealthConfig struct {
+	// results of dependency checks are cached for the interval, so probes don't load db
+	CheckInterval time.Duration `yaml:"check-interval"`
+}
+
+// ServerConfig keeps timeouts of http server and its graceful shutdown, zero values are replaced by defaults
+type ServerConfig struct {
+	ReadTimeout  time.Duration `yaml:"read-timeout"`
+	WriteTimeout time.Duration `yaml:"write-timeout"`
+	IdleTimeout  time.Duration `yaml:"idle-timeout"`
+	// on SIGTERM or SIGINT health check fails during drain period, so load balancer stops routing requests,
+	// then in-flight requests are given shutdown timeout to complete
+	DrainPeriod     time.Duration `yaml:"drain-period"`
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout"`
+}
+
+// KeysCacheConfig keeps settings of local copy of keys server JWKS
+type KeysCacheConfig struct {
+	// keys are re-fetched when older than refresh interval
+	RefreshInterval time.Duration `yaml:"refresh-interval"`
+	// min interval between fetches, limits refreshes on unknown kid
+	MinRefreshInterval time.Duration `yaml:"min-refresh-interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+}
+
+// VerifyConfig keeps claims every bearer token must have besides signature and expiration,
+// both keys server tokens and tokens issued by the service are checked; empty lists aren't checked
+type VerifyConfig struct {
+	// token iss must be one of issuers
+	Issuers []string `yaml:"issuers"`
+	// token aud must contain one of audiences
+	Audiences []string `yaml:"audiences"`
+}
+
+// TokenConfig keeps settings of access tokens issued by the service
+type TokenConfig struct {
+	Issuer string `yaml:"issuer"`
+	// aud of issued tokens, empty value omits the claim
+	Audience string        `yaml:"audience"`
+	TTL      time.Duration `yaml:"ttl"`
+	// kid of the key used for signing, the rest of keys are only published
+	KeyID string `yaml:"key-id"`
+	// single key with KeyID as kid
+	PrivateKeyFile string `yaml:"private-key-file"`
+	// directory with PEM keys, file name without extension is used as kid
+	KeysDir string             `yaml:"keys-dir"`
+	Keys    []SigningKeyConfig `yaml:"keys"`
+}
+
+// SigningKeyConfig describes a key of the key ring
+type SigningKeyConfig struct {
+	ID             string `yaml:"id"`
+	PrivateKeyFile string `yaml:"private-key-file"`
+	// key is not published after expiration, zero value means no expiration
+	ExpiresAt time.Time `yaml:"expires-at"`
 }
 
 // PortToStr Converts port to string
@@ -34,13 +118,47 @@ func (c AppConfig) PortToStr() string {
 // newrelic.com/<insert app id here once deployed>
 type NewRelicConfig struct {
 	Enabled         bool   `yaml:"enabled"`
-	APIKey          string `yaml:"apikey"`
+	APIKey          string `yaml:"apikey" secret:"true"`
 	IgnoreHTTPCodes []int  `yaml:"ignorehttpcodes"`
 }
 
-// MsSQLConfig keeps the db connection information
+// supported db backends, values of sql ones are names of their drivers
+const (
+	BackendMSSQL    = "mssql"
+	BackendPostgres = "postgres"
+	// in-memory data loaded from FixturesFile, for local development and integration tests
+	BackendFixture = "fixture"
+)
+
+// MsSQLConfig keeps the db connection information of CCNET, whichever backend it runs on
 type MsSQLConfig struct {
-	Connection Connection `yaml:"connection"`
+	// BackendMSSQL (default), BackendPostgres or BackendFixture
+	Backend      string     `yaml:"backend"`
+	FixturesFile string     `yaml:"fixtures-file"`
+	Connection   Connection `yaml:"connection"`
+	// limit of a db lookup run time, failover from replica to primary doesn't extend it
+	QueryTimeout time.Duration `yaml:"query-timeout"`
+	Pool         PoolConfig    `yaml:"pool"`
+	// startup connectivity check, backoff doubles after every failed attempt
+	ConnectRetries int           `yaml:"connect-retries"`
+	ConnectBackoff time.Duration `yaml:"connect-backoff"`
+	// read-only secondaries, empty fields are taken from primary connection
+	Replicas []Connection   `yaml:"replicas"`
+	Failover FailoverConfig `yaml:"failover"`
+}
+
+// FailoverConfig defines when replica is skipped and how often it is re-probed
+type FailoverConfig struct {
+	// consecutive failures which mark replica unhealthy
+	MaxFailures   int           `yaml:"max-failures"`
+	ProbeInterval time.Duration `yaml:"probe-interval"`
+}
+
+// PoolConfig limits db connections, zero values keep database/sql defaults
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"max-open-conns"`
+	MaxIdleConns    int           `yaml:"max-idle-conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn-max-lifetime"`
 }
 
 type Connection struct {
@@ -48,32 +166,163 @@ type Connection struct {
 	Port     int    `yaml:"port"`
 	Database string `yaml:"database"`
 	User     string `yaml:"user"`
-	Password string `yaml:"password"`
+	Password string `yaml:"password" secret:"true"`
+	// postgres only, disable, require, verify-ca or verify-full
+	SSLMode string `yaml:"ssl-mode"`
+}
+
+// DriverName returns configured backend, which is also the name of its sql driver
+func (c MsSQLConfig) DriverName() string {
+	if c.Backend == "" {
+		return BackendMSSQL
+	}
+	return c.Backend
+}
+
+// CacheConfig keeps settings of in-process access cache
+type CacheConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+	MaxSize int           `yaml:"max-size"`
+	// scope a service token must have to purge cached access
+	PurgeScope string      `yaml:"purge-scope"`
+	Redis      RedisConfig `yaml:"redis"`
+}
+
+// RedisConfig keeps settings of shared access cache tier, KeyPrefix namespaces keys of both cached access
+// and redis history, so deployments may share redis
+type RedisConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Address   string        `yaml:"address"`
+	Password  string        `yaml:"password" secret:"true"`
+	DB        int           `yaml:"db"`
+	TTL       time.Duration `yaml:"ttl"`
+	Timeout   time.Duration `yaml:"timeout"`
+	KeyPrefix string        `yaml:"key-prefix"`
+}
+
+// history stores
+const (
+	HistoryStoreMemory = "memory"
+	HistoryStoreRedis  = "redis"
+)
+
+// HistoryConfig keeps settings of access change history
+type HistoryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// HistoryStoreMemory (default) keeps history in the process, HistoryStoreRedis
+	// shares it between replicas using connection settings of cache.redis
+	Store string `yaml:"store"`
+	// max number of changes kept per user, the oldest ones are dropped
+	MaxEntries int `yaml:"max-entries"`
+	// max number of users kept by HistoryStoreMemory, history of the least recently looked up ones is dropped
+	MaxUsers int `yaml:"max-users"`
+	// page size of history endpoint when limit isn't requested, and its max value
+	PageSize    int `yaml:"page-size"`
+	MaxPageSize int `yaml:"max-page-size"`
+}
+
+// audit event outputs
+const (
+	AuditOutputStdout = "stdout"
+	AuditOutputFile   = "file"
+)
+
+// redaction policies of child IDs in audit events
+const (
+	AuditRedactOmit = "omit"
+	AuditRedactHash = "hash"
+	AuditRedactNone = "none"
+)
+
+// AuditConfig keeps settings of access decision audit trail, which is written apart from application log
+type AuditConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// AuditOutputStdout (default) or AuditOutputFile
+	Output string          `yaml:"output"`
+	File   AuditFileConfig `yaml:"file"`
+	// AuditRedactOmit (default) keeps only number of child IDs, AuditRedactHash replaces them
+	// with keyed hashes which are stable between events, AuditRedactNone keeps them as is
+	ChildIDs string `yaml:"child-ids"`
+	HashKey  string `yaml:"hash-key" secret:"true"`
+}
+
+// AuditFileConfig defines audit file and its rotation
+type AuditFileConfig struct {
+	Path string `yaml:"path"`
+	// file is rotated when it exceeds max size in megabytes, at most max backups rotated files are kept
+	MaxSize    int `yaml:"max-size"`
+	MaxBackups int `yaml:"max-backups"`
 }
 
+// WebhooksConfig keeps subscriptions to access change events and settings of their delivery
+type WebhooksConfig struct {
+	Enabled       bool                  `yaml:"enabled"`
+	Subscriptions []WebhookSubscription `yaml:"subscriptions"`
+	// number of concurrent deliveries and max number of queued ones
+	Workers   int           `yaml:"workers"`
+	QueueSize int           `yaml:"queue-size"`
+	Timeout   time.Duration `yaml:"timeout"`
+	// failed deliveries are retried with backoff which doubles after every attempt,
+	// events which aren't delivered are appended to dead letter file
+	MaxAttempts    int           `yaml:"max-attempts"`
+	Backoff        time.Duration `yaml:"backoff"`
+	MaxBackoff     time.Duration `yaml:"max-backoff"`
+	DeadLetterFile string        `yaml:"dead-letter-file"`
+}
+
+// WebhookSubscription is a receiver of events, every event is signed by HMAC with its secret
+type WebhookSubscription struct {
+	ID     string `yaml:"id"`
+	URL    string `yaml:"url"`
+	Secret string `yaml:"secret" secret:"true"`
+}
+
+// HierarchyConfig limits expansion of admin entities down the CCNET hierarchy
+type HierarchyConfig struct {
+	MaxDepth   int `yaml:"max-depth"`
+	MaxResults int `yaml:"max-results"`
+}
+
+// ConfigListener is notified after config is reloaded, neither config may be modified
+type ConfigListener func(old, new *Config)
+
 // ApplicationConfigService represent configuration service for authorization service
 type ApplicationConfigService interface {
 	Config() *Config
 	IsProduction() bool
+	// Reload re-reads config file and notifies listeners, current config is kept if new one is invalid
+	Reload() error
+	// Subscribe registers listener of reloaded config
+	Subscribe(ConfigListener)
 }
 
+// ConfigOverride changes config read from file before it's validated, e.g. by command line flags
+type ConfigOverride func(*Config)
+
 // dummy type which implements ApplicationConfigService and holds path to config file
 type configService struct {
 	configFile *string
+	overrides  []ConfigOverride
+	reloadMu   sync.Mutex // serializes reloads, so listeners are notified in order
+	mu         sync.RWMutex
 	config     *Config
+	listeners  []ConfigListener
 }
 
-// NewApplicationConfigService builds new implementation of ConfigService
-func NewApplicationConfigService(configFile *string) ApplicationConfigService {
-	appConfigService := &configService{configFile: configFile, config: &Config{}}
-	if err := initialize(configFile, appConfigService.config); err != nil {
+// NewApplicationConfigService builds new implementation of ConfigService, overrides are applied
+// after environment on every (re)load
+func NewApplicationConfigService(configFile *string, overrides ...ConfigOverride) ApplicationConfigService {
+	appConfigService := &configService{configFile: configFile, overrides: overrides, config: &Config{}}
+	if err := initialize(configFile, appConfigService.config, overrides...); err != nil {
 		panic("unable to initialize configuration service: " + err.Error())
 	}
 	return appConfigService
 }
 
-// initialize configuration service - read data from provided yml file
-func initialize(configFile *string, config *Config) error {
+// initialize configuration service - read data from provided yml file, overridden by environment (see applyEnv)
+// and then by overrides, the result is validated
+func initialize(configFile *string, config *Config, overrides ...ConfigOverride) error {
 	if _, err := os.Stat(*configFile); os.IsNotExist(err) {
 		return fmt.Errorf("unable to find application configuration file: %s", *configFile)
 	}
@@ -81,13 +330,60 @@ func initialize(configFile *string, config *Config) error {
 	if err != nil {
 		return err
 	}
-	return yaml.Unmarshal(bytes, config)
+	// unknown and duplicated keys are rejected, so misplaced settings aren't ignored silently
+	if err = yaml.UnmarshalStrict(bytes, config); err != nil {
+		return err
+	}
+	// secrets are passed by environment rather than kept in config file
+	if err = applyEnv(config, os.LookupEnv, ioutil.ReadFile); err != nil {
+		return err
+	}
+	for _, override := range overrides {
+		override(config)
+	}
+	config.Roles.setDefaults()
+	return config.Validate()
+}
+
+// LoadConfig reads config file the same way as config service, but returns error instead of panic
+func LoadConfig(configFile string) (*Config, error) {
+	config := &Config{}
+	return config, initialize(&configFile, config)
 }
 
 func (s *configService) Config() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.config
 }
 
 func (s *configService) IsProduction() bool {
-	return s.config.App.Env == "production"
+	return s.Config().App.Env == "production"
+}
+
+func (s *configService) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	config := &Config{}
+	if err := initialize(s.configFile, config, s.overrides...); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	old := s.config
+	// db is connected at startup, so its settings including command line overrides are kept until restart
+	config.MsSQL = old.MsSQL
+	s.config = config
+	listeners := append([]ConfigListener{}, s.listeners...)
+	s.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(old, config)
+	}
+	return nil
+}
+
+func (s *configService) Subscribe(listener ConfigListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
 }
@@ -16,17 +16,17 @@ app:
   port: 8888
   id: "1"
   keys-server: "http://localhost:8888"
-  newrelic:
+newrelic:
   enabled: false
   apikey: "1234567890123456789012345678901234567890"
   ignorehttpcodes: [400, 401, 402, 403, 404, 405, 406]
-  mssql:
-    connection:
-      host: "10.0.0.53"
-      port: 62433
-      database: "CCNET"
-      user: "test user"
-      password: "mysecret"`
+mssql:
+  connection:
+    host: "10.0.0.53"
+    port: 62433
+    database: "CCNET"
+    user: "test user"
+    password: "mysecret"`
 
 func TestConfigService(t *testing.T) {
 	// error
@@ -48,6 +48,126 @@ func TestConfigService(t *testing.T) {
 	assert.NotNil(t, configService.Config())
 	assert.False(t, configService.IsProduction())
 	assert.Equal(t, "8888", configService.Config().App.PortToStr())
+	assert.Equal(t, DefaultRolesConfig(), configService.Config().Roles)
+	assert.Equal(t, 62433, configService.Config().MsSQL.Connection.Port)
+	assert.Equal(t, "mysecret", configService.Config().MsSQL.Connection.Password)
+}
+
+func TestConfigServiceEnvOverride(t *testing.T) {
+	file, err := ioutil.TempFile(os.TempDir(), "cfg")
+	if err != nil {
+		t.Fatal("unable to create tmp file for test", err)
+	}
+	defer os.Remove(file.Name())
+	configFile := file.Name()
+	if err = ioutil.WriteFile(configFile, []byte(testCfg), 0644); err != nil {
+		t.Fatal("unable to write test data into file", err)
+	}
+	os.Setenv("AUTHR_APP_ENV", "production")
+	defer os.Unsetenv("AUTHR_APP_ENV")
+	configService := NewApplicationConfigService(&configFile)
+	assert.True(t, configService.IsProduction())
+	assert.Equal(t, "production", configService.Config().App.Env)
+}
+
+func TestConfigServiceOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cfg")
+	if err != nil {
+		t.Fatal("unable to create tmp dir for test", err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := dir + "/config.yml"
+	if err = ioutil.WriteFile(configFile, []byte(testCfg), 0644); err != nil {
+		t.Fatal("unable to write test data into file", err)
+	}
+
+	// overrides are validated together with config file
+	assert.PanicsWithValue(t, "unable to initialize configuration service: invalid configuration: "+
+		"mssql.fixtures-file: is required", func() {
+		NewApplicationConfigService(&configFile, func(config *Config) { config.MsSQL.Backend = BackendFixture })
+	})
+	service := NewApplicationConfigService(&configFile,
+		func(config *Config) { config.MsSQL.Backend = BackendFixture },
+		func(config *Config) { config.MsSQL.FixturesFile = "fixtures.yml" })
+	assert.Equal(t, BackendFixture, service.Config().MsSQL.Backend)
+	assert.Equal(t, "fixtures.yml", service.Config().MsSQL.FixturesFile)
+}
+
+func TestConfigServiceReload(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cfg")
+	if err != nil {
+		t.Fatal("unable to create tmp dir for test", err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := dir + "/config.yml"
+	if err = ioutil.WriteFile(configFile, []byte(testCfg), 0644); err != nil {
+		t.Fatal("unable to write test data into file", err)
+	}
+	// command line override
+	service := NewApplicationConfigService(&configFile, func(config *Config) {
+		config.MsSQL.Backend, config.MsSQL.FixturesFile = BackendFixture, "fixtures.yml"
+	})
+	var notified [][2]*Config
+	service.Subscribe(func(old, new *Config) { notified = append(notified, [2]*Config{old, new}) })
+	initial := service.Config()
+
+	// valid config is swapped, db settings are kept until restart
+	if err = ioutil.WriteFile(configFile, []byte(testCfg+"\nroles:\n  allowed-user-types: [1]\n"), 0644); err != nil {
+		t.Fatal("unable to write test data into file", err)
+	}
+	assert.NoError(t, service.Reload())
+	assert.Equal(t, []int64{1}, service.Config().Roles.AllowedUserTypes)
+	assert.Equal(t, BackendFixture, service.Config().MsSQL.Backend)
+	assert.Equal(t, [][2]*Config{{initial, service.Config()}}, notified)
+
+	// invalid config is rejected, listeners aren't notified
+	if err = ioutil.WriteFile(configFile, []byte(testCfg+"\nroles:\n  allowed-user-types: []\n"), 0644); err != nil {
+		t.Fatal("unable to write test data into file", err)
+	}
+	assert.EqualError(t, service.Reload(),
+		"invalid configuration: roles.allowed-user-types: at least one user type should be allowed")
+	assert.Equal(t, []int64{1}, service.Config().Roles.AllowedUserTypes)
+	assert.Equal(t, 1, len(notified))
+}
+
+func TestLoadConfig(t *testing.T) {
+	// config shipped with the service
+	config, err := LoadConfig("../../../configs/authr-config.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", config.App.Env)
+
+	dir, err := ioutil.TempDir(os.TempDir(), "cfg")
+	if err != nil {
+		t.Fatal("unable to create tmp dir for test", err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := dir + "/config.yml"
+
+	// unknown keys are rejected
+	if err = ioutil.WriteFile(configFile, []byte(testCfg+"\n    pasword: \"typo\"\nmetrics:\n  enabled: true\n"), 0644); err != nil {
+		t.Fatal("unable to write test data into file", err)
+	}
+	_, err = LoadConfig(configFile)
+	assert.EqualError(t, err, "yaml: unmarshal errors:\n"+
+		"  line 19: field pasword not found in type authrlib.Connection\n"+
+		"  line 20: field metrics not found in type authrlib.Config")
+
+	// all problems are reported at once
+	if err = ioutil.WriteFile(configFile, []byte("app:\n  env: \"prod\"\n  keys-server: \"localhost\"\n"), 0644); err != nil {
+		t.Fatal("unable to write test data into file", err)
+	}
+	_, err = LoadConfig(configFile)
+	validationErr, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"app.name: is required",
+		`app.env: unsupported value "prod", expected one of local, dev, test, qa, staging, production`,
+		"app.port: must be between 1 and 65535, got 0",
+		`app.keys-server: must be http or https url, got "localhost"`,
+		"mssql.connection.host: is required",
+		"mssql.connection.database: is required",
+		"mssql.connection.port: must be between 1 and 65535, got 0",
+	}, validationErr.Problems)
 }
 
 func TestInitialize(t *testing.T) {
@@ -88,4 +208,16 @@ func TestInitialize(t *testing.T) {
 	if expected != err.Error() {
 		t.Fatalf("Got '%s', expected '%s'", err.Error(), expected)
 	}
+
+	// invalid roles mapping
+	err = ioutil.WriteFile(configFile, []byte(testCfg+"\nroles:\n  admin-types:\n    admin: 0\n"), 0644)
+	if err != nil {
+		t.Fatal("unable to write test data into file", err)
+	}
+	config = &Config{}
+	err = initialize(&configFile, config)
+	expected = `invalid configuration: roles.admin-types: role "vo-admin" is not mapped; roles.admin-types: role "vo-no-child-admin" is not mapped`
+	if err == nil || expected != err.Error() {
+		t.Fatalf("Got '%v', expected '%s'", err, expected)
+	}
 }
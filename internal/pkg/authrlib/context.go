@@ -8,11 +8,28 @@ import (
 
 // AppContext defines application context
 type AppContext struct {
-	Logger                      *AppLogger
-	ConfigService               ApplicationConfigService
-	NewRelicService             NewRelicService
-	Healthchecks                *health.HealthCheckCollection
-	DbManager                   DbManager
-	AccessHandler               http.HandlerFunc
-	AccessValidationMiddlewares []func(next http.Handler) http.Handler
+	Logger                                 *AppLogger
+	ConfigService                          ApplicationConfigService
+	NewRelicService                        NewRelicService
+	Healthchecks                           *health.HealthCheckCollection
+	Probes                                 Probes
+	DbManager                              DbManager
+	TokenSigner                            TokenSigner
+	KeySet                                 KeySet
+	AuditSink                              AuditSink
+	AccessHandler                          http.HandlerFunc
+	AccessValidationMiddlewares            []func(next http.Handler) http.Handler
+	DelegatedAccessValidationMiddlewares   []func(next http.Handler) http.Handler
+	AccessCheckHandler                     http.HandlerFunc
+	BatchAccessHandler                     http.HandlerFunc
+	BatchAccessValidationMiddlewares       []func(next http.Handler) http.Handler
+	CacheInvalidationHandler               http.HandlerFunc
+	CacheInvalidationValidationMiddlewares []func(next http.Handler) http.Handler
+	TokenHandler                           http.HandlerFunc
+	AccessHistoryHandler                   http.HandlerFunc
+	JWKSHandler                            http.HandlerFunc
+	DBStatsHandler                         http.HandlerFunc
+	DebugValidationMiddlewares             []func(next http.Handler) http.Handler
+	LivenessHandler                        http.HandlerFunc
+	ReadinessHandler                       http.HandlerFunc
 }
@@ -1,50 +1,291 @@
 package authrlib
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// startup connectivity check defaults
+const (
+	defaultConnectBackoff = time.Second
+	maxConnectBackoff     = 30 * time.Second
+)
+
+// replica failover defaults
+const (
+	defaultMaxFailures   = 3
+	defaultProbeInterval = 30 * time.Second
+)
+
+// name of primary node in healthchecks and statistics
+const primaryNode = "primary"
+
+// DbReader runs read-only statements
+type DbReader interface {
+	// Runs read on a healthy replica, falls back to primary when there is none or read fails
+	Read(context.Context, func(*sql.DB) error) error
+}
+
 // DbManager manages a connection with database
 type DbManager interface {
-	// Provides a valid *sql.DB object
+	DbReader
+	// Provides a valid *sql.DB object of primary
 	Db() *sql.DB
+	// Connection pool statistics per node
+	Stats() map[string]sql.DBStats
 	// Closes connection with db
 	Release()
 }
 
 // impl of DbManager
 type dbManager struct {
-	ctx  *AppContext
-	conn *sql.DB
+	ctx         *AppContext
+	conn        *sql.DB
+	replicas    []*dbNode
+	next        uint32 // round robin over replicas
+	maxFailures int
+	stop        chan struct{}
+}
+
+// replica with its health state
+type dbNode struct {
+	name string
+	db   *sql.DB
+
+	mu       sync.RWMutex
+	failures int // consecutive
+	healthy  bool
+	lastErr  error
 }
 
-// NewDbManager instantiates new mssql database manager
+// NewDbManager instantiates new database manager of configured backend
+// it fails when primary is not reachable after configured number of retries,
+// unreachable replicas are only marked unhealthy
 func NewDbManager(ctx *AppContext) (DbManager, error) {
-	connConfig := ctx.ConfigService.Config().MsSQL.Connection
+	config := ctx.ConfigService.Config().MsSQL
+
+	mssconn, err := openDb(config.DriverName(), config.Connection, config.Pool)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = pingWithRetry(ctx, mssconn.Ping, config.ConnectRetries, config.ConnectBackoff, time.Sleep); err != nil {
+		mssconn.Close()
+		return nil, err
+	}
+
+	manager := &dbManager{ctx: ctx, conn: mssconn, maxFailures: config.Failover.MaxFailures, stop: make(chan struct{})}
+	if manager.maxFailures <= 0 {
+		manager.maxFailures = defaultMaxFailures
+	}
+
+	ctx.Probes.AddCheck("CCNet", true, manager.conn.Ping)
 
-	mssconn, err := sql.Open("mssql",
-		fmt.Sprintf("server=%s; port=%v; database=%s; user id=%s; password=%s;",
+	for _, connConfig := range config.Replicas {
+		connConfig = replicaConnection(connConfig, config.Connection)
+		db, err := openDb(config.DriverName(), connConfig, config.Pool)
+		if err != nil {
+			manager.Release()
+			return nil, err
+		}
+		node := &dbNode{name: fmt.Sprintf("replica %s:%d", connConfig.Host, connConfig.Port), db: db, healthy: true}
+		if err = db.Ping(); err != nil {
+			ctx.Logger.Warn().Err(err).Str("node", node.name).Msg("db replica is not reachable")
+			node.healthy, node.lastErr = false, err
+		}
+		manager.replicas = append(manager.replicas, node)
+
+		// primary serves reads while replica is down
+		ctx.Probes.AddStateCheck("CCNet "+node.name, false, node.err)
+	}
+
+	if len(manager.replicas) > 0 {
+		probeInterval := config.Failover.ProbeInterval
+		if probeInterval <= 0 {
+			probeInterval = defaultProbeInterval
+		}
+		go manager.probe(probeInterval)
+	}
+
+	return manager, nil
+}
+
+func openDb(driverName string, connConfig Connection, pool PoolConfig) (*sql.DB, error) {
+	dsn, err := dataSourceName(driverName, connConfig)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	configurePool(db, pool)
+	return db, nil
+}
+
+// builds connection string in the format of backend's driver
+func dataSourceName(driverName string, connConfig Connection) (string, error) {
+	switch driverName {
+	case BackendMSSQL:
+		return fmt.Sprintf("server=%s; port=%v; database=%s; user id=%s; password=%s;",
 			connConfig.Host,
 			connConfig.Port,
 			connConfig.Database,
 			connConfig.User,
-			connConfig.Password))
-	if err != nil {
-		return nil, err
+			connConfig.Password), nil
+	case BackendPostgres:
+		dsn := &url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(connConfig.User, connConfig.Password),
+			Host:   connConfig.Host + ":" + strconv.Itoa(connConfig.Port),
+			Path:   "/" + connConfig.Database,
+		}
+		if connConfig.SSLMode != "" {
+			dsn.RawQuery = url.Values{"sslmode": {connConfig.SSLMode}}.Encode()
+		}
+		return dsn.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported db backend: %s", driverName)
+	}
+}
+
+// fills empty fields of replica connection from primary one
+func replicaConnection(replica, primary Connection) Connection {
+	if replica.Port == 0 {
+		replica.Port = primary.Port
+	}
+	if replica.Database == "" {
+		replica.Database = primary.Database
+	}
+	if replica.User == "" {
+		replica.User, replica.Password = primary.User, primary.Password
+	}
+	return replica
+}
+
+// applies configured limits of connection pool
+func configurePool(db *sql.DB, pool PoolConfig) {
+	if pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConns)
 	}
+	if pool.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+}
 
-	manager := &dbManager{ctx, mssconn}
+// pings db until success, backoff doubles after every failure up to maxConnectBackoff
+func pingWithRetry(ctx *AppContext, ping func() error, retries int, backoff time.Duration, sleep func(time.Duration)) error {
+	if backoff <= 0 {
+		backoff = defaultConnectBackoff
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = ping(); err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return fmt.Errorf("db is not reachable after %d attempts: %v", attempt+1, err)
+		}
+		ctx.Logger.Warn().Err(err).Int("attempt", attempt+1).Str("backoff", backoff.String()).Msg("db is not reachable, retrying")
+		sleep(backoff)
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
 
-	ctx.Healthchecks.AddHealthCheck("CCNet", func() (bool, error) {
-		err := manager.conn.Ping()
-		return err == nil, err
-	})
+// Read tries healthy replicas in round robin order, failed read is repeated on primary within the same deadline;
+// reads cancelled by caller are neither repeated nor counted as replica failures, reads which exceeded
+// the deadline are counted but not repeated
+func (m *dbManager) Read(ctx context.Context, read func(*sql.DB) error) error {
+	if node := m.replica(); node != nil {
+		err := read(node.db)
+		if err == nil {
+			node.succeeded()
+			return nil
+		}
+		if ctx.Err() == context.Canceled {
+			return err
+		}
+		if node.failed(err, m.maxFailures) {
+			m.ctx.Logger.Warn().Err(err).Str("node", node.name).Msg("db replica marked unhealthy")
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return read(m.conn)
+}
 
-	return manager, nil
+// next healthy replica, nil if there is none
+func (m *dbManager) replica() *dbNode {
+	n := uint32(len(m.replicas))
+	if n == 0 {
+		return nil
+	}
+	start := atomic.AddUint32(&m.next, 1)
+	for i := uint32(0); i < n; i++ {
+		if node := m.replicas[(start+i)%n]; node.isHealthy() {
+			return node
+		}
+	}
+	return nil
+}
+
+// re-probes unhealthy replicas until manager is released
+func (m *dbManager) probe(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.probeReplicas()
+		}
+	}
+}
+
+func (m *dbManager) probeReplicas() {
+	for _, node := range m.replicas {
+		if node.isHealthy() {
+			continue
+		}
+		if err := node.db.Ping(); err != nil {
+			node.failed(err, m.maxFailures)
+			continue
+		}
+		node.succeeded()
+		m.ctx.Logger.Info().Str("node", node.name).Msg("db replica recovered")
+	}
+}
+
+func (m *dbManager) Stats() map[string]sql.DBStats {
+	stats := map[string]sql.DBStats{primaryNode: m.conn.Stats()}
+	for _, node := range m.replicas {
+		stats[node.name] = node.db.Stats()
+	}
+	return stats
 }
 
 func (m *dbManager) Release() {
+	if m.stop != nil {
+		close(m.stop)
+	}
+	for _, node := range m.replicas {
+		if err := node.db.Close(); err != nil {
+			m.ctx.Logger.Error().Err(err).Str("node", node.name).Msg("unable to release db connection")
+		}
+	}
 	if err := m.conn.Close(); err != nil {
 		m.ctx.Logger.Error().Err(err).Msg("unable to release db connection")
 	}
@@ -53,3 +294,38 @@ func (m *dbManager) Release() {
 func (m *dbManager) Db() *sql.DB {
 	return m.conn
 }
+
+func (n *dbNode) isHealthy() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.healthy
+}
+
+// error of the last failure while replica is unhealthy
+func (n *dbNode) err() error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.healthy {
+		return nil
+	}
+	return n.lastErr
+}
+
+func (n *dbNode) succeeded() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.failures, n.healthy, n.lastErr = 0, true, nil
+}
+
+// counts failure, returns true when it made replica unhealthy
+func (n *dbNode) failed(err error, maxFailures int) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.failures++
+	n.lastErr = err
+	if n.healthy && n.failures >= maxFailures {
+		n.healthy = false
+		return true
+	}
+	return false
+}
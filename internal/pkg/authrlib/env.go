@@ -0,0 +1,200 @@
+package authrlib
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts names of environment variables which override config file
+const EnvPrefix = "AUTHR"
+
+// suffix of variables which reference a file with the value, e.g. docker or kubernetes secret
+const envFileSuffix = "_FILE"
+
+// replaces values of secret fields in printed config
+const secretMask = "******"
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// applyEnv overrides config fields by environment variables. Precedence, from the lowest:
+//  1. config file
+//  2. variable named after yaml path of the field, e.g. AUTHR_MSSQL_CONNECTION_PASSWORD for mssql.connection.password
+//  3. variable with _FILE suffix, e.g. AUTHR_MSSQL_CONNECTION_PASSWORD_FILE, its value is a path of file
+//     whose content with trailing newlines trimmed is the value
+//
+// Lists are comma separated, maps are comma separated key=value pairs, durations are in time.ParseDuration format.
+// Lists of sections, e.g. mssql.replicas, can only be set in config file.
+func applyEnv(config *Config, lookup func(string) (string, bool), readFile func(string) ([]byte, error)) error {
+	return applyEnvFields(reflect.ValueOf(config).Elem(), EnvPrefix, lookup, readFile)
+}
+
+func applyEnvFields(v reflect.Value, prefix string, lookup func(string) (string, bool), readFile func(string) ([]byte, error)) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		name = prefix + "_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+		if field.Type.Kind() == reflect.Struct && field.Type != timeType {
+			if err := applyEnvFields(v.Field(i), name, lookup, readFile); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := lookup(name)
+		source := name
+		if path, fileOK := lookup(name + envFileSuffix); fileOK {
+			content, err := readFile(path)
+			if err != nil {
+				return fmt.Errorf("unable to read %s: %v", name+envFileSuffix, err)
+			}
+			value, ok, source = strings.TrimRight(string(content), "\r\n"), true, name+envFileSuffix
+		}
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(v.Field(i), value); err != nil {
+			return fmt.Errorf("invalid value of %s: %v", source, err)
+		}
+	}
+	return nil
+}
+
+func setEnvValue(v reflect.Value, value string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case v.Type() == timeType:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Struct {
+			return fmt.Errorf("list of sections can't be set by environment")
+		}
+		items := splitEnvList(value)
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setEnvValue(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Map:
+		items := splitEnvList(value)
+		m := reflect.MakeMapWithSize(v.Type(), len(items))
+		for _, item := range items {
+			pair := strings.SplitN(item, "=", 2)
+			if len(pair) != 2 {
+				return fmt.Errorf("%q is not key=value pair", item)
+			}
+			key, elem := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+			if err := setEnvValue(key, strings.TrimSpace(pair[0])); err != nil {
+				return err
+			}
+			if err := setEnvValue(elem, strings.TrimSpace(pair[1])); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+		return nil
+	}
+	return setScalar(v, value)
+}
+
+func setScalar(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// empty value is an empty list
+func splitEnvList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	items := strings.Split(value, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+// MaskedYAML returns effective config in yaml with values of secret fields masked
+func (c *Config) MaskedYAML() ([]byte, error) {
+	// the copy is masked, config itself is used by the service
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	masked := &Config{}
+	if err = yaml.Unmarshal(data, masked); err != nil {
+		return nil, err
+	}
+	maskSecrets(reflect.ValueOf(masked).Elem())
+	return yaml.Marshal(masked)
+}
+
+// masks non-empty string fields tagged `secret:"true"`, empty ones are kept to show they aren't set
+func maskSecrets(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+				if v.Field(i).String() != "" {
+					v.Field(i).SetString(secretMask)
+				}
+				continue
+			}
+			maskSecrets(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			maskSecrets(v.Index(i))
+		}
+	}
+}
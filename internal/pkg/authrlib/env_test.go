@@ -0,0 +1,130 @@
+package authrlib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyEnv(t *testing.T) {
+	files := map[string]string{
+		"/run/secrets/db-password": "file-secret\n",
+		"/run/secrets/nr-key":      "nr-key-from-file",
+	}
+	readFile := func(path string) ([]byte, error) {
+		if content, ok := files[path]; ok {
+			return []byte(content), nil
+		}
+		return nil, errors.New("no such file")
+	}
+
+	testCases := []struct {
+		name     string
+		env      map[string]string
+		validate func(*Config)
+		err      string
+	}{
+		{name: "no variables", validate: func(c *Config) {
+			assert.Equal(t, "pwd", c.MsSQL.Connection.Password)
+			assert.Equal(t, 8888, c.App.Port)
+		}},
+		{name: "variable overrides file", env: map[string]string{"AUTHR_MSSQL_CONNECTION_PASSWORD": "env-secret"},
+			validate: func(c *Config) { assert.Equal(t, "env-secret", c.MsSQL.Connection.Password) }},
+		{name: "secret file overrides variable", env: map[string]string{
+			"AUTHR_MSSQL_CONNECTION_PASSWORD":      "env-secret",
+			"AUTHR_MSSQL_CONNECTION_PASSWORD_FILE": "/run/secrets/db-password",
+			"AUTHR_NEWRELIC_APIKEY_FILE":           "/run/secrets/nr-key",
+		}, validate: func(c *Config) {
+			assert.Equal(t, "file-secret", c.MsSQL.Connection.Password)
+			assert.Equal(t, "nr-key-from-file", c.NewRelic.APIKey)
+		}},
+		{name: "typed values", env: map[string]string{
+			"AUTHR_APP_PORT":                   "9090",
+			"AUTHR_NEWRELIC_ENABLED":           "true",
+			"AUTHR_NEWRELIC_IGNOREHTTPCODES":   "400, 404",
+			"AUTHR_APP_CLIENT_IDS":             "reports-job,sync-job",
+			"AUTHR_MSSQL_QUERY_TIMEOUT":        "10s",
+			"AUTHR_MSSQL_POOL_MAX_OPEN_CONNS":  "20",
+			"AUTHR_ROLES_USER_TYPES":           "teacher=1, admin=3",
+			"AUTHR_CACHE_REDIS_ENABLED":        "1",
+			"AUTHR_APP_KEYS_CACHE_TIMEOUT":     "2s",
+			"AUTHR_ROLES_ALLOWED_USER_TYPES":   "",
+			"AUTHR_AUDIT_FILE_MAX_BACKUPS":     "3",
+			"AUTHR_APP_TOKEN_PRIVATE_KEY_FILE": "/keys/authr.pem",
+		}, validate: func(c *Config) {
+			assert.Equal(t, 9090, c.App.Port)
+			assert.True(t, c.NewRelic.Enabled)
+			assert.Equal(t, []int{400, 404}, c.NewRelic.IgnoreHTTPCodes)
+			assert.Equal(t, []string{"reports-job", "sync-job"}, c.App.ClientIDs)
+			assert.Equal(t, 10*time.Second, c.MsSQL.QueryTimeout)
+			assert.Equal(t, 20, c.MsSQL.Pool.MaxOpenConns)
+			assert.Equal(t, map[string]int64{"teacher": 1, "admin": 3}, c.Roles.UserTypes)
+			assert.True(t, c.Cache.Redis.Enabled)
+			assert.Equal(t, 2*time.Second, c.App.KeysCache.Timeout)
+			assert.Empty(t, c.Roles.AllowedUserTypes)
+			assert.Equal(t, 3, c.Audit.File.MaxBackups)
+			// _FILE suffix of field name isn't a secret file reference
+			assert.Equal(t, "/keys/authr.pem", c.App.Token.PrivateKeyFile)
+		}},
+		{name: "invalid number", env: map[string]string{"AUTHR_APP_PORT": "http"},
+			err: `invalid value of AUTHR_APP_PORT: strconv.ParseInt: parsing "http": invalid syntax`},
+		{name: "invalid duration in file", env: map[string]string{"AUTHR_MSSQL_QUERY_TIMEOUT_FILE": "/run/secrets/db-password"},
+			err: `invalid value of AUTHR_MSSQL_QUERY_TIMEOUT_FILE: time: invalid duration`},
+		{name: "invalid map", env: map[string]string{"AUTHR_ROLES_USER_TYPES": "teacher"},
+			err: `invalid value of AUTHR_ROLES_USER_TYPES: "teacher" is not key=value pair`},
+		{name: "missing secret file", env: map[string]string{"AUTHR_MSSQL_CONNECTION_PASSWORD_FILE": "/run/secrets/unknown"},
+			err: "unable to read AUTHR_MSSQL_CONNECTION_PASSWORD_FILE: no such file"},
+		{name: "list of sections", env: map[string]string{"AUTHR_MSSQL_REPLICAS": "replica"},
+			err: "invalid value of AUTHR_MSSQL_REPLICAS: list of sections can't be set by environment"},
+	}
+
+	for _, testCase := range testCases {
+		config := &Config{App: AppConfig{Port: 8888}, MsSQL: MsSQLConfig{Connection: Connection{Password: "pwd"}}}
+		lookup := func(name string) (string, bool) {
+			value, ok := testCase.env[name]
+			return value, ok
+		}
+		err := applyEnv(config, lookup, readFile)
+		if testCase.err != "" {
+			if assert.Error(t, err, testCase.name) {
+				assert.True(t, strings.HasPrefix(err.Error(), testCase.err), testCase.name+": "+err.Error())
+			}
+		} else {
+			assert.NoError(t, err, testCase.name)
+			testCase.validate(config)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestMaskedYAML(t *testing.T) {
+	config := &Config{
+		App:      AppConfig{Name: "authr", Port: 8888},
+		NewRelic: NewRelicConfig{APIKey: "nr-key"},
+		MsSQL: MsSQLConfig{
+			Connection:   Connection{User: "sa", Password: "db-secret"},
+			Replicas:     []Connection{{Host: "replica", Password: "replica-secret"}},
+			QueryTimeout: 30 * time.Second,
+		},
+		Cache:    CacheConfig{Redis: RedisConfig{Password: ""}},
+		Webhooks: WebhooksConfig{Subscriptions: []WebhookSubscription{{ID: "reports", Secret: "hook-secret"}}},
+	}
+	masked, err := config.MaskedYAML()
+	assert.NoError(t, err)
+	out := string(masked)
+	for _, secret := range []string{"nr-key", "db-secret", "replica-secret", "hook-secret"} {
+		assert.NotContains(t, out, secret)
+	}
+	assert.Contains(t, out, "apikey: '******'")
+	assert.Contains(t, out, "user: sa")
+	assert.Contains(t, out, "query-timeout: 30s")
+	assert.Contains(t, out, "host: replica")
+	// not set secrets stay empty
+	assert.Contains(t, out, "password: \"\"")
+	// config itself isn't masked
+	assert.Equal(t, "db-secret", config.MsSQL.Connection.Password)
+	assert.Equal(t, "hook-secret", config.Webhooks.Subscriptions[0].Secret)
+}
@@ -0,0 +1,73 @@
+package authrlib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJWK(t *testing.T) {
+	testCases := []struct {
+		name string
+		key  interface{}
+		jwk  JWK
+		err  string
+	}{
+		{name: "rsa", key: &rsa.PublicKey{N: big.NewInt(0xABCDEF), E: 65537},
+			jwk: JWK{Kty: "RSA", Kid: "kid-1", Use: "sig", Alg: "RS256", N: "q83v", E: "AQAB"}},
+		{name: "ecdsa", key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: big.NewInt(1), Y: big.NewInt(2)},
+			jwk: JWK{Kty: "EC", Kid: "kid-1", Use: "sig", Alg: "ES256", Crv: "P-256",
+				X: "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAE", Y: "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAI"}},
+		{name: "unsupported curve", key: &ecdsa.PublicKey{Curve: elliptic.P224(), X: big.NewInt(1), Y: big.NewInt(2)},
+			err: "unsupported curve P-224"},
+		{name: "unsupported key", key: "not a key", err: "unsupported public key type string"},
+	}
+
+	for _, testCase := range testCases {
+		jwk, err := NewJWK("kid-1", testCase.key)
+		if testCase.err != "" {
+			assert.EqualError(t, err, testCase.err, testCase.name)
+		} else {
+			assert.NoError(t, err, testCase.name)
+			assert.Equal(t, testCase.jwk, jwk, testCase.name)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	rsaKey := &rsa.PublicKey{N: big.NewInt(0xABCDEF), E: 65537}
+	ecKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: elliptic.P256().Params().Gx, Y: elliptic.P256().Params().Gy}
+	testCases := []struct {
+		name string
+		key  interface{}
+		jwk  JWK
+		err  string
+	}{
+		{name: "rsa", key: rsaKey},
+		{name: "ecdsa", key: ecKey},
+		{name: "unsupported key type", jwk: JWK{Kty: "oct"}, err: "unsupported key type oct"},
+		{name: "unsupported curve", jwk: JWK{Kty: "EC", Crv: "P-224"}, err: "unsupported curve P-224"},
+		{name: "not on curve", jwk: JWK{Kty: "EC", Kid: "kid-1", Crv: "P-256", X: "AQ", Y: "Ag"}, err: "key kid-1 is not on curve P-256"},
+		{name: "invalid encoding", jwk: JWK{Kty: "RSA", N: "q83v!", E: "AQAB"}, err: "illegal base64 data at input byte 4"},
+	}
+
+	for _, testCase := range testCases {
+		jwk := testCase.jwk
+		if testCase.key != nil {
+			jwk, _ = NewJWK("kid-1", testCase.key)
+		}
+		key, err := jwk.PublicKey()
+		if testCase.err != "" {
+			assert.EqualError(t, err, testCase.err, testCase.name)
+		} else {
+			assert.NoError(t, err, testCase.name)
+			assert.Equal(t, testCase.key, key, testCase.name)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
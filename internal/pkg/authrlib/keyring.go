@@ -0,0 +1,198 @@
+package authrlib
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// TokenSigner signs tokens issued by the service and publishes keys to verify them
+type TokenSigner interface {
+	// Sign returns signed compact JWT
+	Sign(jwt.Claims) (string, error)
+	// JWKS returns public keys which verify signed tokens
+	JWKS() *JWKS
+}
+
+// KeyRing is a TokenSigner backed by several keys, one of them is active for signing
+type KeyRing interface {
+	TokenSigner
+	// Reload re-reads keys configured in current config, current keys are kept if new ones are invalid
+	Reload() error
+}
+
+var errActiveKeyExpired = errors.New("active signing key expired")
+
+// a key of the ring
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   interface{}
+	public    crypto.PublicKey
+	expiresAt time.Time
+}
+
+func (k *signingKey) expired(now time.Time) bool {
+	return !k.expiresAt.IsZero() && !now.Before(k.expiresAt)
+}
+
+// impl of KeyRing
+type keyRing struct {
+	config ApplicationConfigService
+	now    func() time.Time
+	mu     sync.RWMutex
+	active *signingKey
+	keys   []*signingKey
+}
+
+// NewKeyRing loads signing keys configured in app.token section, keys are reloaded with config;
+// nil key ring is returned if token issuing is not configured
+func NewKeyRing(ctx *AppContext) (KeyRing, error) {
+	conf := ctx.ConfigService.Config().App.Token
+	if conf.PrivateKeyFile == "" && conf.KeysDir == "" && len(conf.Keys) == 0 {
+		return nil, nil
+	}
+	ring := &keyRing{config: ctx.ConfigService, now: time.Now}
+	if err := ring.Reload(); err != nil {
+		return nil, err
+	}
+	// keys are re-read even if config is the same, so rotated key files are picked up
+	ctx.ConfigService.Subscribe(func(_, _ *Config) {
+		if err := ring.Reload(); err != nil {
+			ctx.Logger.Error().Err(err).Msg("unable to reload signing keys, keeping current ones")
+			return
+		}
+		ctx.Logger.Info().Int("keys", len(ring.JWKS().Keys)).Msg("signing keys reloaded")
+	})
+	return ring, nil
+}
+
+func (r *keyRing) Reload() error {
+	conf := r.config.Config().App.Token
+	keys, err := loadSigningKeys(conf)
+	if err != nil {
+		return err
+	}
+	var active *signingKey
+	for _, key := range keys {
+		if key.kid == conf.KeyID {
+			active = key
+		}
+	}
+	if active == nil {
+		return fmt.Errorf("active signing key %q not found", conf.KeyID)
+	}
+	if active.expired(r.now()) {
+		return fmt.Errorf("active signing key %q expired", conf.KeyID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active = active
+	r.keys = keys
+	return nil
+}
+
+func (r *keyRing) Sign(claims jwt.Claims) (string, error) {
+	r.mu.RLock()
+	key := r.active
+	r.mu.RUnlock()
+
+	if key.expired(r.now()) {
+		return "", errActiveKeyExpired
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+func (r *keyRing) JWKS() *JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := r.now()
+	jwks := &JWKS{Keys: []JWK{}}
+	for _, key := range r.keys {
+		if key.expired(now) {
+			continue
+		}
+		// keys are validated on load
+		jwk, _ := NewJWK(key.kid, key.public)
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+// reads keys from all configured sources, kids must be unique
+func loadSigningKeys(conf TokenConfig) ([]*signingKey, error) {
+	var keyConfs []SigningKeyConfig
+	if conf.PrivateKeyFile != "" {
+		keyConfs = append(keyConfs, SigningKeyConfig{ID: conf.KeyID, PrivateKeyFile: conf.PrivateKeyFile})
+	}
+	if conf.KeysDir != "" {
+		files, err := filepath.Glob(filepath.Join(conf.KeysDir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			kid := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+			keyConfs = append(keyConfs, SigningKeyConfig{ID: kid, PrivateKeyFile: file})
+		}
+	}
+	keyConfs = append(keyConfs, conf.Keys...)
+
+	kids := make(map[string]bool)
+	keys := make([]*signingKey, 0, len(keyConfs))
+	for _, keyConf := range keyConfs {
+		if keyConf.ID == "" {
+			return nil, fmt.Errorf("signing key %s has no id", keyConf.PrivateKeyFile)
+		}
+		if kids[keyConf.ID] {
+			return nil, fmt.Errorf("duplicate signing key id %q", keyConf.ID)
+		}
+		kids[keyConf.ID] = true
+
+		key, err := loadSigningKey(keyConf)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parses RSA or ECDSA private key from PEM file
+func loadSigningKey(conf SigningKeyConfig) (*signingKey, error) {
+	bytes, err := ioutil.ReadFile(conf.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{kid: conf.ID, expiresAt: conf.ExpiresAt}
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(bytes); err == nil {
+		key.method, key.private, key.public = jwt.SigningMethodRS256, rsaKey, &rsaKey.PublicKey
+	} else if ecKey, err := jwt.ParseECPrivateKeyFromPEM(bytes); err == nil {
+		key.private, key.public = ecKey, &ecKey.PublicKey
+		switch ecdsaAlgorithms[ecKey.Curve.Params().Name] {
+		case "ES256":
+			key.method = jwt.SigningMethodES256
+		case "ES384":
+			key.method = jwt.SigningMethodES384
+		case "ES512":
+			key.method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("signing key %q has unsupported curve %s", conf.ID, ecKey.Curve.Params().Name)
+		}
+	} else {
+		return nil, fmt.Errorf("signing key %q is neither RSA nor ECDSA PEM key", conf.ID)
+	}
+	return key, nil
+}
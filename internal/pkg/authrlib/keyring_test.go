@@ -0,0 +1,202 @@
+package authrlib
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKeyRing(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "keys")
+	if err != nil {
+		t.Fatal("unable to create tmp dir for test", err)
+	}
+	defer os.RemoveAll(dir)
+	rsaKey := writeRSAKey(t, filepath.Join(dir, "rsa-1.pem"))
+	ecKey := writeECKey(t, filepath.Join(dir, "ec-1.pem"))
+	writeRSAKey(t, filepath.Join(dir, "single.key"))
+	if err = ioutil.WriteFile(filepath.Join(dir, "invalid.key"), []byte("invalid"), 0600); err != nil {
+		t.Fatal("unable to write key", err)
+	}
+
+	testCases := []struct {
+		name string
+		conf TokenConfig
+		kids []string
+		err  string
+	}{
+		{name: "not configured"},
+		{name: "single key", conf: TokenConfig{KeyID: "single", PrivateKeyFile: filepath.Join(dir, "single.key")},
+			kids: []string{"single"}},
+		{name: "keys dir", conf: TokenConfig{KeyID: "ec-1", KeysDir: dir}, kids: []string{"ec-1", "rsa-1"}},
+		{name: "keys list", conf: TokenConfig{KeyID: "rsa-1", Keys: []SigningKeyConfig{
+			{ID: "rsa-1", PrivateKeyFile: filepath.Join(dir, "rsa-1.pem")},
+			{ID: "old", PrivateKeyFile: filepath.Join(dir, "single.key"), ExpiresAt: time.Now().Add(time.Hour)},
+			{ID: "expired", PrivateKeyFile: filepath.Join(dir, "ec-1.pem"), ExpiresAt: time.Now().Add(-time.Hour)},
+		}}, kids: []string{"rsa-1", "old"}},
+		{name: "file not found", conf: TokenConfig{KeyID: "single", PrivateKeyFile: "/tmp/fake/path/to/key.pem"},
+			err: "open /tmp/fake/path/to/key.pem: no such file or directory"},
+		{name: "invalid key", conf: TokenConfig{KeyID: "single", PrivateKeyFile: filepath.Join(dir, "invalid.key")},
+			err: `signing key "single" is neither RSA nor ECDSA PEM key`},
+		{name: "no id", conf: TokenConfig{KeyID: "single", Keys: []SigningKeyConfig{{PrivateKeyFile: "single.key"}}},
+			err: "signing key single.key has no id"},
+		{name: "duplicate id", conf: TokenConfig{KeyID: "rsa-1", PrivateKeyFile: filepath.Join(dir, "single.key"), Keys: []SigningKeyConfig{
+			{ID: "rsa-1", PrivateKeyFile: filepath.Join(dir, "rsa-1.pem")},
+		}}, err: `duplicate signing key id "rsa-1"`},
+		{name: "active key not found", conf: TokenConfig{KeyID: "unknown", KeysDir: dir},
+			err: `active signing key "unknown" not found`},
+		{name: "active key expired", conf: TokenConfig{KeyID: "rsa-1", Keys: []SigningKeyConfig{
+			{ID: "rsa-1", PrivateKeyFile: filepath.Join(dir, "rsa-1.pem"), ExpiresAt: time.Now().Add(-time.Hour)},
+		}}, err: `active signing key "rsa-1" expired`},
+	}
+
+	for _, testCase := range testCases {
+		ctx := &AppContext{ConfigService: &configService{config: &Config{App: AppConfig{Token: testCase.conf}}}}
+		ring, err := NewKeyRing(ctx)
+		if testCase.err != "" {
+			assert.EqualError(t, err, testCase.err, testCase.name)
+		} else if assert.NoError(t, err, testCase.name) && testCase.kids == nil {
+			assert.Nil(t, ring, testCase.name)
+		} else {
+			var kids []string
+			for _, key := range ring.JWKS().Keys {
+				kids = append(kids, key.Kid)
+			}
+			assert.Equal(t, testCase.kids, kids, testCase.name)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+
+	// signing by RSA and ECDSA keys
+	for kid, public := range map[string]interface{}{"rsa-1": &rsaKey.PublicKey, "ec-1": &ecKey.PublicKey} {
+		ring, err := NewKeyRing(&AppContext{ConfigService: &configService{config: &Config{App: AppConfig{
+			Token: TokenConfig{KeyID: kid, KeysDir: dir}}}}})
+		assert.NoError(t, err)
+		signed, err := ring.Sign(&jwt.StandardClaims{Subject: "42"})
+		assert.NoError(t, err)
+		token, err := jwt.ParseWithClaims(signed, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+			assert.Equal(t, kid, token.Header["kid"])
+			return public, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "42", token.Claims.(*jwt.StandardClaims).Subject)
+		t.Log("test case ok: sign by", kid)
+	}
+}
+
+func TestKeyRingExpiration(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "keys")
+	if err != nil {
+		t.Fatal("unable to create tmp dir for test", err)
+	}
+	defer os.RemoveAll(dir)
+	writeRSAKey(t, filepath.Join(dir, "new.pem"))
+	writeRSAKey(t, filepath.Join(dir, "old.pem"))
+
+	now := time.Now()
+	ring, err := NewKeyRing(&AppContext{ConfigService: &configService{config: &Config{App: AppConfig{Token: TokenConfig{
+		KeyID: "new",
+		Keys: []SigningKeyConfig{
+			{ID: "new", PrivateKeyFile: filepath.Join(dir, "new.pem"), ExpiresAt: now.Add(2 * time.Hour)},
+			{ID: "old", PrivateKeyFile: filepath.Join(dir, "old.pem"), ExpiresAt: now.Add(time.Hour)},
+		}}}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ring.JWKS().Keys))
+
+	// old key is not published after expiration
+	ring.(*keyRing).now = func() time.Time { return now.Add(90 * time.Minute) }
+	assert.Equal(t, 1, len(ring.JWKS().Keys))
+	assert.Equal(t, "new", ring.JWKS().Keys[0].Kid)
+
+	// active key can't sign after expiration
+	ring.(*keyRing).now = func() time.Time { return now.Add(3 * time.Hour) }
+	_, err = ring.Sign(&jwt.StandardClaims{})
+	assert.Equal(t, errActiveKeyExpired, err)
+	assert.Equal(t, 0, len(ring.JWKS().Keys))
+}
+
+func TestKeyRingReload(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "keys")
+	if err != nil {
+		t.Fatal("unable to create tmp dir for test", err)
+	}
+	defer os.RemoveAll(dir)
+	writeRSAKey(t, filepath.Join(dir, "key-1.pem"))
+
+	var buf bytes.Buffer
+	config := &configService{config: &Config{App: AppConfig{Token: TokenConfig{KeyID: "key-1", KeysDir: dir}}}}
+	ctx := &AppContext{Logger: &AppLogger{zerolog.New(&buf)}, ConfigService: config}
+	ring, err := NewKeyRing(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(config.listeners))
+
+	// swaps config the way Reload does and notifies key ring
+	reload := func(token TokenConfig) {
+		old := config.config
+		config.config = &Config{App: AppConfig{Token: token}}
+		config.listeners[0](old, config.config)
+	}
+
+	// new key is published
+	writeRSAKey(t, filepath.Join(dir, "key-2.pem"))
+	reload(TokenConfig{KeyID: "key-1", KeysDir: dir})
+	assert.Equal(t, 2, len(ring.JWKS().Keys))
+	// active key is switched by config
+	reload(TokenConfig{KeyID: "key-2", KeysDir: dir})
+	signed, err := ring.Sign(&jwt.StandardClaims{})
+	assert.NoError(t, err)
+	token, _ := jwt.Parse(signed, nil)
+	assert.Equal(t, "key-2", token.Header["kid"])
+	// invalid key is rejected, current keys are kept
+	if err = ioutil.WriteFile(filepath.Join(dir, "key-3.pem"), []byte("invalid"), 0600); err != nil {
+		t.Fatal("unable to write key", err)
+	}
+	reload(TokenConfig{KeyID: "key-2", KeysDir: dir})
+
+	assert.Equal(t, 2, len(ring.JWKS().Keys))
+	assert.Contains(t, buf.String(), `"keys":2,"message":"signing keys reloaded"`)
+	assert.Contains(t, buf.String(), `"message":"unable to reload signing keys, keeping current ones"`)
+}
+
+// generates RSA key and writes it into file as PEM
+func writeRSAKey(t *testing.T, fileName string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("unable to generate key", err)
+	}
+	writePEM(t, fileName, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return key
+}
+
+// generates ECDSA P-256 key and writes it into file as PEM
+func writeECKey(t *testing.T, fileName string) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("unable to generate key", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("unable to marshal key", err)
+	}
+	writePEM(t, fileName, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return key
+}
+
+func writePEM(t *testing.T, fileName string, block *pem.Block) {
+	if err := ioutil.WriteFile(fileName, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal("unable to write key", err)
+	}
+}
@@ -0,0 +1,171 @@
+package authrlib
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// default settings of keys server JWKS cache
+const (
+	defaultKeysRefreshInterval    = 15 * time.Minute
+	defaultKeysMinRefreshInterval = 30 * time.Second
+	defaultKeysTimeout            = 5 * time.Second
+)
+
+var errKeyNotFound = errors.New("token signing key not found")
+
+// KeySet provides public keys verifying caller tokens
+type KeySet interface {
+	// Key returns public key by kid, empty kid is resolved only if set has a single key
+	Key(kid string) (crypto.PublicKey, error)
+	// LastError returns error of the last fetch, nil if keys server responded
+	LastError() error
+}
+
+// impl of KeySet, keeps the last fetched keys when keys server is down
+type remoteKeySet struct {
+	url                string
+	client             *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	now                func() time.Time
+
+	refreshMu   sync.Mutex // serializes fetches
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	lastErr     error
+}
+
+// NewKeySet creates cache of keys server JWKS and registers it as a degraded healthcheck,
+// unavailable keys server is not fatal on startup
+func NewKeySet(ctx *AppContext) KeySet {
+	config := ctx.ConfigService.Config().App
+	keySet := newRemoteKeySet(config.KeysServer, config.KeysCache)
+	if err := keySet.refresh(); err != nil {
+		ctx.Logger.Warn().Err(err).Msg("unable to fetch keys server keys")
+	}
+
+	// keys server outage doesn't fail the service while cached keys are served
+	ctx.Probes.AddStateCheck("KeysServer", false, keySet.LastError)
+	return keySet
+}
+
+func newRemoteKeySet(url string, config KeysCacheConfig) *remoteKeySet {
+	keySet := &remoteKeySet{
+		url:                url,
+		client:             &http.Client{Timeout: config.Timeout},
+		refreshInterval:    config.RefreshInterval,
+		minRefreshInterval: config.MinRefreshInterval,
+		now:                time.Now,
+		keys:               map[string]crypto.PublicKey{},
+	}
+	if keySet.refreshInterval <= 0 {
+		keySet.refreshInterval = defaultKeysRefreshInterval
+	}
+	if keySet.minRefreshInterval <= 0 {
+		keySet.minRefreshInterval = defaultKeysMinRefreshInterval
+	}
+	if keySet.client.Timeout <= 0 {
+		keySet.client.Timeout = defaultKeysTimeout
+	}
+	return keySet
+}
+
+func (s *remoteKeySet) Key(kid string) (crypto.PublicKey, error) {
+	key, found, stale := s.lookup(kid)
+	if found && !stale {
+		return key, nil
+	}
+	// unknown kid may be a rotated key, stale set is refreshed in place
+	if err := s.refresh(); err == nil {
+		key, found, _ = s.lookup(kid)
+	}
+	if !found {
+		return nil, errKeyNotFound
+	}
+	return key, nil
+}
+
+func (s *remoteKeySet) LastError() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastErr
+}
+
+func (s *remoteKeySet) lookup(kid string) (key crypto.PublicKey, found, stale bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stale = s.now().Sub(s.fetchedAt) >= s.refreshInterval
+	if kid == "" && len(s.keys) == 1 {
+		for _, key = range s.keys {
+			return key, true, stale
+		}
+	}
+	key, found = s.keys[kid]
+	return key, found, stale
+}
+
+// fetches keys unless the last attempt was less than min refresh interval ago,
+// current keys are kept on failure
+func (s *remoteKeySet) refresh() error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	s.mu.RLock()
+	limited := !s.attemptedAt.IsZero() && s.now().Sub(s.attemptedAt) < s.minRefreshInterval
+	lastErr := s.lastErr
+	s.mu.RUnlock()
+	if limited {
+		return lastErr
+	}
+
+	attemptedAt := s.now()
+	keys, err := s.fetch()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptedAt = attemptedAt
+	s.lastErr = err
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = attemptedAt
+	}
+	return err
+}
+
+func (s *remoteKeySet) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("keys server responded with status %d", resp.StatusCode)
+	}
+
+	var jwks JWKS
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("unable to decode keys server response: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		// keys of other types and uses are not relevant for token verification
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("keys server returned no usable keys")
+	}
+	return keys, nil
+}
@@ -0,0 +1,175 @@
+package authrlib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/health"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// keys server stub, counts requests and serves whatever is in jwks
+type keysServer struct {
+	*httptest.Server
+	requests int32
+	status   int32
+	jwks     atomic.Value
+}
+
+func newKeysServer(t *testing.T, keys map[string]*rsa.PrivateKey) *keysServer {
+	server := &keysServer{status: http.StatusOK}
+	server.setKeys(t, keys)
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&server.requests, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&server.status)))
+		w.Write(server.jwks.Load().([]byte))
+	}))
+	return server
+}
+
+func (s *keysServer) setKeys(t *testing.T, keys map[string]*rsa.PrivateKey) {
+	jwks := JWKS{Keys: []JWK{}}
+	for kid, key := range keys {
+		jwk, err := NewJWK(kid, &key.PublicKey)
+		if err != nil {
+			t.Fatal("unable to create jwk", err)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	bytes, _ := json.Marshal(jwks)
+	s.jwks.Store(bytes)
+}
+
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("unable to generate key", err)
+	}
+	return key
+}
+
+func TestNewKeySet(t *testing.T) {
+	key := generateRSAKey(t)
+	server := newKeysServer(t, map[string]*rsa.PrivateKey{"kid-1": key})
+	defer server.Close()
+
+	// keys are fetched on startup
+	healthchecks := health.NewHealthCheckCollection()
+	ctx := &AppContext{Healthchecks: healthchecks, ConfigService: &configService{config: &Config{App: AppConfig{KeysServer: server.URL}}}}
+	ctx.Probes = NewProbes(ctx)
+	keySet := NewKeySet(ctx)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.requests))
+	public, err := keySet.Key("kid-1")
+	assert.NoError(t, err)
+	assert.Equal(t, &key.PublicKey, public)
+	healthy, err := healthchecks.IsHealthy()
+	assert.True(t, healthy)
+	assert.NoError(t, err)
+
+	// unavailable keys server is logged and reported as degraded
+	var buf bytes.Buffer
+	server.Close()
+	ctx = &AppContext{Logger: &AppLogger{zerolog.New(&buf)}, Healthchecks: health.NewHealthCheckCollection(),
+		ConfigService: &configService{config: &Config{App: AppConfig{KeysServer: server.URL}}}}
+	ctx.Probes = NewProbes(ctx)
+	keySet = NewKeySet(ctx)
+	assert.Error(t, keySet.LastError())
+	assert.Contains(t, buf.String(), `"message":"unable to fetch keys server keys"`)
+	healthy, _ = ctx.Healthchecks.IsHealthy()
+	assert.True(t, healthy)
+	results := ctx.Probes.Results()
+	assert.Equal(t, "KeysServer", results[0].Name)
+	assert.False(t, results[0].Critical)
+	assert.False(t, results[0].Healthy)
+}
+
+func TestKeySetKey(t *testing.T) {
+	key1, key2 := generateRSAKey(t), generateRSAKey(t)
+	server := newKeysServer(t, map[string]*rsa.PrivateKey{"kid-1": key1})
+	defer server.Close()
+
+	now := time.Now()
+	keySet := newRemoteKeySet(server.URL, KeysCacheConfig{RefreshInterval: time.Hour, MinRefreshInterval: time.Minute})
+	keySet.now = func() time.Time { return now }
+
+	// first lookup fetches keys, next ones are served from cache
+	public, err := keySet.Key("kid-1")
+	assert.NoError(t, err)
+	assert.Equal(t, &key1.PublicKey, public)
+	public, err = keySet.Key("")
+	assert.NoError(t, err)
+	assert.Equal(t, &key1.PublicKey, public)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.requests))
+
+	// unknown kid refreshes keys once per min refresh interval
+	server.setKeys(t, map[string]*rsa.PrivateKey{"kid-1": key1, "kid-2": key2})
+	_, err = keySet.Key("kid-2")
+	assert.Equal(t, errKeyNotFound, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.requests))
+	now = now.Add(time.Minute)
+	public, err = keySet.Key("kid-2")
+	assert.NoError(t, err)
+	assert.Equal(t, &key2.PublicKey, public)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.requests))
+	_, err = keySet.Key("kid-3")
+	assert.Equal(t, errKeyNotFound, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.requests))
+	now = now.Add(time.Minute)
+	_, err = keySet.Key("kid-3")
+	assert.Equal(t, errKeyNotFound, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&server.requests))
+
+	// empty kid is ambiguous for several keys
+	_, err = keySet.Key("")
+	assert.Equal(t, errKeyNotFound, err)
+
+	// stale keys are served while keys server is down
+	atomic.StoreInt32(&server.status, http.StatusBadGateway)
+	now = now.Add(time.Hour)
+	public, err = keySet.Key("kid-1")
+	assert.NoError(t, err)
+	assert.Equal(t, &key1.PublicKey, public)
+	assert.EqualError(t, keySet.LastError(), "keys server responded with status 502")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&server.requests))
+
+	// keys server is back
+	atomic.StoreInt32(&server.status, http.StatusOK)
+	now = now.Add(time.Minute)
+	_, err = keySet.Key("kid-1")
+	assert.NoError(t, err)
+	assert.NoError(t, keySet.LastError())
+	assert.Equal(t, int32(5), atomic.LoadInt32(&server.requests))
+}
+
+func TestKeySetFetchErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{name: "invalid json", body: "{", err: "unable to decode keys server response: unexpected EOF"},
+		{name: "no keys", body: `{"keys":[]}`, err: "keys server returned no usable keys"},
+		{name: "no usable keys", body: `{"keys":[{"kty":"oct","kid":"kid-1"},{"kty":"RSA","kid":"kid-2","use":"enc","n":"q83v","e":"AQAB"}]}`,
+			err: "keys server returned no usable keys"},
+	}
+
+	for _, testCase := range testCases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(testCase.body))
+		}))
+		keySet := newRemoteKeySet(server.URL, KeysCacheConfig{})
+		_, err := keySet.Key("kid-1")
+		assert.Equal(t, errKeyNotFound, err, testCase.name)
+		assert.EqualError(t, keySet.LastError(), testCase.err, testCase.name)
+		server.Close()
+		t.Log("test case ok:", testCase.name)
+	}
+}
@@ -1,6 +1,7 @@
 package authrlib
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -36,3 +37,20 @@ func NewLogger(isProduction bool) *AppLogger {
 	}
 	return &AppLogger{zerolog.New(logWriter).With().Timestamp().Logger()}
 }
+
+// LogLevels lists supported values of app.log-level
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// SetLogLevel sets min level of all loggers, empty level logs everything down to debug
+func SetLogLevel(level string) error {
+	if level == "" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		return nil
+	}
+	l, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("unsupported log level: %s", level)
+	}
+	zerolog.SetGlobalLevel(l)
+	return nil
+}
@@ -71,3 +71,21 @@ func extractWriter(appLogger *AppLogger) interface{} {
 	rf = rs.Field(0)
 	return rf.Interface()
 }
+
+func TestSetLogLevel(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+
+	assert.NoError(t, SetLogLevel("warn"))
+	logger.Info().Msg("info")
+	logger.Warn().Msg("warn")
+	assert.Equal(t, `{"level":"warn","message":"warn"}`, strings.TrimSpace(buf.String()))
+
+	buf.Reset()
+	assert.NoError(t, SetLogLevel(""))
+	logger.Debug().Msg("debug")
+	assert.Equal(t, `{"level":"debug","message":"debug"}`, strings.TrimSpace(buf.String()))
+
+	assert.EqualError(t, SetLogLevel("verbose"), "unsupported log level: verbose")
+}
@@ -0,0 +1,121 @@
+package authrlib
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// default interval between runs of the same dependency check
+const defaultCheckInterval = 10 * time.Second
+
+// CheckResult is the latest result of a readiness check
+type CheckResult struct {
+	Name string `json:"name"`
+	// failed critical check makes service not ready, failed non-critical one only degrades it
+	Critical  bool      `json:"critical"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs float64   `json:"latencyMs"`
+	CheckedAt time.Time `json:"checkedAt"`
+	// the most recent failure, it's kept after recovery
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// Probes keeps readiness checks of dependencies, every check is reported by /health as well
+type Probes interface {
+	// AddCheck registers check which calls a dependency, its result is cached for check interval
+	// so probes don't load the dependency
+	AddCheck(name string, critical bool, check func() error)
+	// AddStateCheck registers check which only reads state kept by a component, it's run by every probe
+	AddStateCheck(name string, critical bool, check func() error)
+	// Results returns results of all checks in order of registration
+	Results() []CheckResult
+}
+
+// impl of Probes
+type probes struct {
+	ctx      *AppContext
+	interval time.Duration
+	now      func() time.Time
+	mu       sync.RWMutex
+	checks   []*check
+}
+
+// registered check with its latest result
+type check struct {
+	run    func() error
+	cached bool
+	mu     sync.Mutex // check runs once at a time, concurrent probes wait for its result
+	result CheckResult
+}
+
+// NewProbes creates registry of readiness checks with interval configured in app.health section
+func NewProbes(ctx *AppContext) Probes {
+	return &probes{
+		ctx:      ctx,
+		interval: durationOr(ctx.ConfigService.Config().App.Health.CheckInterval, defaultCheckInterval),
+		now:      time.Now,
+	}
+}
+
+func (p *probes) AddCheck(name string, critical bool, run func() error) {
+	p.add(&check{run: run, cached: true, result: CheckResult{Name: name, Critical: critical}})
+}
+
+func (p *probes) AddStateCheck(name string, critical bool, run func() error) {
+	p.add(&check{run: run, result: CheckResult{Name: name, Critical: critical}})
+}
+
+func (p *probes) add(c *check) {
+	p.mu.Lock()
+	p.checks = append(p.checks, c)
+	p.mu.Unlock()
+	// load balancer still polls /health, failed non-critical checks are reported but don't fail it
+	p.ctx.Healthchecks.AddHealthCheck(c.result.Name, func() (bool, error) {
+		result := p.result(c)
+		if result.Healthy {
+			return true, nil
+		}
+		return !result.Critical, errors.New(result.Error)
+	})
+}
+
+func (p *probes) Results() []CheckResult {
+	p.mu.RLock()
+	checks := append([]*check{}, p.checks...)
+	p.mu.RUnlock()
+
+	// checks are independent, so a slow one doesn't delay the rest
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = p.result(c)
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+// runs check unless its cached result is fresh
+func (p *probes) result(c *check) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start := p.now()
+	if c.cached && !c.result.CheckedAt.IsZero() && start.Sub(c.result.CheckedAt) < p.interval {
+		return c.result
+	}
+	err := c.run()
+	c.result.CheckedAt = start
+	c.result.LatencyMs = float64(p.now().Sub(start)) / float64(time.Millisecond)
+	c.result.Healthy, c.result.Error = err == nil, ""
+	if err != nil {
+		failedAt := start
+		c.result.Error, c.result.LastError, c.result.LastErrorAt = err.Error(), err.Error(), &failedAt
+	}
+	return c.result
+}
@@ -0,0 +1,74 @@
+package authrlib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/health"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbes(t *testing.T) {
+	ctx := &AppContext{Healthchecks: health.NewHealthCheckCollection(),
+		ConfigService: &configService{config: &Config{App: AppConfig{Health: HealthConfig{CheckInterval: time.Minute}}}}}
+	p := NewProbes(ctx).(*probes)
+	now := time.Date(2019, 12, 1, 10, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	var dbErr, keysErr error
+	dbRuns, keysRuns := 0, 0
+	p.AddCheck("CCNet", true, func() error {
+		dbRuns++
+		return dbErr
+	})
+	p.AddStateCheck("KeysServer", false, func() error {
+		keysRuns++
+		return keysErr
+	})
+
+	// all checks pass
+	results := p.Results()
+	assert.Equal(t, []CheckResult{
+		{Name: "CCNet", Critical: true, Healthy: true, CheckedAt: now},
+		{Name: "KeysServer", Healthy: true, CheckedAt: now},
+	}, results)
+	healthy, err := ctx.Healthchecks.IsHealthy()
+	assert.True(t, healthy)
+	assert.NoError(t, err)
+
+	// dependency check is cached for check interval, state check runs every time
+	dbErr, keysErr = errors.New("connection refused"), errors.New("timeout")
+	now = now.Add(30 * time.Second)
+	results = p.Results()
+	assert.True(t, results[0].Healthy)
+	assert.False(t, results[1].Healthy)
+	assert.Equal(t, 1, dbRuns)
+	assert.Equal(t, 3, keysRuns)
+	// failed non-critical check doesn't fail /health
+	healthy, err = ctx.Healthchecks.IsHealthy()
+	assert.True(t, healthy)
+
+	// failed critical check fails /health
+	now = now.Add(time.Minute)
+	failedAt := now
+	results = p.Results()
+	assert.Equal(t, CheckResult{Name: "CCNet", Critical: true, Error: "connection refused", CheckedAt: now,
+		LastError: "connection refused", LastErrorAt: &failedAt}, results[0])
+	healthy, err = ctx.Healthchecks.IsHealthy()
+	assert.False(t, healthy)
+	assert.EqualError(t, err, "connection refused")
+
+	// the last error is kept after recovery
+	dbErr = nil
+	now = now.Add(time.Minute)
+	results = p.Results()
+	assert.Equal(t, CheckResult{Name: "CCNet", Critical: true, Healthy: true, CheckedAt: now,
+		LastError: "connection refused", LastErrorAt: &failedAt}, results[0])
+	assert.Equal(t, 3, dbRuns)
+}
+
+func TestNewProbesDefaults(t *testing.T) {
+	p := NewProbes(&AppContext{ConfigService: &configService{config: &Config{}}}).(*probes)
+	assert.Equal(t, defaultCheckInterval, p.interval)
+}
@@ -0,0 +1,52 @@
+package authrlib
+
+import (
+	"os"
+	"time"
+)
+
+// WatchConfig reloads config on every received signal and when config file is modified until signals channel
+// is closed. The file is polled every interval rather than watched, so replaced files and symlinks, e.g.
+// kubernetes config maps, are noticed as well; zero interval disables polling
+func WatchConfig(ctx *AppContext, configFile string, signals <-chan os.Signal, interval time.Duration) {
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	version := fileVersion(configFile)
+	for {
+		select {
+		case _, ok := <-signals:
+			if !ok {
+				return
+			}
+		case <-ticks:
+			if fileVersion(configFile) == version {
+				continue
+			}
+		}
+		version = fileVersion(configFile)
+		reloadConfig(ctx)
+	}
+}
+
+func reloadConfig(ctx *AppContext) {
+	if err := ctx.ConfigService.Reload(); err != nil {
+		ctx.Logger.Error().Err(err).Msg("unable to reload config, keeping current one")
+		return
+	}
+	ctx.Logger.Info().Msg("config reloaded")
+}
+
+// modification time and size of file, which change on every write; zero value if file is missing
+func fileVersion(file string) (version struct {
+	modTime time.Time
+	size    int64
+}) {
+	if info, err := os.Stat(file); err == nil {
+		version.modTime, version.size = info.ModTime(), info.Size()
+	}
+	return version
+}
@@ -0,0 +1,68 @@
+package authrlib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cfg")
+	if err != nil {
+		t.Fatal("unable to create tmp dir for test", err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := dir + "/config.yml"
+	write := func(content string) {
+		if err := ioutil.WriteFile(configFile, []byte(content), 0644); err != nil {
+			t.Fatal("unable to write test data into file", err)
+		}
+	}
+	write(testCfg)
+
+	var buf bytes.Buffer
+	service := NewApplicationConfigService(&configFile)
+	ctx := &AppContext{Logger: &AppLogger{zerolog.New(zerolog.SyncWriter(&buf))}, ConfigService: service}
+	reloaded := make(chan *Config, 1)
+	service.Subscribe(func(_, new *Config) { reloaded <- new })
+
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		WatchConfig(ctx, configFile, signals, 10*time.Millisecond)
+		close(done)
+	}()
+	wait := func() *Config {
+		select {
+		case config := <-reloaded:
+			return config
+		case <-time.After(5 * time.Second):
+			t.Fatal("config isn't reloaded")
+			return nil
+		}
+	}
+
+	// signal reloads unmodified file, once it's received watcher is surely running
+	signals <- syscall.SIGHUP
+	assert.Equal(t, DefaultRolesConfig(), wait().Roles)
+
+	// modified file is reloaded
+	write(testCfg + "\nroles:\n  allowed-user-types: [1]\n")
+	assert.Equal(t, []int64{1}, wait().Roles.AllowedUserTypes)
+
+	// invalid file is rejected
+	write(testCfg + "\nroles:\n  allowed-user-types: []\n")
+	signals <- syscall.SIGHUP
+	close(signals)
+	<-done
+	assert.Equal(t, []int64{1}, service.Config().Roles.AllowedUserTypes)
+	assert.Contains(t, buf.String(), `"message":"config reloaded"`)
+	assert.Contains(t, buf.String(), `"error":"invalid configuration: roles.allowed-user-types: `+
+		`at least one user type should be allowed","message":"unable to reload config, keeping current one"`)
+}
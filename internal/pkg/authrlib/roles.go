@@ -0,0 +1,135 @@
+package authrlib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// names of roles which CCNET type IDs are mapped to
+const (
+	RoleTeacher          = "teacher"
+	RoleCoTeacher        = "co-teacher"
+	RoleAssistantTeacher = "assistant-teacher"
+	RoleAdmin            = "admin"
+	RoleVOAdmin          = "vo-admin"
+	RoleVONoChildAdmin   = "vo-no-child-admin"
+	RoleTeamMember       = "team-member"
+	RoleFSAdmin          = "fs-admin"
+	RoleFSVOAdmin        = "fs-vo-admin"
+)
+
+// RolesConfig maps CCNET type IDs to authorization.Access roles,
+// every group maps role name to type ID
+type RolesConfig struct {
+	// user types allowed to request permissions
+	AllowedUserTypes     []int64          `yaml:"allowed-user-types"`
+	UserTypes            map[string]int64 `yaml:"user-types"`
+	AdminTypes           map[string]int64 `yaml:"admin-types"`
+	TeacherTypes         map[string]int64 `yaml:"teacher-types"`
+	FundSourceAdminTypes map[string]int64 `yaml:"fund-source-admin-types"`
+}
+
+// DefaultRolesConfig returns mapping used by CCNET when roles section is not configured
+func DefaultRolesConfig() RolesConfig {
+	return RolesConfig{
+		AllowedUserTypes:     []int64{1, 3, 4, 5, 7},
+		UserTypes:            map[string]int64{RoleTeacher: 1, RoleAdmin: 3, RoleTeamMember: 5, RoleFSAdmin: 7},
+		AdminTypes:           map[string]int64{RoleAdmin: 0, RoleVOAdmin: 1, RoleVONoChildAdmin: 2},
+		TeacherTypes:         map[string]int64{RoleTeacher: 1, RoleCoTeacher: 2, RoleAssistantTeacher: 3},
+		FundSourceAdminTypes: map[string]int64{RoleFSAdmin: 0, RoleFSVOAdmin: 1},
+	}
+}
+
+// IsAllowed checks if user type may request permissions
+func (c RolesConfig) IsAllowed(userTypeID int64) bool {
+	for _, allowed := range c.AllowedUserTypes {
+		if allowed == userTypeID {
+			return true
+		}
+	}
+	return false
+}
+
+// fills groups which are not configured with defaults
+func (c *RolesConfig) setDefaults() {
+	defaults := DefaultRolesConfig()
+	if c.AllowedUserTypes == nil {
+		c.AllowedUserTypes = defaults.AllowedUserTypes
+	}
+	if c.UserTypes == nil {
+		c.UserTypes = defaults.UserTypes
+	}
+	if c.AdminTypes == nil {
+		c.AdminTypes = defaults.AdminTypes
+	}
+	if c.TeacherTypes == nil {
+		c.TeacherTypes = defaults.TeacherTypes
+	}
+	if c.FundSourceAdminTypes == nil {
+		c.FundSourceAdminTypes = defaults.FundSourceAdminTypes
+	}
+}
+
+// Validate checks that every group maps all of its roles to distinct type IDs
+// and returns all found problems at once
+func (c RolesConfig) Validate() error {
+	if problems := c.problems(); len(problems) > 0 {
+		return fmt.Errorf("invalid roles configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (c RolesConfig) problems() []string {
+	var problems []string
+	groups := []struct {
+		name    string
+		mapping map[string]int64
+		roles   []string
+	}{
+		{"user-types", c.UserTypes, []string{RoleTeacher, RoleAdmin, RoleTeamMember, RoleFSAdmin}},
+		{"admin-types", c.AdminTypes, []string{RoleAdmin, RoleVOAdmin, RoleVONoChildAdmin}},
+		{"teacher-types", c.TeacherTypes, []string{RoleTeacher, RoleCoTeacher, RoleAssistantTeacher}},
+		{"fund-source-admin-types", c.FundSourceAdminTypes, []string{RoleFSAdmin, RoleFSVOAdmin}},
+	}
+	for _, group := range groups {
+		known := make(map[string]struct{}, len(group.roles))
+		for _, role := range group.roles {
+			known[role] = struct{}{}
+			if _, ok := group.mapping[role]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: role %q is not mapped", group.name, role))
+			}
+		}
+		mapped := make([]string, 0, len(group.mapping))
+		for role := range group.mapping {
+			mapped = append(mapped, role)
+		}
+		sort.Strings(mapped)
+		byTypeID := make(map[int64]string, len(mapped))
+		for _, role := range mapped {
+			if _, ok := known[role]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown role %q, expected one of %s",
+					group.name, role, strings.Join(group.roles, ", ")))
+				continue
+			}
+			typeID := group.mapping[role]
+			if other, ok := byTypeID[typeID]; ok {
+				problems = append(problems, fmt.Sprintf("%s: roles %q and %q are mapped to the same type ID %d",
+					group.name, other, role, typeID))
+				continue
+			}
+			byTypeID[typeID] = role
+		}
+	}
+	if len(c.AllowedUserTypes) == 0 {
+		problems = append(problems, "allowed-user-types: at least one user type should be allowed")
+	}
+	seen := make(map[int64]struct{}, len(c.AllowedUserTypes))
+	for _, typeID := range c.AllowedUserTypes {
+		if _, ok := seen[typeID]; ok {
+			problems = append(problems, fmt.Sprintf("allowed-user-types: user type %d is listed twice", typeID))
+		}
+		seen[typeID] = struct{}{}
+	}
+	return problems
+}
@@ -0,0 +1,46 @@
+package authrlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultRolesConfig(t *testing.T) {
+	roles := DefaultRolesConfig()
+	assert.NoError(t, roles.Validate())
+	for _, userTypeID := range []int64{1, 3, 4, 5, 7} {
+		assert.True(t, roles.IsAllowed(userTypeID))
+	}
+	assert.False(t, roles.IsAllowed(6))
+}
+
+func TestRolesConfigSetDefaults(t *testing.T) {
+	roles := RolesConfig{AdminTypes: map[string]int64{RoleAdmin: 5, RoleVOAdmin: 6, RoleVONoChildAdmin: 7}}
+	roles.setDefaults()
+	defaults := DefaultRolesConfig()
+	assert.Equal(t, int64(5), roles.AdminTypes[RoleAdmin])
+	assert.Equal(t, defaults.UserTypes, roles.UserTypes)
+	assert.Equal(t, defaults.TeacherTypes, roles.TeacherTypes)
+	assert.Equal(t, defaults.FundSourceAdminTypes, roles.FundSourceAdminTypes)
+	assert.Equal(t, defaults.AllowedUserTypes, roles.AllowedUserTypes)
+}
+
+func TestRolesConfigValidate(t *testing.T) {
+	roles := DefaultRolesConfig()
+	roles.AllowedUserTypes = []int64{1, 1}
+	roles.UserTypes["super-admin"] = 9
+	delete(roles.TeacherTypes, RoleCoTeacher)
+	roles.FundSourceAdminTypes[RoleFSVOAdmin] = 0
+
+	err := roles.Validate()
+	assert.EqualError(t, err, "invalid roles configuration: "+
+		`user-types: unknown role "super-admin", expected one of teacher, admin, team-member, fs-admin; `+
+		`teacher-types: role "co-teacher" is not mapped; `+
+		`fund-source-admin-types: roles "fs-admin" and "fs-vo-admin" are mapped to the same type ID 0; `+
+		`allowed-user-types: user type 1 is listed twice`)
+
+	roles = DefaultRolesConfig()
+	roles.AllowedUserTypes = []int64{}
+	assert.EqualError(t, roles.Validate(), "invalid roles configuration: allowed-user-types: at least one user type should be allowed")
+}
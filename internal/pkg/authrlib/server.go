@@ -0,0 +1,126 @@
+package authrlib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// defaults of http server
+const (
+	defaultReadTimeout     = 15 * time.Second
+	defaultWriteTimeout    = time.Minute
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultDrainPeriod     = 15 * time.Second
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+var errShuttingDown = errors.New("service is shutting down")
+
+// Server serves http requests until it's stopped by signal, then shuts down gracefully
+type Server interface {
+	// OnShutdown registers function which releases a resource after server is shut down,
+	// functions are called in order of registration with context which expires at shutdown deadline
+	OnShutdown(name string, release func(ctx context.Context) error)
+	// Run serves requests until signal is received or server fails, resources are released in any case
+	Run(signals <-chan os.Signal) error
+}
+
+// impl of Server
+type server struct {
+	ctx         *AppContext
+	http        *http.Server
+	drainPeriod time.Duration
+	timeout     time.Duration
+	draining    int32 // set once signal is received, health check fails since then
+	resources   []resource
+}
+
+// named release function
+type resource struct {
+	name    string
+	release func(ctx context.Context) error
+}
+
+// NewServer creates server of handler configured in app.server section and registers readiness check
+// which fails while server is draining
+func NewServer(ctx *AppContext, handler http.Handler) Server {
+	conf := ctx.ConfigService.Config().App
+	s := &server{
+		ctx: ctx,
+		http: &http.Server{
+			Addr:         ":" + conf.PortToStr(),
+			Handler:      handler,
+			ReadTimeout:  durationOr(conf.Server.ReadTimeout, defaultReadTimeout),
+			WriteTimeout: durationOr(conf.Server.WriteTimeout, defaultWriteTimeout),
+			IdleTimeout:  durationOr(conf.Server.IdleTimeout, defaultIdleTimeout),
+		},
+		drainPeriod: durationOr(conf.Server.DrainPeriod, defaultDrainPeriod),
+		timeout:     durationOr(conf.Server.ShutdownTimeout, defaultShutdownTimeout),
+	}
+	ctx.Probes.AddStateCheck("Shutdown", true, func() error {
+		if atomic.LoadInt32(&s.draining) == 1 {
+			return errShuttingDown
+		}
+		return nil
+	})
+	return s
+}
+
+func (s *server) OnShutdown(name string, release func(ctx context.Context) error) {
+	s.resources = append(s.resources, resource{name, release})
+}
+
+func (s *server) Run(signals <-chan os.Signal) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.http.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		s.release(ctx)
+		return err
+	case sig := <-signals:
+		s.ctx.Logger.Info().Str("signal", sig.String()).Dur("drain_period", s.drainPeriod).Msg("shutting down")
+	}
+
+	// load balancer stops routing requests once health check fails, the next signal skips draining
+	atomic.StoreInt32(&s.draining, 1)
+	timer := time.NewTimer(s.drainPeriod)
+	select {
+	case <-timer.C:
+	case <-signals:
+		timer.Stop()
+	}
+
+	// in-flight requests and release of resources share the same deadline
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	err := s.http.Shutdown(ctx)
+	if err == nil {
+		s.ctx.Logger.Info().Msg("server is shut down")
+	}
+	s.release(ctx)
+	return err
+}
+
+// releases resources in order of registration, failure of one doesn't stop the rest
+func (s *server) release(ctx context.Context) {
+	for _, r := range s.resources {
+		if err := r.release(ctx); err != nil {
+			s.ctx.Logger.Error().Err(err).Str("resource", r.name).Msg("unable to release resource")
+		}
+	}
+}
+
+func durationOr(value, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+	return value
+}
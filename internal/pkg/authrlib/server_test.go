@@ -0,0 +1,129 @@
+package authrlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/health"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerRun(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("unable to find free port", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	var buf bytes.Buffer
+	ctx := &AppContext{Logger: &AppLogger{zerolog.New(zerolog.SyncWriter(&buf))}, Healthchecks: health.NewHealthCheckCollection(),
+		ConfigService: &configService{config: &Config{App: AppConfig{Port: port,
+			Server: ServerConfig{DrainPeriod: 100 * time.Millisecond, ShutdownTimeout: 5 * time.Second}}}}}
+	ctx.Probes = NewProbes(ctx)
+	started, finished := make(chan struct{}), make(chan struct{})
+	server := NewServer(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-finished
+		}
+	}))
+	var released []string
+	server.OnShutdown("first", func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+		released = append(released, "first")
+		return errors.New("first failed")
+	})
+	server.OnShutdown("second", func(context.Context) error {
+		released = append(released, "second")
+		return nil
+	})
+
+	signals := make(chan os.Signal, 1)
+	runErr := make(chan error, 1)
+	go func() { runErr <- server.Run(signals) }()
+	url := "http://127.0.0.1:" + strconv.Itoa(port)
+	for i := 0; ; i++ {
+		if _, err = http.Get(url + "/ping"); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal("server isn't started", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	healthy, _ := ctx.Healthchecks.IsHealthy()
+	assert.True(t, healthy)
+
+	// in-flight request is completed during shutdown
+	slowStatus := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			slowStatus <- 0
+			return
+		}
+		resp.Body.Close()
+		slowStatus <- resp.StatusCode
+	}()
+	<-started
+	signals <- syscall.SIGTERM
+	time.Sleep(50 * time.Millisecond)
+	healthy, err = ctx.Healthchecks.IsHealthy()
+	assert.False(t, healthy)
+	assert.Equal(t, errShuttingDown, err)
+	// requests are served while draining
+	_, err = http.Get(url + "/ping")
+	assert.NoError(t, err)
+
+	close(finished)
+	assert.Equal(t, http.StatusOK, <-slowStatus)
+	assert.NoError(t, <-runErr)
+	assert.Equal(t, []string{"first", "second"}, released)
+	assert.Contains(t, buf.String(), `"signal":"terminated","drain_period":100,"message":"shutting down"`)
+	assert.Contains(t, buf.String(), `"error":"first failed","resource":"first","message":"unable to release resource"`)
+	assert.Contains(t, buf.String(), `"message":"server is shut down"`)
+}
+
+func TestServerRunFails(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("unable to find free port", err)
+	}
+	defer listener.Close()
+
+	ctx := &AppContext{Logger: &AppLogger{zerolog.Nop()}, Healthchecks: health.NewHealthCheckCollection(),
+		ConfigService: &configService{config: &Config{App: AppConfig{Port: listener.Addr().(*net.TCPAddr).Port}}}}
+	ctx.Probes = NewProbes(ctx)
+	server := NewServer(ctx, http.NotFoundHandler())
+	released := false
+	server.OnShutdown("db", func(context.Context) error {
+		released = true
+		return nil
+	})
+	// port is in use, resources are released anyway
+	assert.Error(t, server.Run(make(chan os.Signal)))
+	assert.True(t, released)
+}
+
+func TestNewServerDefaults(t *testing.T) {
+	ctx := &AppContext{Healthchecks: health.NewHealthCheckCollection(),
+		ConfigService: &configService{config: &Config{App: AppConfig{Port: 8888, Server: ServerConfig{ReadTimeout: time.Second}}}}}
+	ctx.Probes = NewProbes(ctx)
+	s := NewServer(ctx, http.NotFoundHandler()).(*server)
+	assert.Equal(t, ":8888", s.http.Addr)
+	assert.Equal(t, time.Second, s.http.ReadTimeout)
+	assert.Equal(t, defaultWriteTimeout, s.http.WriteTimeout)
+	assert.Equal(t, defaultIdleTimeout, s.http.IdleTimeout)
+	assert.Equal(t, defaultDrainPeriod, s.drainPeriod)
+	assert.Equal(t, defaultShutdownTimeout, s.timeout)
+}
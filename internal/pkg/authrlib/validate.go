@@ -0,0 +1,199 @@
+package authrlib
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Envs lists supported values of app.env
+var Envs = []string{"local", "dev", "test", "qa", "staging", "production"}
+
+// ValidationError keeps all problems found in configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// collects problems of config fields, field names are yaml paths
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(field, format string, args ...interface{}) {
+	v.problems = append(v.problems, field+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.addf(field, "is required")
+	}
+}
+
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.addf(field, "unsupported value %q, expected one of %s", value, strings.Join(allowed, ", "))
+}
+
+func (v *validator) port(field string, port int) {
+	if port < 1 || port > 65535 {
+		v.addf(field, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+func (v *validator) nonNegative(field string, value int64) {
+	if value < 0 {
+		v.addf(field, "must not be negative, got %d", value)
+	}
+}
+
+func (v *validator) httpURL(field, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf(field, "must be http or https url, got %q", value)
+	}
+}
+
+// Validate checks required fields, url formats, port ranges and enum values,
+// all found problems are returned at once as *ValidationError
+func (c *Config) Validate() error {
+	v := &validator{}
+	c.App.validate(v)
+	if c.NewRelic.Enabled {
+		v.required("newrelic.apikey", c.NewRelic.APIKey)
+	}
+	c.MsSQL.validate(v)
+	c.Cache.validate(v)
+	v.nonNegative("hierarchy.max-depth", int64(c.Hierarchy.MaxDepth))
+	v.nonNegative("hierarchy.max-results", int64(c.Hierarchy.MaxResults))
+	for _, problem := range c.Roles.problems() {
+		v.problems = append(v.problems, "roles."+problem)
+	}
+	if c.History.Enabled {
+		v.oneOf("history.store", c.History.Store, "", HistoryStoreMemory, HistoryStoreRedis)
+		v.nonNegative("history.max-users", int64(c.History.MaxUsers))
+		if c.History.Store == HistoryStoreRedis && !c.Cache.Redis.Enabled {
+			v.required("cache.redis.address", c.Cache.Redis.Address)
+		}
+		if c.History.MaxPageSize > 0 && c.History.PageSize > c.History.MaxPageSize {
+			v.addf("history.page-size", "must not exceed max-page-size %d, got %d", c.History.MaxPageSize, c.History.PageSize)
+		}
+	}
+	if c.Audit.Enabled {
+		v.oneOf("audit.output", c.Audit.Output, "", AuditOutputStdout, AuditOutputFile)
+		if c.Audit.Output == AuditOutputFile {
+			v.required("audit.file.path", c.Audit.File.Path)
+		}
+		v.oneOf("audit.child-ids", c.Audit.ChildIDs, "", AuditRedactOmit, AuditRedactHash, AuditRedactNone)
+		if c.Audit.ChildIDs == AuditRedactHash {
+			v.required("audit.hash-key", c.Audit.HashKey)
+		}
+	}
+	if c.Webhooks.Enabled {
+		// last versions are shared by replicas and survive restarts, so every change is published once
+		if !c.History.Enabled || c.History.Store != HistoryStoreRedis {
+			v.addf("webhooks.enabled", "requires history.enabled with history.store %q", HistoryStoreRedis)
+		}
+		ids := make(map[string]bool, len(c.Webhooks.Subscriptions))
+		for i, subscription := range c.Webhooks.Subscriptions {
+			field := fmt.Sprintf("webhooks.subscriptions[%d]", i)
+			v.required(field+".id", subscription.ID)
+			if ids[subscription.ID] {
+				v.addf(field+".id", "%q is used twice", subscription.ID)
+			}
+			ids[subscription.ID] = true
+			v.httpURL(field+".url", subscription.URL)
+			v.required(field+".secret", subscription.Secret)
+		}
+	}
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (c AppConfig) validate(v *validator) {
+	v.required("app.name", c.Name)
+	v.oneOf("app.env", c.Env, Envs...)
+	v.port("app.port", c.Port)
+	v.httpURL("app.keys-server", c.KeysServer)
+	v.nonNegative("app.batch-limit", int64(c.BatchLimit))
+	v.nonNegative("app.token.ttl", int64(c.Token.TTL))
+	issuing := c.Token.PrivateKeyFile != "" || c.Token.KeysDir != "" || len(c.Token.Keys) > 0
+	if issuing {
+		v.required("app.token.key-id", c.Token.KeyID)
+	}
+	for i, key := range c.Token.Keys {
+		v.required(fmt.Sprintf("app.token.keys[%d].id", i), key.ID)
+		v.required(fmt.Sprintf("app.token.keys[%d].private-key-file", i), key.PrivateKeyFile)
+	}
+	// tokens issued by the service must pass its own verification
+	if issuing && len(c.Verify.Issuers) > 0 {
+		v.oneOf("app.token.issuer", c.Token.Issuer, c.Verify.Issuers...)
+	}
+	if issuing && len(c.Verify.Audiences) > 0 {
+		v.oneOf("app.token.audience", c.Token.Audience, c.Verify.Audiences...)
+	}
+	v.nonNegative("app.keys-cache.refresh-interval", int64(c.KeysCache.RefreshInterval))
+	v.nonNegative("app.keys-cache.timeout", int64(c.KeysCache.Timeout))
+	v.oneOf("app.log-level", c.LogLevel, append([]string{""}, LogLevels...)...)
+	v.nonNegative("app.config-watch-interval", int64(c.ConfigWatchInterval))
+	v.nonNegative("app.server.read-timeout", int64(c.Server.ReadTimeout))
+	v.nonNegative("app.server.write-timeout", int64(c.Server.WriteTimeout))
+	v.nonNegative("app.server.idle-timeout", int64(c.Server.IdleTimeout))
+	v.nonNegative("app.server.drain-period", int64(c.Server.DrainPeriod))
+	v.nonNegative("app.server.shutdown-timeout", int64(c.Server.ShutdownTimeout))
+	v.nonNegative("app.health.check-interval", int64(c.Health.CheckInterval))
+}
+
+func (c MsSQLConfig) validate(v *validator) {
+	v.oneOf("mssql.backend", c.Backend, "", BackendMSSQL, BackendPostgres, BackendFixture)
+	if c.DriverName() == BackendFixture {
+		v.required("mssql.fixtures-file", c.FixturesFile)
+		return
+	}
+	c.Connection.validate(v, "mssql.connection", true)
+	for i, replica := range c.Replicas {
+		replica.validate(v, fmt.Sprintf("mssql.replicas[%d]", i), false)
+	}
+	v.nonNegative("mssql.query-timeout", int64(c.QueryTimeout))
+	v.nonNegative("mssql.pool.max-open-conns", int64(c.Pool.MaxOpenConns))
+	v.nonNegative("mssql.pool.max-idle-conns", int64(c.Pool.MaxIdleConns))
+	if c.Pool.MaxOpenConns > 0 && c.Pool.MaxIdleConns > c.Pool.MaxOpenConns {
+		v.addf("mssql.pool.max-idle-conns", "must not exceed max-open-conns %d, got %d", c.Pool.MaxOpenConns, c.Pool.MaxIdleConns)
+	}
+	v.nonNegative("mssql.connect-retries", int64(c.ConnectRetries))
+	v.nonNegative("mssql.failover.max-failures", int64(c.Failover.MaxFailures))
+}
+
+// empty fields of replica are taken from primary
+func (c Connection) validate(v *validator, field string, primary bool) {
+	if primary {
+		v.required(field+".host", c.Host)
+		v.required(field+".database", c.Database)
+	}
+	if primary || c.Port != 0 {
+		v.port(field+".port", c.Port)
+	}
+	v.oneOf(field+".ssl-mode", c.SSLMode, "", "disable", "require", "verify-ca", "verify-full")
+}
+
+func (c CacheConfig) validate(v *validator) {
+	v.nonNegative("cache.ttl", int64(c.TTL))
+	v.nonNegative("cache.max-size", int64(c.MaxSize))
+	if c.Redis.Enabled {
+		// redis is a tier of access cache, it isn't used on its own
+		if !c.Enabled {
+			v.addf("cache.redis.enabled", "requires cache.enabled")
+		}
+		v.required("cache.redis.address", c.Redis.Address)
+		v.nonNegative("cache.redis.db", int64(c.Redis.DB))
+	}
+}
@@ -0,0 +1,127 @@
+package authrlib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// minimal config which passes validation
+func validConfig() *Config {
+	return &Config{
+		App:   AppConfig{Name: "authr", Env: "dev", Port: 8888, KeysServer: "https://keys.example.com/dev"},
+		MsSQL: MsSQLConfig{Connection: Connection{Host: "db", Port: 1433, Database: "CCNET"}},
+		Roles: DefaultRolesConfig(),
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		modify   func(*Config)
+		problems []string
+	}{
+		{name: "valid", modify: func(*Config) {}},
+		{name: "fixture backend needs no connection", modify: func(c *Config) {
+			c.MsSQL = MsSQLConfig{Backend: BackendFixture, FixturesFile: "fixtures.yml"}
+		}},
+		{name: "app", modify: func(c *Config) {
+			c.App = AppConfig{Env: "prod", Port: 70000, KeysServer: "ftp://keys", BatchLimit: -1,
+				Token: TokenConfig{TTL: -time.Second, Keys: []SigningKeyConfig{{}}}}
+		}, problems: []string{
+			"app.name: is required",
+			`app.env: unsupported value "prod", expected one of local, dev, test, qa, staging, production`,
+			"app.port: must be between 1 and 65535, got 70000",
+			`app.keys-server: must be http or https url, got "ftp://keys"`,
+			"app.batch-limit: must not be negative, got -1",
+			"app.token.ttl: must not be negative, got -1000000000",
+			"app.token.key-id: is required",
+			"app.token.keys[0].id: is required",
+			"app.token.keys[0].private-key-file: is required",
+		}},
+		{name: "issued tokens don't pass verification", modify: func(c *Config) {
+			c.App.Token = TokenConfig{Issuer: "authr", KeyID: "authr-1", PrivateKeyFile: "key.pem"}
+			c.App.Verify = VerifyConfig{Issuers: []string{"keys-server"}, Audiences: []string{"authr"}}
+		}, problems: []string{
+			`app.token.issuer: unsupported value "authr", expected one of keys-server`,
+			`app.token.audience: unsupported value "", expected one of authr`,
+		}},
+		{name: "newrelic enabled without key", modify: func(c *Config) { c.NewRelic.Enabled = true },
+			problems: []string{"newrelic.apikey: is required"}},
+		{name: "db", modify: func(c *Config) {
+			c.MsSQL.Backend = "oracle"
+			c.MsSQL.Connection = Connection{Port: 0, SSLMode: "on"}
+			c.MsSQL.Replicas = []Connection{{Host: "replica"}, {Port: -1}}
+			c.MsSQL.Pool = PoolConfig{MaxOpenConns: 5, MaxIdleConns: 10}
+		}, problems: []string{
+			`mssql.backend: unsupported value "oracle", expected one of , mssql, postgres, fixture`,
+			"mssql.connection.host: is required",
+			"mssql.connection.database: is required",
+			"mssql.connection.port: must be between 1 and 65535, got 0",
+			`mssql.connection.ssl-mode: unsupported value "on", expected one of , disable, require, verify-ca, verify-full`,
+			"mssql.replicas[1].port: must be between 1 and 65535, got -1",
+			"mssql.pool.max-idle-conns: must not exceed max-open-conns 5, got 10",
+		}},
+		{name: "fixture backend without file", modify: func(c *Config) { c.MsSQL.Backend = BackendFixture },
+			problems: []string{"mssql.fixtures-file: is required"}},
+		{name: "cache and history", modify: func(c *Config) {
+			c.Cache = CacheConfig{Enabled: true, TTL: -1, Redis: RedisConfig{Enabled: true}}
+			c.History = HistoryConfig{Enabled: true, Store: HistoryStoreRedis, PageSize: 50, MaxPageSize: 20}
+		}, problems: []string{
+			"cache.ttl: must not be negative, got -1",
+			"cache.redis.address: is required",
+			"history.page-size: must not exceed max-page-size 20, got 50",
+		}},
+		{name: "redis cache without cache", modify: func(c *Config) {
+			c.Cache = CacheConfig{Redis: RedisConfig{Enabled: true, Address: "redis:6379"}}
+		}, problems: []string{"cache.redis.enabled: requires cache.enabled"}},
+		{name: "redis history without redis cache", modify: func(c *Config) {
+			c.History = HistoryConfig{Enabled: true, Store: HistoryStoreRedis}
+		}, problems: []string{"cache.redis.address: is required"}},
+		{name: "audit", modify: func(c *Config) {
+			c.Audit = AuditConfig{Enabled: true, Output: AuditOutputFile, ChildIDs: AuditRedactHash}
+		}, problems: []string{"audit.file.path: is required", "audit.hash-key: is required"}},
+		{name: "webhooks", modify: func(c *Config) {
+			c.Webhooks = WebhooksConfig{Enabled: true, Subscriptions: []WebhookSubscription{
+				{ID: "reports", URL: "https://reports/hook", Secret: "s"},
+				{ID: "reports", URL: "reports/hook"},
+			}}
+		}, problems: []string{
+			`webhooks.enabled: requires history.enabled with history.store "redis"`,
+			`webhooks.subscriptions[1].id: "reports" is used twice`,
+			`webhooks.subscriptions[1].url: must be http or https url, got "reports/hook"`,
+			"webhooks.subscriptions[1].secret: is required",
+		}},
+		{name: "webhooks with redis history", modify: func(c *Config) {
+			c.Cache.Redis = RedisConfig{Address: "redis:6379"}
+			c.History = HistoryConfig{Enabled: true, Store: HistoryStoreRedis}
+			c.Webhooks = WebhooksConfig{Enabled: true, Subscriptions: []WebhookSubscription{
+				{ID: "reports", URL: "https://reports/hook", Secret: "s"},
+			}}
+		}},
+		{name: "roles", modify: func(c *Config) { c.Roles.AllowedUserTypes = nil },
+			problems: []string{"roles.allowed-user-types: at least one user type should be allowed"}},
+		{name: "disabled sections aren't validated", modify: func(c *Config) {
+			c.Audit = AuditConfig{Output: "kafka"}
+			c.Webhooks = WebhooksConfig{Subscriptions: []WebhookSubscription{{}}}
+		}},
+	}
+
+	for _, testCase := range testCases {
+		config := validConfig()
+		testCase.modify(config)
+		err := config.Validate()
+		if testCase.problems == nil {
+			assert.NoError(t, err, testCase.name)
+		} else if assert.IsType(t, &ValidationError{}, err, testCase.name) {
+			assert.Equal(t, testCase.problems, err.(*ValidationError).Problems, testCase.name)
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := &ValidationError{Problems: []string{"app.name: is required", "app.port: must be between 1 and 65535, got 0"}}
+	assert.EqualError(t, err, "invalid configuration: app.name: is required; app.port: must be between 1 and 65535, got 0")
+}
@@ -0,0 +1,129 @@
+package authrlib
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// reasons of rejected tokens with valid signature
+var (
+	errTokenNoExpiration = errors.New("token has no expiration")
+	errTokenIssuer       = errors.New("token issuer is not accepted")
+	errTokenAudience     = errors.New("token audience is not accepted")
+)
+
+// VerifyTokenMiddleware verifies bearer token offline by keys of the key set, requires its expiration,
+// checks its issuer and audience against verify and runs validators on its claims
+func VerifyTokenMiddleware(logger *AppLogger, keys KeySet, verify VerifyConfig, validators ...authorization.ClaimsValidator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := parseBearerToken(keys, verify, r)
+			if event := AuditEventFrom(r.Context()); event != nil && err == nil {
+				event.Sub, _ = claims["sub"].(string)
+				event.ClientID, _ = claims["client_id"].(string)
+			}
+			for _, validator := range validators {
+				if err != nil {
+					break
+				}
+				r, err = validator(claims, r)
+			}
+			if err != nil {
+				logger := logger.HandlerLogger(r)
+				logger.Warn().Err(err).Str("code", string(authrerr.From(err).Code)).Msg("token rejected")
+				if _, err = authrerr.Reply(w, err); err != nil {
+					logger.Warn().Err(err).Msg("unable to reply: token rejected")
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func parseBearerToken(keys KeySet, verify VerifyConfig, r *http.Request) (jwt.MapClaims, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return nil, authrerr.New(authrerr.TokenMissing, "bearer token not found")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(strings.TrimSpace(header[7:]), claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := keys.Key(kid)
+		if err != nil {
+			return nil, err
+		}
+		// signing method must match the key type, so alg header can't be forged
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+				return key, nil
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	})
+	if err == nil {
+		err = verifyClaims(claims, verify)
+	}
+	if err != nil {
+		return nil, authrerr.Wrap(err, authrerr.TokenInvalid, "invalid token")
+	}
+	return claims, nil
+}
+
+// checks claims which parser doesn't, exp is only checked by parser when it's present
+func verifyClaims(claims jwt.MapClaims, verify VerifyConfig) error {
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return errTokenNoExpiration
+	}
+	if issuer, _ := claims["iss"].(string); len(verify.Issuers) > 0 && !containsAny(verify.Issuers, issuer) {
+		return errTokenIssuer
+	}
+	if len(verify.Audiences) > 0 && !containsAny(verify.Audiences, audiences(claims)...) {
+		return errTokenAudience
+	}
+	return nil
+}
+
+// aud claim is either a string or an array of strings
+func audiences(claims jwt.MapClaims) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		result := make([]string, 0, len(aud))
+		for _, value := range aud {
+			if s, ok := value.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// whether any of values is one of accepted
+func containsAny(accepted []string, values ...string) bool {
+	for _, a := range accepted {
+		for _, value := range values {
+			if value == a {
+				return true
+			}
+		}
+	}
+	return false
+}
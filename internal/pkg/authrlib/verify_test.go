@@ -0,0 +1,134 @@
+package authrlib
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/authorization-service/internal/pkg/authrerr"
+	bootstraputils "bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type keySetMock struct{ mock.Mock }
+
+func (m *keySetMock) Key(kid string) (crypto.PublicKey, error) {
+	args := m.Called(kid)
+	return args.Get(0), args.Error(1)
+}
+func (m *keySetMock) LastError() error { return m.Called().Error(0) }
+
+func TestVerifyTokenMiddleware(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sign := func(method jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal("unable to sign token", err)
+		}
+		return "Bearer " + signed
+	}
+	// claims required by verification are added unless they are set
+	valid := func(claims jwt.MapClaims) jwt.MapClaims {
+		for name, value := range map[string]interface{}{"exp": time.Now().Add(time.Minute).Unix(), "iss": "keys-server", "aud": "authr"} {
+			if _, ok := claims[name]; !ok {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+	keys := &keySetMock{}
+	keys.On("Key", "rsa").Return(&rsaKey.PublicKey, nil)
+	keys.On("Key", "ec").Return(&ecKey.PublicKey, nil)
+	keys.On("Key", "unknown").Return(nil, errKeyNotFound)
+	validator := func(claims jwt.MapClaims, r *http.Request) (*http.Request, error) {
+		if claims["sub"] != "108" {
+			return r, authrerr.Wrap(errors.New("sub invalid"), authrerr.TokenSubMismatch, "token sub doesn't match user")
+		}
+		return r, nil
+	}
+
+	testCases := []struct {
+		name   string
+		header string
+		status int
+		body   string
+		log    string
+	}{
+		{name: "no token", status: http.StatusUnauthorized, body: `"code":"TOKEN_MISSING"`, log: "bearer token not found"},
+		{name: "not bearer", header: "Basic dXNlcjpwd2Q=", status: http.StatusUnauthorized, body: `"code":"TOKEN_MISSING"`, log: "bearer token not found"},
+		{name: "malformed token", header: "Bearer abc", status: http.StatusUnauthorized, body: `"code":"TOKEN_INVALID"`,
+			log: "invalid token: token contains an invalid number of segments"},
+		{name: "unknown kid", header: sign(jwt.SigningMethodRS256, "unknown", jwt.MapClaims{"sub": "108"}, rsaKey),
+			status: http.StatusUnauthorized, body: `"code":"TOKEN_INVALID"`, log: "invalid token: token signing key not found"},
+		{name: "wrong signature", header: sign(jwt.SigningMethodES256, "rsa", jwt.MapClaims{"sub": "108"}, ecKey),
+			status: http.StatusUnauthorized, body: `"code":"TOKEN_INVALID"`, log: "invalid token: unexpected signing method ES256"},
+		{name: "expired", header: sign(jwt.SigningMethodRS256, "rsa", valid(jwt.MapClaims{"sub": "108", "exp": time.Now().Add(-time.Minute).Unix()}), rsaKey),
+			status: http.StatusUnauthorized, body: `"code":"TOKEN_INVALID"`, log: "invalid token: Token is expired"},
+		{name: "no expiration", header: sign(jwt.SigningMethodRS256, "rsa", jwt.MapClaims{"sub": "108", "iss": "keys-server", "aud": "authr"}, rsaKey),
+			status: http.StatusUnauthorized, body: `"code":"TOKEN_INVALID"`, log: "invalid token: token has no expiration"},
+		{name: "wrong issuer", header: sign(jwt.SigningMethodRS256, "rsa", valid(jwt.MapClaims{"sub": "108", "iss": "other"}), rsaKey),
+			status: http.StatusUnauthorized, body: `"code":"TOKEN_INVALID"`, log: "invalid token: token issuer is not accepted"},
+		{name: "wrong audience", header: sign(jwt.SigningMethodRS256, "rsa", valid(jwt.MapClaims{"sub": "108", "aud": "other"}), rsaKey),
+			status: http.StatusUnauthorized, body: `"code":"TOKEN_INVALID"`, log: "invalid token: token audience is not accepted"},
+		{name: "claims rejected", header: sign(jwt.SigningMethodRS256, "rsa", valid(jwt.MapClaims{"sub": "1008"}), rsaKey),
+			status: http.StatusForbidden, body: `"code":"TOKEN_SUB_MISMATCH"`, log: "sub invalid"},
+		{name: "rsa token", header: sign(jwt.SigningMethodRS256, "rsa", valid(jwt.MapClaims{"sub": "108"}), rsaKey), status: http.StatusOK},
+		{name: "ecdsa token", header: sign(jwt.SigningMethodES256, "ec", valid(jwt.MapClaims{"sub": "108"}), ecKey), status: http.StatusOK},
+		{name: "audience list", header: sign(jwt.SigningMethodRS256, "rsa", valid(jwt.MapClaims{"sub": "108", "aud": []string{"other", "authr"}}), rsaKey),
+			status: http.StatusOK},
+	}
+
+	var buf bytes.Buffer
+	logger := &AppLogger{Logger: zerolog.New(&buf)}
+	verify := VerifyConfig{Issuers: []string{"authorization-service", "keys-server"}, Audiences: []string{"authr"}}
+	handler := VerifyTokenMiddleware(logger, keys, verify, validator)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, testCase := range testCases {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/test", nil)
+		txn := (*newrelicApp()).StartTransaction("/test", w, r)
+		r = r.WithContext(context.WithValue(r.Context(), bootstraputils.ContextKey("txn"), txn))
+		if testCase.header != "" {
+			r.Header.Set("Authorization", testCase.header)
+		}
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, testCase.status, w.Code, testCase.name)
+		assert.Contains(t, w.Body.String(), testCase.body, testCase.name)
+		assert.Contains(t, buf.String(), testCase.log, testCase.name)
+		t.Log("test case ok:", testCase.name)
+	}
+}
+
+func TestVerifyTokenMiddlewareAudit(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	keys := &keySetMock{}
+	keys.On("Key", "rsa").Return(&rsaKey.PublicKey, nil)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "108", "client_id": "portal", "exp": time.Now().Add(time.Minute).Unix()})
+	token.Header["kid"] = "rsa"
+	signed, _ := token.SignedString(rsaKey)
+
+	event := &AuditEvent{}
+	handler := VerifyTokenMiddleware(&AppLogger{Logger: zerolog.Nop()}, keys, VerifyConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/test", nil)
+	r = r.WithContext(WithAuditEvent(r.Context(), event))
+	r.Header.Set("Authorization", "Bearer "+signed)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "108", event.Sub)
+	assert.Equal(t, "portal", event.ClientID)
+}
@@ -0,0 +1,190 @@
+// Package permission decides whether a resolved authorization.Access allows
+// an action on a resource. Rules:
+//   - superuser is allowed everything
+//   - class: Teacher and CoTeacher may read and write, AssistantTeacher may read
+//   - child: TeamMember may read
+//   - entity: Admin and FSAdmin may read and write, VOAdmin, VONoChildAdmin and FSVOAdmin may read
+//   - fund source: FSAdmin may read and write, FSVOAdmin may read
+package permission
+
+import (
+	"errors"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+)
+
+// ResourceType is a kind of resource permission is checked for
+type ResourceType string
+
+// supported resource types
+const (
+	Class      ResourceType = "class"
+	Child      ResourceType = "child"
+	Entity     ResourceType = "entity"
+	FundSource ResourceType = "fundsource"
+)
+
+// Action is an operation on a resource
+type Action string
+
+// supported actions
+const (
+	Read  Action = "read"
+	Write Action = "write"
+)
+
+// names of roles which may grant permission, they match authorization.Access fields
+const (
+	RoleSuperUser        = "SuperUser"
+	RoleTeacher          = "Teacher"
+	RoleCoTeacher        = "CoTeacher"
+	RoleAssistantTeacher = "AssistantTeacher"
+	RoleTeamMember       = "TeamMember"
+	RoleAdmin            = "Admin"
+	RoleVOAdmin          = "VOAdmin"
+	RoleVONoChildAdmin   = "VONoChildAdmin"
+	RoleFSAdmin          = "FSAdmin"
+	RoleFSVOAdmin        = "FSVOAdmin"
+)
+
+var (
+	// ErrUnknownResourceType is returned for resource types not listed above
+	ErrUnknownResourceType = errors.New("unknown resource type")
+	// ErrUnknownAction is returned for actions not listed above
+	ErrUnknownAction = errors.New("unknown action")
+	// ErrInvalidResourceID is returned for non positive resource IDs
+	ErrInvalidResourceID = errors.New("invalid resource id")
+)
+
+// Request describes permission to check
+type Request struct {
+	ResourceType ResourceType `json:"resourceType"`
+	ResourceID   int64        `json:"resourceId"`
+	Action       Action       `json:"action"`
+}
+
+// Decision is a result of evaluation, Role is set when permission is granted
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Role    string `json:"role,omitempty"`
+}
+
+// Validate checks that request contains supported values
+func (r Request) Validate() error {
+	if _, ok := grants[r.ResourceType]; !ok {
+		return ErrUnknownResourceType
+	}
+	if r.Action != Read && r.Action != Write {
+		return ErrUnknownAction
+	}
+	if r.ResourceID <= 0 {
+		return ErrInvalidResourceID
+	}
+	return nil
+}
+
+// a role which grants actions on resources listed by ids
+type grant struct {
+	role    string
+	actions []Action
+	ids     func(*authorization.Access) []int64
+}
+
+var readWrite, readOnly = []Action{Read, Write}, []Action{Read}
+
+// grants by resource type, ordered from the most to the least privileged role
+var grants = map[ResourceType][]grant{
+	Class: {
+		{RoleTeacher, readWrite, func(a *authorization.Access) []int64 { return TeacherClasses(a.Teacher) }},
+		{RoleCoTeacher, readWrite, func(a *authorization.Access) []int64 { return TeacherClasses(a.CoTeacher) }},
+		{RoleAssistantTeacher, readOnly, func(a *authorization.Access) []int64 { return TeacherClasses(a.AssistantTeacher) }},
+	},
+	Child: {
+		{RoleTeamMember, readOnly, func(a *authorization.Access) []int64 {
+			if a.TeamMember == nil {
+				return nil
+			}
+			return a.TeamMember.Kid
+		}},
+	},
+	Entity: {
+		{RoleAdmin, readWrite, func(a *authorization.Access) []int64 { return AdminEntities(a.Admin) }},
+		{RoleFSAdmin, readWrite, func(a *authorization.Access) []int64 { return FSAdminEntities(a.FSAdmin) }},
+		{RoleVOAdmin, readOnly, func(a *authorization.Access) []int64 { return AdminEntities(a.VOAdmin) }},
+		{RoleVONoChildAdmin, readOnly, func(a *authorization.Access) []int64 { return AdminEntities(a.VONoChildAdmin) }},
+		{RoleFSVOAdmin, readOnly, func(a *authorization.Access) []int64 { return FSAdminEntities(a.FSVOAdmin) }},
+	},
+	FundSource: {
+		{RoleFSAdmin, readWrite, func(a *authorization.Access) []int64 { return FundSources(a.FSAdmin) }},
+		{RoleFSVOAdmin, readOnly, func(a *authorization.Access) []int64 { return FundSources(a.FSVOAdmin) }},
+	},
+}
+
+// Evaluate checks request against access and returns the first role which grants it
+func Evaluate(access *authorization.Access, req Request) (Decision, error) {
+	if err := req.Validate(); err != nil {
+		return Decision{}, err
+	}
+	if access == nil {
+		return Decision{}, nil
+	}
+	if access.SuperUser {
+		return Decision{Allowed: true, Role: RoleSuperUser}, nil
+	}
+	for _, g := range grants[req.ResourceType] {
+		if containsAction(g.actions, req.Action) && containsID(g.ids(access), req.ResourceID) {
+			return Decision{Allowed: true, Role: g.role}, nil
+		}
+	}
+	return Decision{}, nil
+}
+
+// TeacherClasses returns classes of teacher role, nil if the role is absent
+func TeacherClasses(t *authorization.TeacherType) []int64 {
+	if t == nil {
+		return nil
+	}
+	return t.Cls
+}
+
+// AdminEntities returns entities of admin role, nil if the role is absent
+func AdminEntities(a *authorization.AdminType) []int64 {
+	if a == nil {
+		return nil
+	}
+	return a.Ent
+}
+
+// FSAdminEntities returns entities of fund source admin role, nil if the role is absent
+func FSAdminEntities(a *authorization.FsAdminType) []int64 {
+	if a == nil {
+		return nil
+	}
+	return a.Ent
+}
+
+// FundSources returns fund sources of fund source admin role, nil if the role is absent
+func FundSources(a *authorization.FsAdminType) []int64 {
+	if a == nil {
+		return nil
+	}
+	return a.FundSrc
+}
+
+func containsAction(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package permission
+
+import (
+	"testing"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/authorization"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert.Equal(t, ErrUnknownResourceType, Request{ResourceType: "site", ResourceID: 1, Action: Read}.Validate())
+	assert.Equal(t, ErrUnknownAction, Request{ResourceType: Class, ResourceID: 1, Action: "delete"}.Validate())
+	assert.Equal(t, ErrInvalidResourceID, Request{ResourceType: Class, ResourceID: 0, Action: Read}.Validate())
+	assert.NoError(t, Request{ResourceType: FundSource, ResourceID: 1, Action: Write}.Validate())
+}
+
+func TestEvaluate(t *testing.T) {
+	access := &authorization.Access{
+		Teacher:          &authorization.TeacherType{Cls: []int64{1}},
+		CoTeacher:        &authorization.TeacherType{Cls: []int64{2}},
+		AssistantTeacher: &authorization.TeacherType{Cls: []int64{3, 1}},
+		TeamMember:       &authorization.TeamMemberType{Kid: []int64{10}},
+		Admin:            &authorization.AdminType{Ent: []int64{100}},
+		VOAdmin:          &authorization.AdminType{Ent: []int64{101}},
+		VONoChildAdmin:   &authorization.AdminType{Ent: []int64{102}},
+		FSAdmin:          &authorization.FsAdminType{AdminType: authorization.AdminType{Ent: []int64{103}}, FundSrc: []int64{1000}},
+		FSVOAdmin:        &authorization.FsAdminType{AdminType: authorization.AdminType{Ent: []int64{104}}, FundSrc: []int64{1001}},
+	}
+
+	testCases := []struct {
+		name     string
+		access   *authorization.Access
+		req      Request
+		decision Decision
+		err      error
+	}{
+		{name: "invalid request", access: access, req: Request{ResourceType: "site", ResourceID: 1, Action: Read}, err: ErrUnknownResourceType},
+		{name: "no access", access: nil, req: Request{Class, 1, Read}},
+		{name: "superuser", access: &authorization.Access{SuperUser: true}, req: Request{FundSource, 5, Write}, decision: Decision{true, RoleSuperUser}},
+		{name: "teacher writes class", access: access, req: Request{Class, 1, Write}, decision: Decision{true, RoleTeacher}},
+		{name: "teacher wins over assistant", access: access, req: Request{Class, 1, Read}, decision: Decision{true, RoleTeacher}},
+		{name: "coteacher writes class", access: access, req: Request{Class, 2, Write}, decision: Decision{true, RoleCoTeacher}},
+		{name: "assistant reads class", access: access, req: Request{Class, 3, Read}, decision: Decision{true, RoleAssistantTeacher}},
+		{name: "assistant can't write class", access: access, req: Request{Class, 3, Write}},
+		{name: "unknown class", access: access, req: Request{Class, 4, Read}},
+		{name: "team member reads child", access: access, req: Request{Child, 10, Read}, decision: Decision{true, RoleTeamMember}},
+		{name: "team member can't write child", access: access, req: Request{Child, 10, Write}},
+		{name: "admin writes entity", access: access, req: Request{Entity, 100, Write}, decision: Decision{true, RoleAdmin}},
+		{name: "voadmin reads entity", access: access, req: Request{Entity, 101, Read}, decision: Decision{true, RoleVOAdmin}},
+		{name: "voadmin can't write entity", access: access, req: Request{Entity, 101, Write}},
+		{name: "vonochildadmin reads entity", access: access, req: Request{Entity, 102, Read}, decision: Decision{true, RoleVONoChildAdmin}},
+		{name: "fsadmin writes entity", access: access, req: Request{Entity, 103, Write}, decision: Decision{true, RoleFSAdmin}},
+		{name: "fsvoadmin reads entity", access: access, req: Request{Entity, 104, Read}, decision: Decision{true, RoleFSVOAdmin}},
+		{name: "fsadmin writes fund source", access: access, req: Request{FundSource, 1000, Write}, decision: Decision{true, RoleFSAdmin}},
+		{name: "fsvoadmin reads fund source", access: access, req: Request{FundSource, 1001, Read}, decision: Decision{true, RoleFSVOAdmin}},
+		{name: "fsvoadmin can't write fund source", access: access, req: Request{FundSource, 1001, Write}},
+		{name: "empty access", access: &authorization.Access{}, req: Request{Entity, 100, Read}},
+	}
+
+	for _, testCase := range testCases {
+		decision, err := Evaluate(testCase.access, testCase.req)
+		if result := assert.Equal(t, testCase.err, err) && assert.Equal(t, testCase.decision, decision); !result {
+			t.Error("test case failed:", testCase.name)
+			continue
+		}
+		t.Log("test case ok:", testCase.name)
+	}
+}